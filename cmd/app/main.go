@@ -19,12 +19,12 @@ import (
 	"remnawave-tg-shop-bot/internal/handler"
 	"remnawave-tg-shop-bot/internal/notification"
 	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/internal/promo"
 	"remnawave-tg-shop-bot/internal/remnawave"
 	"remnawave-tg-shop-bot/internal/sync"
 	"remnawave-tg-shop-bot/internal/translation"
 	"remnawave-tg-shop-bot/internal/tribute"
 	"remnawave-tg-shop-bot/internal/yookasa"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -50,36 +50,91 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	cache := cache.NewCache(30 * time.Minute)
+	cache := cache.NewCache(config.InvoiceTTL() + 30*time.Minute)
 	customerRepository := database.NewCustomerRepository(pool)
 	purchaseRepository := database.NewPurchaseRepository(pool)
 	referralRepository := database.NewReferralRepository(pool)
+	promoCodeRepository := database.NewPromoCodeRepository(pool)
+	tariffRepository := database.NewTariffRepository(pool)
+	balanceRepository := database.NewBalanceRepository(pool)
+	giftRepository := database.NewGiftRepository(pool)
+	provisioningJobRepository := database.NewProvisioningJobRepository(pool)
+
+	err = seedTariffs(ctx, tariffRepository, tm)
+	if err != nil {
+		panic(err)
+	}
 
 	cryptoPayClient := cryptopay.NewCryptoPayClient(config.CryptoPayUrl(), config.CryptoPayToken())
-	remnawaveClient := remnawave.NewClient(config.RemnawaveUrl(), config.RemnawaveToken(), config.RemnawaveMode())
+	panels := remnawave.NewPanels()
+	for _, panel := range config.Panels() {
+		panels.Add(panel.Name, remnawave.NewPanelClient(panel))
+	}
 	yookasaClient := yookasa.NewClient(config.YookasaUrl(), config.YookasaShopId(), config.YookasaSecretKey())
 	b, err := bot.New(config.TelegramToken(), bot.WithWorkers(3))
 	if err != nil {
 		panic(err)
 	}
 
-	paymentService := payment.NewPaymentService(tm, purchaseRepository, remnawaveClient, customerRepository, b, cryptoPayClient, yookasaClient, referralRepository, cache)
+	providers := payment.NewRegistry()
+	paymentService := payment.NewPaymentService(tm, purchaseRepository, panels, customerRepository, b, providers, referralRepository, cache, tariffRepository, balanceRepository, giftRepository, provisioningJobRepository)
+
+	if config.IsBalanceEnabled() {
+		providers.Register(payment.NewBalanceProvider(balanceRepository))
+	}
+	if config.IsCryptoPayEnabled() {
+		providers.Register(cryptopay.NewProvider(cryptoPayClient, tm))
+	}
+	if config.IsYookasaEnabled() {
+		providers.Register(yookasa.NewProvider(yookasaClient, tm))
+	}
+	if config.IsTelegramStarsEnabled() {
+		providers.Register(payment.NewTelegramProvider(b, tm))
+	}
+	if config.IsManualPaymentEnabled() {
+		providers.Register(payment.NewManualProvider())
+	}
+	if config.GetTributeWebHookUrl() != "" {
+		providers.Register(tribute.NewClient(customerRepository, purchaseRepository))
+	}
 
-	cronScheduler := setupInvoiceChecker(purchaseRepository, cryptoPayClient, paymentService, yookasaClient)
+	cronScheduler := setupInvoiceChecker(providers, paymentService)
 	if cronScheduler != nil {
 		cronScheduler.Start()
 		defer cronScheduler.Stop()
 	}
 
+	expiryCronScheduler := setupInvoiceExpiry(paymentService)
+	expiryCronScheduler.Start()
+	defer expiryCronScheduler.Stop()
+
+	provisioningCronScheduler := setupProvisioning(paymentService)
+	provisioningCronScheduler.Start()
+	defer provisioningCronScheduler.Stop()
+
+	if config.IsAutoPaymentEnabled() {
+		renewalCronScheduler := setupAutoRenewal(paymentService)
+		renewalCronScheduler.Start()
+		defer renewalCronScheduler.Stop()
+	}
+
 	subService := notification.NewSubscriptionService(customerRepository, b, tm)
 
 	subscriptionNotificationCronScheduler := setupSubscriptionNotifier(subService)
 	subscriptionNotificationCronScheduler.Start()
 	defer subscriptionNotificationCronScheduler.Stop()
 
-	syncService := sync.NewSyncService(remnawaveClient, customerRepository)
+	syncService := sync.NewSyncService(panels, customerRepository)
 
-	h := handler.NewHandler(syncService, paymentService, tm, customerRepository, purchaseRepository, cryptoPayClient, yookasaClient, referralRepository, cache)
+	promoService := promo.NewService(promoCodeRepository)
+
+	h := handler.NewHandler(syncService, paymentService, tm, customerRepository, purchaseRepository, cryptoPayClient, yookasaClient, referralRepository, cache, promoService, promoCodeRepository, tariffRepository, giftRepository)
+
+	if config.IsReconciliationEnabled() {
+		reconciliationCronScheduler := setupReconciliation(h, b)
+		reconciliationCronScheduler.Start()
+		defer reconciliationCronScheduler.Stop()
+	}
 
 	me, err := b.GetMe(ctx)
 	if err != nil {
@@ -114,6 +169,14 @@ func main() {
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/start", bot.MatchTypePrefix, h.StartCommandHandler)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/connect", bot.MatchTypeExact, h.ConnectCommandHandler, h.CreateCustomerIfNotExistMiddleware)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/sync", bot.MatchTypeExact, h.SyncUsersCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/refund", bot.MatchTypePrefix, h.RefundCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/promo_create", bot.MatchTypePrefix, h.PromoCreateCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/promo_list", bot.MatchTypeExact, h.PromoListCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/tariff_add", bot.MatchTypePrefix, h.TariffAddCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/tariff_set", bot.MatchTypePrefix, h.TariffSetCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/tariff_list", bot.MatchTypeExact, h.TariffListCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/gift_list", bot.MatchTypeExact, h.GiftListCommandHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/reconcile", bot.MatchTypePrefix, h.ReconcileCommandHandler, isAdminMiddleware)
 
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackReferral, bot.MatchTypeExact, h.ReferralCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackBuy, bot.MatchTypeExact, h.BuyCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
@@ -122,7 +185,18 @@ func main() {
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackStart, bot.MatchTypeExact, h.StartCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackSell, bot.MatchTypePrefix, h.SellCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackConnect, bot.MatchTypeExact, h.ConnectCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackAutoRenewOff, bot.MatchTypeExact, h.AutoRenewOffCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackStarsCancel, bot.MatchTypeExact, h.StarsCancelCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackPayment, bot.MatchTypePrefix, h.PaymentCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackPromo, bot.MatchTypePrefix, h.PromoCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackCurrency, bot.MatchTypePrefix, h.CurrencyCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackLocation, bot.MatchTypePrefix, h.LocationCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackTraffic, bot.MatchTypePrefix, h.TrafficCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackTrafficPay, bot.MatchTypePrefix, h.TrafficPaymentCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackDeviceTiers, bot.MatchTypePrefix, h.DeviceTiersCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackDevicesPay, bot.MatchTypePrefix, h.DeviceUpgradeCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackReprocess, bot.MatchTypePrefix, h.ReprocessCallbackHandler, isAdminMiddleware)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackProvisioningRetry, bot.MatchTypePrefix, h.ProvisioningRetryCallbackHandler, isAdminMiddleware)
 	b.RegisterHandlerMatchFunc(func(update *models.Update) bool {
 		return update.PreCheckoutQuery != nil
 	}, h.PreCheckoutCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
@@ -131,11 +205,28 @@ func main() {
 		return update.Message != nil && update.Message.SuccessfulPayment != nil
 	}, h.SuccessPaymentHandler)
 
+	b.RegisterHandlerMatchFunc(h.IsPromoCodeInput, h.PromoCodeMessageHandler, h.CreateCustomerIfNotExistMiddleware)
+	b.RegisterHandlerMatchFunc(h.IsReceiptContactInput, h.ReceiptContactMessageHandler, h.CreateCustomerIfNotExistMiddleware)
+
+	if config.IsBalanceEnabled() {
+		b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackBalance, bot.MatchTypeExact, h.BalanceCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+		b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackTopUp, bot.MatchTypePrefix, h.TopUpCallbackHandler, h.CreateCustomerIfNotExistMiddleware)
+		b.RegisterHandlerMatchFunc(h.IsTopUpAmountInput, h.TopUpAmountMessageHandler, h.CreateCustomerIfNotExistMiddleware)
+	}
+
+	if config.IsManualPaymentEnabled() {
+		b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackManualApprove, bot.MatchTypePrefix, h.ManualApproveCallbackHandler, isAdminMiddleware)
+		b.RegisterHandler(bot.HandlerTypeCallbackQueryData, handler.CallbackManualReject, bot.MatchTypePrefix, h.ManualRejectCallbackHandler, isAdminMiddleware)
+		b.RegisterHandlerMatchFunc(h.IsManualReceiptInput, h.ManualReceiptMessageHandler, h.CreateCustomerIfNotExistMiddleware)
+		b.RegisterHandlerMatchFunc(h.IsRejectReasonInput, h.RejectReasonMessageHandler, isAdminMiddleware)
+	}
+
 	mux := http.NewServeMux()
-	mux.Handle("/healthcheck", fullHealthHandler(pool, remnawaveClient))
-	if config.GetTributeWebHookUrl() != "" {
-		tributeHandler := tribute.NewClient(paymentService, customerRepository)
-		mux.Handle(config.GetTributeWebHookUrl(), tributeHandler.WebHookHandler())
+	mux.Handle("/healthcheck", fullHealthHandler(pool, panels))
+	for _, provider := range providers.All() {
+		if webhook, ok := provider.(payment.WebhookProvider); ok && webhook.WebhookPath() != "" {
+			mux.Handle(webhook.WebhookPath(), webhook.WebhookHandler(paymentService))
+		}
 	}
 
 	srv := &http.Server{
@@ -160,7 +251,7 @@ func main() {
 	}
 }
 
-func fullHealthHandler(pool *pgxpool.Pool, rw *remnawave.Client) http.Handler {
+func fullHealthHandler(pool *pgxpool.Pool, panels *remnawave.Panels) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		status := map[string]string{
 			"status": "ok",
@@ -179,10 +270,17 @@ func fullHealthHandler(pool *pgxpool.Pool, rw *remnawave.Client) http.Handler {
 
 		rwCtx, rwCancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer rwCancel()
-		if err := rw.Ping(rwCtx); err != nil {
+		var rwErrors []string
+		for _, name := range panels.Names() {
+			panel := name
+			if err := panels.Get(&panel).Ping(rwCtx); err != nil {
+				rwErrors = append(rwErrors, panel+": "+err.Error())
+			}
+		}
+		if len(rwErrors) > 0 {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			status["status"] = "fail"
-			status["rw"] = "error: " + err.Error()
+			status["rw"] = "error: " + strings.Join(rwErrors, "; ")
 		}
 
 		if status["status"] == "ok" {
@@ -199,6 +297,8 @@ func isAdminMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
 	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
 		if update.Message != nil && update.Message.From.ID == config.GetAdminTelegramId() {
 			next(ctx, b, update)
+		} else if update.CallbackQuery != nil && update.CallbackQuery.From.ID == config.GetAdminTelegramId() {
+			next(ctx, b, update)
 		} else {
 			return
 		}
@@ -223,159 +323,140 @@ func setupSubscriptionNotifier(subService *notification.SubscriptionService) *cr
 	return c
 }
 
-func initDatabase(ctx context.Context, connString string) (*pgxpool.Pool, error) {
-	config, err := pgxpool.ParseConfig(connString)
+func setupInvoiceExpiry(paymentService *payment.PaymentService) *cron.Cron {
+	c := cron.New()
+
+	_, err := c.AddFunc("* * * * *", func() {
+		paymentService.ExpirePurchases(context.Background())
+	})
+
 	if err != nil {
-		return nil, err
+		panic(err)
 	}
+	return c
+}
 
-	config.MaxConns = 20
-	config.MinConns = 5
+func setupProvisioning(paymentService *payment.PaymentService) *cron.Cron {
+	c := cron.New()
 
-	return pgxpool.ConnectConfig(ctx, config)
-}
+	_, err := c.AddFunc("* * * * *", func() {
+		paymentService.ProcessProvisioningJobs(context.Background())
+	})
 
-func setupInvoiceChecker(
-	purchaseRepository *database.PurchaseRepository,
-	cryptoPayClient *cryptopay.Client,
-	paymentService *payment.PaymentService,
-	yookasaClient *yookasa.Client) *cron.Cron {
-	if !config.IsYookasaEnabled() && !config.IsCryptoPayEnabled() {
-		return nil
+	if err != nil {
+		panic(err)
 	}
-	c := cron.New(cron.WithSeconds())
+	return c
+}
 
-	if config.IsCryptoPayEnabled() {
-		_, err := c.AddFunc("*/5 * * * * *", func() {
-			ctx := context.Background()
-			checkCryptoPayInvoice(ctx, purchaseRepository, cryptoPayClient, paymentService)
-		})
+func setupAutoRenewal(paymentService *payment.PaymentService) *cron.Cron {
+	c := cron.New()
+
+	_, err := c.AddFunc("0 12 * * *", func() {
+		slog.Info("Running subscription auto renewal")
 
+		err := paymentService.RenewSubscriptions(context.Background())
 		if err != nil {
-			panic(err)
+			slog.Error("Error renewing subscriptions", "error", err)
 		}
+	})
+
+	if err != nil {
+		panic(err)
 	}
+	return c
+}
 
-	if config.IsYookasaEnabled() {
-		_, err := c.AddFunc("*/5 * * * * *", func() {
-			ctx := context.Background()
-			checkYookasaInvoice(ctx, purchaseRepository, yookasaClient, paymentService)
-		})
+func setupReconciliation(h *handler.Handler, b *bot.Bot) *cron.Cron {
+	c := cron.New()
 
-		if err != nil {
-			panic(err)
-		}
-	}
+	_, err := c.AddFunc("0 9 * * *", func() {
+		slog.Info("Running payment reconciliation")
+		h.SendReconciliationReport(context.Background(), b, config.GetAdminTelegramId(), config.ReconciliationDays(), true)
+	})
 
+	if err != nil {
+		panic(err)
+	}
 	return c
 }
 
-func checkYookasaInvoice(
-	ctx context.Context,
-	purchaseRepository *database.PurchaseRepository,
-	yookasaClient *yookasa.Client,
-	paymentService *payment.PaymentService,
-) {
-	pendingPurchases, err := purchaseRepository.FindByInvoiceTypeAndStatus(
-		ctx,
-		database.InvoiceTypeYookasa,
-		database.PurchaseStatusPending,
-	)
+// seedTariffs creates the tariffs of PRICE_<n> and STARS_PRICE_<n> on the first start,
+// afterwards the catalog is managed with the /tariff_* commands.
+func seedTariffs(ctx context.Context, tariffRepository *database.TariffRepository, tm *translation.Manager) error {
+	tariffs, err := tariffRepository.FindAll(ctx)
 	if err != nil {
-		log.Printf("Error finding pending purchases: %v", err)
-		return
+		return err
 	}
-	if len(*pendingPurchases) == 0 {
-		return
+	if len(tariffs) > 0 {
+		return nil
 	}
 
-	for _, purchase := range *pendingPurchases {
-
-		invoice, err := yookasaClient.GetPayment(ctx, *purchase.YookasaID)
-
-		if err != nil {
-			slog.Error("Error getting invoice", "invoiceId", purchase.YookasaID, err)
-			continue
-		}
-
-		if invoice.IsCancelled() {
-			err := paymentService.CancelPayment(purchase.ID)
-			if err != nil {
-				slog.Error("Error canceling invoice", "invoiceId", invoice.ID, "purchaseId", purchase.ID, err)
-			}
+	for i, month := range []int{1, 3, 6, 12} {
+		price := config.Price(month)
+		if price <= 0 {
 			continue
 		}
 
-		if !invoice.Paid {
-			continue
+		prices := map[string]int{payment.CurrencyRUB: price}
+		if config.IsTelegramStarsEnabled() {
+			prices[payment.CurrencyStars] = config.StarsPrice(month)
 		}
 
-		purchaseId, err := strconv.Atoi(invoice.Metadata["purchaseId"])
-		if err != nil {
-			slog.Error("Error parsing purchaseId", "invoiceId", invoice.ID, err)
-		}
-		ctxWithValue := context.WithValue(ctx, "username", invoice.Metadata["username"])
-		err = paymentService.ProcessPurchaseById(ctxWithValue, int64(purchaseId))
+		nameKey := fmt.Sprintf("month_%d", month)
+		_, err := tariffRepository.Create(ctx, &database.Tariff{
+			Name:            map[string]string{"en": tm.GetText("en", nameKey), "ru": tm.GetText("ru", nameKey)},
+			DurationDays:    month * 30,
+			TrafficLimitGB:  config.TrafficLimitGb(),
+			TrafficStrategy: "MONTH",
+			Prices:          prices,
+			SortOrder:       i,
+			Active:          true,
+		})
 		if err != nil {
-			slog.Error("Error processing invoice", "invoiceId", invoice.ID, "purchaseId", purchaseId, err)
-		} else {
-			slog.Info("Invoice processed", "invoiceId", invoice.ID, "purchaseId", purchaseId)
+			return err
 		}
-
 	}
+
+	slog.Info("tariffs seeded from config")
+	return nil
 }
 
-func checkCryptoPayInvoice(
-	ctx context.Context,
-	purchaseRepository *database.PurchaseRepository,
-	cryptoPayClient *cryptopay.Client,
-	paymentService *payment.PaymentService,
-) {
-	pendingPurchases, err := purchaseRepository.FindByInvoiceTypeAndStatus(
-		ctx,
-		database.InvoiceTypeCrypto,
-		database.PurchaseStatusPending,
-	)
+func initDatabase(ctx context.Context, connString string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connString)
 	if err != nil {
-		log.Printf("Error finding pending purchases: %v", err)
-		return
-	}
-	if len(*pendingPurchases) == 0 {
-		return
+		return nil, err
 	}
 
-	var invoiceIDs []string
+	config.MaxConns = 20
+	config.MinConns = 5
 
-	for _, purchase := range *pendingPurchases {
-		if purchase.CryptoInvoiceID != nil {
-			invoiceIDs = append(invoiceIDs, fmt.Sprintf("%d", *purchase.CryptoInvoiceID))
-		}
-	}
+	return pgxpool.ConnectConfig(ctx, config)
+}
 
-	if len(invoiceIDs) == 0 {
-		return
-	}
+func setupInvoiceChecker(providers *payment.Registry, paymentService *payment.PaymentService) *cron.Cron {
+	c := cron.New(cron.WithSeconds())
+	scheduled := 0
 
-	stringInvoiceIDs := strings.Join(invoiceIDs, ",")
-	invoices, err := cryptoPayClient.GetInvoices("", "", "", stringInvoiceIDs, 0, 0)
-	if err != nil {
-		log.Printf("Error getting invoices: %v", err)
-		return
-	}
-
-	for _, invoice := range *invoices {
-		if invoice.InvoiceID != nil && invoice.IsPaid() {
-			payload := strings.Split(invoice.Payload, "&")
-			purchaseID, err := strconv.Atoi(strings.Split(payload[0], "=")[1])
-			username := strings.Split(payload[1], "=")[1]
-			ctxWithUsername := context.WithValue(ctx, "username", username)
-			err = paymentService.ProcessPurchaseById(ctxWithUsername, int64(purchaseID))
-			if err != nil {
-				slog.Error("Error processing invoice", "invoiceId", invoice.InvoiceID, err)
-			} else {
-				slog.Info("Invoice processed", "invoiceId", invoice.InvoiceID, "purchaseId", purchaseID)
-			}
+	for _, provider := range providers.All() {
+		poller, ok := provider.(payment.PollingProvider)
+		if !ok {
+			continue
+		}
+		invoiceType := provider.Type()
+		_, err := c.AddFunc(poller.PollSchedule(), func() {
+			paymentService.CheckPendingPurchases(context.Background(), invoiceType)
+		})
 
+		if err != nil {
+			panic(err)
 		}
+		scheduled++
 	}
+
+	if scheduled == 0 {
+		return nil
+	}
+
+	return c
 }
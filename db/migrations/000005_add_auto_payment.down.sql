@@ -0,0 +1,4 @@
+ALTER TABLE purchase DROP COLUMN parent_purchase_id;
+
+ALTER TABLE customer DROP COLUMN auto_renew;
+ALTER TABLE customer DROP COLUMN payment_method_id;
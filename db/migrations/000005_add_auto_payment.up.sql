@@ -0,0 +1,4 @@
+ALTER TABLE customer ADD COLUMN payment_method_id uuid;
+ALTER TABLE customer ADD COLUMN auto_renew BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE purchase ADD COLUMN parent_purchase_id BIGINT REFERENCES purchase (id) ON DELETE SET NULL;
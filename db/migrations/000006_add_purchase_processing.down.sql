@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_purchase_external_id;
+
+ALTER TABLE purchase DROP COLUMN external_id;
@@ -0,0 +1,3 @@
+ALTER TABLE purchase ADD COLUMN external_id TEXT;
+
+CREATE UNIQUE INDEX idx_purchase_external_id ON purchase (invoice_type, external_id) WHERE external_id IS NOT NULL;
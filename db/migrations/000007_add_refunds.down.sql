@@ -0,0 +1 @@
+ALTER TABLE purchase DROP COLUMN telegram_payment_charge_id;
@@ -0,0 +1 @@
+ALTER TABLE purchase ADD COLUMN telegram_payment_charge_id TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE purchase DROP COLUMN traffic_limit;
+
+ALTER TABLE customer DROP COLUMN tribute_subscription_id;
@@ -0,0 +1,3 @@
+ALTER TABLE customer ADD COLUMN tribute_subscription_id BIGINT;
+
+ALTER TABLE purchase ADD COLUMN traffic_limit BIGINT;
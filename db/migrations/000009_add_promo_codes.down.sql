@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_purchase_promo_code_id;
+
+ALTER TABLE purchase DROP COLUMN discount;
+ALTER TABLE purchase DROP COLUMN promo_code_id;
+
+DROP TABLE IF EXISTS promo_code;
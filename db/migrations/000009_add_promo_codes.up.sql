@@ -0,0 +1,18 @@
+CREATE TABLE promo_code
+(
+    id             BIGSERIAL PRIMARY KEY,
+    code           VARCHAR(64) NOT NULL UNIQUE,
+    discount_type  VARCHAR(10) NOT NULL,
+    discount_value INTEGER     NOT NULL,
+    months         INTEGER[],
+    max_uses       INTEGER,
+    per_user_limit INTEGER     NOT NULL DEFAULT 1,
+    valid_from     TIMESTAMP WITH TIME ZONE,
+    valid_until    TIMESTAMP WITH TIME ZONE,
+    created_at     TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE purchase ADD COLUMN promo_code_id BIGINT REFERENCES promo_code (id);
+ALTER TABLE purchase ADD COLUMN discount DECIMAL(20, 8);
+
+CREATE INDEX idx_purchase_promo_code_id ON purchase (promo_code_id) WHERE promo_code_id IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE purchase DROP COLUMN duration_days;
+ALTER TABLE purchase DROP COLUMN tariff_id;
+
+DROP TABLE IF EXISTS tariff;
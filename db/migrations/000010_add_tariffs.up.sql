@@ -0,0 +1,17 @@
+CREATE TABLE tariff
+(
+    id               BIGSERIAL PRIMARY KEY,
+    name             JSONB       NOT NULL DEFAULT '{}',
+    duration_days    INTEGER     NOT NULL,
+    traffic_limit_gb INTEGER     NOT NULL DEFAULT 0,
+    traffic_strategy VARCHAR(20) NOT NULL DEFAULT 'MONTH',
+    device_limit     INTEGER,
+    inbounds         uuid[],
+    prices           JSONB       NOT NULL DEFAULT '{}',
+    sort_order       INTEGER     NOT NULL DEFAULT 0,
+    active           BOOLEAN     NOT NULL DEFAULT TRUE,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE purchase ADD COLUMN tariff_id BIGINT REFERENCES tariff (id);
+ALTER TABLE purchase ADD COLUMN duration_days INTEGER;
@@ -0,0 +1 @@
+ALTER TABLE customer ADD COLUMN currency VARCHAR(3);
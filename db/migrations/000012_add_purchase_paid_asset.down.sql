@@ -0,0 +1,4 @@
+ALTER TABLE purchase DROP COLUMN fee_amount;
+ALTER TABLE purchase DROP COLUMN paid_fiat_rate;
+ALTER TABLE purchase DROP COLUMN paid_amount;
+ALTER TABLE purchase DROP COLUMN paid_asset;
@@ -0,0 +1,4 @@
+ALTER TABLE purchase ADD COLUMN paid_asset VARCHAR(20);
+ALTER TABLE purchase ADD COLUMN paid_amount DECIMAL(30, 10);
+ALTER TABLE purchase ADD COLUMN paid_fiat_rate DECIMAL(30, 10);
+ALTER TABLE purchase ADD COLUMN fee_amount DECIMAL(30, 10);
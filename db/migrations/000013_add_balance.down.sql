@@ -0,0 +1,3 @@
+ALTER TABLE purchase DROP COLUMN kind;
+
+DROP TABLE IF EXISTS balance_transaction;
@@ -0,0 +1,15 @@
+CREATE TABLE balance_transaction
+(
+    id          BIGSERIAL PRIMARY KEY,
+    customer_id BIGINT         NOT NULL REFERENCES customer (id) ON DELETE CASCADE,
+    amount      DECIMAL(20, 2) NOT NULL,
+    currency    VARCHAR(10)    NOT NULL,
+    reason      VARCHAR(20)    NOT NULL,
+    purchase_id BIGINT REFERENCES purchase (id),
+    created_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_balance_transaction_customer_id ON balance_transaction (customer_id);
+CREATE UNIQUE INDEX idx_balance_transaction_purchase ON balance_transaction (purchase_id, reason) WHERE purchase_id IS NOT NULL;
+
+ALTER TABLE purchase ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'subscription';
@@ -0,0 +1,12 @@
+CREATE TABLE gift
+(
+    id           BIGSERIAL PRIMARY KEY,
+    code         VARCHAR(32) NOT NULL UNIQUE,
+    purchase_id  BIGINT      NOT NULL UNIQUE REFERENCES purchase (id),
+    buyer_id     BIGINT      NOT NULL REFERENCES customer (id) ON DELETE CASCADE,
+    recipient_id BIGINT REFERENCES customer (id) ON DELETE SET NULL,
+    redeemed_at  TIMESTAMP WITH TIME ZONE,
+    created_at   TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_gift_unredeemed ON gift (created_at) WHERE redeemed_at IS NULL;
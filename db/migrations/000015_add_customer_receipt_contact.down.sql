@@ -0,0 +1,2 @@
+ALTER TABLE customer DROP COLUMN receipt_phone;
+ALTER TABLE customer DROP COLUMN receipt_email;
@@ -0,0 +1,2 @@
+ALTER TABLE customer ADD COLUMN receipt_email VARCHAR(255);
+ALTER TABLE customer ADD COLUMN receipt_phone VARCHAR(20);
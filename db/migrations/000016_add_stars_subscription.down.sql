@@ -0,0 +1 @@
+ALTER TABLE customer DROP COLUMN stars_subscription_charge_id;
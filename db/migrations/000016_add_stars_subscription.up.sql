@@ -0,0 +1 @@
+ALTER TABLE customer ADD COLUMN stars_subscription_charge_id VARCHAR(255);
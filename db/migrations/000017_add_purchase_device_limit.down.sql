@@ -0,0 +1,2 @@
+ALTER TABLE purchase DROP COLUMN previous_device_limit;
+ALTER TABLE purchase DROP COLUMN device_limit;
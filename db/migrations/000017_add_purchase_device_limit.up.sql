@@ -0,0 +1,2 @@
+ALTER TABLE purchase ADD COLUMN device_limit INTEGER;
+ALTER TABLE purchase ADD COLUMN previous_device_limit INTEGER;
@@ -0,0 +1,13 @@
+CREATE TABLE provisioning_job
+(
+    id          BIGSERIAL PRIMARY KEY,
+    purchase_id BIGINT                   NOT NULL UNIQUE REFERENCES purchase (id) ON DELETE CASCADE,
+    status      VARCHAR(20)              NOT NULL,
+    attempts    INT                      NOT NULL DEFAULT 0,
+    next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_error  TEXT,
+    created_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_provisioning_job_due ON provisioning_job (next_run_at) WHERE status <> 'done';
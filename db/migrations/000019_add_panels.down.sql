@@ -0,0 +1,2 @@
+ALTER TABLE tariff DROP COLUMN panel;
+ALTER TABLE customer DROP COLUMN panel;
@@ -0,0 +1,2 @@
+ALTER TABLE customer ADD COLUMN panel VARCHAR(50);
+ALTER TABLE tariff ADD COLUMN panel VARCHAR(50);
@@ -0,0 +1 @@
+ALTER TABLE tariff DROP COLUMN squads;
@@ -0,0 +1 @@
+ALTER TABLE tariff ADD COLUMN squads TEXT[];
@@ -0,0 +1 @@
+ALTER TABLE purchase DROP COLUMN auto_renew;
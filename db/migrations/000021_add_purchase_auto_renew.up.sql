@@ -0,0 +1 @@
+ALTER TABLE purchase ADD COLUMN auto_renew BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE provisioning_job DROP COLUMN panel_applied_at;
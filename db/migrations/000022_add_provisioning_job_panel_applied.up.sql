@@ -0,0 +1 @@
+ALTER TABLE provisioning_job ADD COLUMN panel_applied_at TIMESTAMP WITH TIME ZONE;
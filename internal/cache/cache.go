@@ -5,46 +5,61 @@ import (
 	"time"
 )
 
-type Item struct {
-	Value     int
+type Item[V any] struct {
+	Value     V
 	ExpiresAt time.Time
 }
 
-type Cache struct {
-	data  map[int64]Item
+// TypedCache keeps values per chat or purchase id until their ttl passes.
+type TypedCache[V any] struct {
+	data  map[int64]Item[V]
 	mutex sync.RWMutex
 	ttl   time.Duration
 }
 
+// Cache holds message ids and other int values.
+type Cache = TypedCache[int]
+
 func NewCache(ttl time.Duration) *Cache {
-	c := &Cache{
-		data: make(map[int64]Item),
+	return NewTypedCache[int](ttl)
+}
+
+func NewTypedCache[V any](ttl time.Duration) *TypedCache[V] {
+	c := &TypedCache[V]{
+		data: make(map[int64]Item[V]),
 		ttl:  ttl,
 	}
 	go c.cleanupExpired()
 	return c
 }
 
-func (c *Cache) Set(key int64, value int) {
+func (c *TypedCache[V]) Set(key int64, value V) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.data[key] = Item{
+	c.data[key] = Item[V]{
 		Value:     value,
 		ExpiresAt: time.Now().Add(c.ttl),
 	}
 }
 
-func (c *Cache) Get(key int64) (int, bool) {
+func (c *TypedCache[V]) Get(key int64) (V, bool) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	item, found := c.data[key]
 	if !found || time.Now().After(item.ExpiresAt) {
-		return 0, false
+		var zero V
+		return zero, false
 	}
 	return item.Value, true
 }
 
-func (c *Cache) cleanupExpired() {
+func (c *TypedCache[V]) Delete(key int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.data, key)
+}
+
+func (c *TypedCache[V]) cleanupExpired() {
 	ticker := time.NewTicker(5 * time.Minute)
 	for range ticker.C {
 		now := time.Now()
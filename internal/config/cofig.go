@@ -1,20 +1,23 @@
 package config
 
 import (
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"log"
 	"log/slog"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type config struct {
 	telegramToken                                             string
 	price1, price3, price6, price12                           int
 	starsPrice1, starsPrice3, starsPrice6, starsPrice12       int
-	remnawaveUrl, remnawaveToken, remnawaveMode               string
+	panels                                                    []RemnawavePanel
 	databaseURL                                               string
 	cryptoPayURL, cryptoPayToken                              string
 	botURL                                                    string
@@ -28,14 +31,84 @@ type config struct {
 	isYookasaEnabled                                          bool
 	isCryptoEnabled                                           bool
 	isTelegramStarsEnabled                                    bool
+	isStarsSubscriptionEnabled                                bool
 	adminTelegramId                                           int64
 	trialDays                                                 int
-	inboundUUIDs                                              map[uuid.UUID]uuid.UUID
 	referralDays                                              int
 	miniApp                                                   string
 	enableAutoPayment                                         bool
 	healthCheckPort                                           int
 	tributeWebhookUrl, tributeAPIKey, tributePaymentUrl       string
+	yookasaWebhookUrl, cryptoPayWebhookUrl                    string
+	yookasaWebhookTrustProxy                                  bool
+	invoiceTTLMinutes                                         int
+	tributePlans                                              map[string]TributePlan
+	currencies, yookasaCurrencies                             []string
+	cryptoPayAssets                                           []string
+	isBalanceEnabled                                          bool
+	yookasaVatCode                                            int
+	yookasaPaymentSubject, yookasaPaymentMode                 string
+	yookasaReceiptLanguage                                    string
+	isYookasaReceiptContactEnabled                            bool
+	referralBalanceBonus                                      int
+	languageCurrencies                                        map[string]string
+	isReconciliationEnabled                                   bool
+	reconciliationDays                                        int
+	trafficPackages                                           []TrafficPackage
+	trialDeviceLimit                                          int
+	trialSquads                                               []string
+	deviceTiers                                               []DeviceTier
+	isManualPaymentEnabled                                    bool
+	manualPaymentDetails                                      string
+	manualPaymentCurrencies                                   []string
+	provisioningAlertAttempts                                 int
+}
+
+// TributePlan is what a Tribute subscription period grants. TrafficLimit is in bytes, 0 is unlimited.
+type TributePlan struct {
+	Months       int
+	TrafficLimit int
+}
+
+// TrafficPackage is extra traffic sold on top of the subscription. A package of 0 GB resets the used traffic instead.
+type TrafficPackage struct {
+	GB     int
+	Prices map[string]int
+}
+
+// IsReset reports whether the package resets the used traffic instead of raising the limit.
+func (p TrafficPackage) IsReset() bool {
+	return p.GB == 0
+}
+
+func (p TrafficPackage) Bytes() int {
+	return p.GB * bytesInGigabyte
+}
+
+func (p TrafficPackage) Price(currency string) (int, bool) {
+	price, ok := p.Prices[currency]
+	return price, ok
+}
+
+// RemnawavePanel is a Remnawave panel customers can be provisioned on. Name is how plans and customers refer to it.
+// InboundUUIDs limit the inbounds of new users, empty means all inbounds of the panel.
+type RemnawavePanel struct {
+	Name         string
+	URL          string
+	Token        string
+	Mode         string
+	InboundUUIDs map[uuid.UUID]uuid.UUID
+}
+
+// DeviceTier is a device limit customers can upgrade to mid-period. Prices are per 30 days of the remaining period.
+type DeviceTier struct {
+	Devices int
+	Prices  map[string]int
+}
+
+func (t DeviceTier) Price(currency string) (int, bool) {
+	price, ok := t.Prices[currency]
+	return price, ok
 }
 
 var conf config
@@ -51,6 +124,71 @@ func GetTributePaymentUrl() string {
 	return conf.tributePaymentUrl
 }
 
+// GetTributePlan looks up the plan of a Tribute subscription period, a plan configured
+// for the whole subscription applies to periods without their own entry.
+func GetTributePlan(subscriptionID, periodID int) (TributePlan, bool) {
+	if plan, ok := conf.tributePlans[fmt.Sprintf("%d:%d", subscriptionID, periodID)]; ok {
+		return plan, true
+	}
+	plan, ok := conf.tributePlans[strconv.Itoa(subscriptionID)]
+	return plan, ok
+}
+
+// Currencies are the fiat currencies customers can choose from, the first one is the default.
+func Currencies() []string {
+	return conf.currencies
+}
+
+func YookasaCurrencies() []string {
+	return conf.yookasaCurrencies
+}
+
+// CurrencyForLanguage is the currency offered to customers who have not chosen one.
+func CurrencyForLanguage(langCode string) string {
+	if currency, ok := conf.languageCurrencies[langCode]; ok {
+		return currency
+	}
+	return conf.currencies[0]
+}
+
+func IsCurrencyEnabled(currency string) bool {
+	for _, enabled := range conf.currencies {
+		if enabled == currency {
+			return true
+		}
+	}
+	return false
+}
+
+func IsBalanceEnabled() bool {
+	return conf.isBalanceEnabled
+}
+
+// ReferralBalanceBonus is credited to the referrer's balance, in the default currency, on the first purchase of a referee.
+func ReferralBalanceBonus() int {
+	return conf.referralBalanceBonus
+}
+
+// TrafficPackages are the extra traffic packages in the order they are offered, none disables the menu entry.
+func TrafficPackages() []TrafficPackage {
+	return conf.trafficPackages
+}
+
+// DeviceTiers are the device limits offered as upgrades, ordered by device count.
+func DeviceTiers() []DeviceTier {
+	return conf.deviceTiers
+}
+
+// IsReconciliationEnabled turns on the daily payment reconciliation report sent to the admin.
+func IsReconciliationEnabled() bool {
+	return conf.isReconciliationEnabled
+}
+
+// ReconciliationDays is how many past days the reconciliation report covers.
+func ReconciliationDays() int {
+	return conf.reconciliationDays
+}
+
 func GetReferralDays() int {
 	return conf.referralDays
 }
@@ -59,8 +197,32 @@ func GetMiniAppURL() string {
 	return conf.miniApp
 }
 
-func InboundUUIDs() map[uuid.UUID]uuid.UUID {
-	return conf.inboundUUIDs
+func IsManualPaymentEnabled() bool {
+	return conf.isManualPaymentEnabled
+}
+
+// ManualPaymentDetails are the bank transfer details shown to customers paying manually.
+func ManualPaymentDetails() string {
+	return conf.manualPaymentDetails
+}
+
+func ManualPaymentCurrencies() []string {
+	return conf.manualPaymentCurrencies
+}
+
+// ProvisioningAlertAttempts is how many failed attempts to fulfil a paid purchase the admin is alerted after.
+func ProvisioningAlertAttempts() int {
+	return conf.provisioningAlertAttempts
+}
+
+// TrialSquads are the internal squad names or UUIDs trial users are granted, nil keeps the panel defaults.
+func TrialSquads() []string {
+	return conf.trialSquads
+}
+
+// TrialDeviceLimit is the HWID device limit of trial users, 0 keeps the panel default.
+func TrialDeviceLimit() int {
+	return conf.trialDeviceLimit
 }
 
 func TrialTrafficLimit() int {
@@ -90,10 +252,33 @@ func TosURL() string {
 	return conf.tosURL
 }
 
+// YookasaEmail receives the receipts of customers who didn't leave their own e-mail or phone.
 func YookasaEmail() string {
 	return conf.yookasaEmail
 }
 
+func YookasaVatCode() int {
+	return conf.yookasaVatCode
+}
+
+func YookasaPaymentSubject() string {
+	return conf.yookasaPaymentSubject
+}
+
+func YookasaPaymentMode() string {
+	return conf.yookasaPaymentMode
+}
+
+// YookasaReceiptLanguage is the language receipt item descriptions are translated to.
+func YookasaReceiptLanguage() string {
+	return conf.yookasaReceiptLanguage
+}
+
+// IsYookasaReceiptContactEnabled asks customers for their e-mail or phone before the first YooKassa payment.
+func IsYookasaReceiptContactEnabled() bool {
+	return conf.isYookasaReceiptContactEnabled
+}
+
 func Price1() int {
 	return conf.price1
 }
@@ -142,24 +327,37 @@ func StarsPrice(month int) int {
 func TelegramToken() string {
 	return conf.telegramToken
 }
-func RemnawaveUrl() string {
-	return conf.remnawaveUrl
-}
 func DadaBaseUrl() string {
 	return conf.databaseURL
 }
-func RemnawaveToken() string {
-	return conf.remnawaveToken
+
+// Panels are the Remnawave panels in the order locations are offered, the first one is the default.
+func Panels() []RemnawavePanel {
+	return conf.panels
 }
-func RemnawaveMode() string {
-	return conf.remnawaveMode
+
+func IsPanelConfigured(name string) bool {
+	for _, panel := range conf.panels {
+		if panel.Name == name {
+			return true
+		}
+	}
+	return false
 }
+
 func CryptoPayUrl() string {
 	return conf.cryptoPayURL
 }
 func CryptoPayToken() string {
 	return conf.cryptoPayToken
 }
+func CryptoPayWebhookUrl() string {
+	return conf.cryptoPayWebhookUrl
+}
+
+func CryptoPayAssets() []string {
+	return conf.cryptoPayAssets
+}
 func BotURL() string {
 	return conf.botURL
 }
@@ -175,10 +373,20 @@ func YookasaShopId() string {
 func YookasaSecretKey() string {
 	return conf.yookasaSecretKey
 }
+func YookasaWebhookUrl() string {
+	return conf.yookasaWebhookUrl
+}
+func YookasaWebhookTrustProxy() bool {
+	return conf.yookasaWebhookTrustProxy
+}
 func TrafficLimit() int {
 	return conf.trafficLimit * bytesInGigabyte
 }
 
+func TrafficLimitGb() int {
+	return conf.trafficLimit
+}
+
 func IsCryptoPayEnabled() bool {
 	return conf.isCryptoEnabled
 }
@@ -187,14 +395,28 @@ func IsYookasaEnabled() bool {
 	return conf.isYookasaEnabled
 }
 
+func IsAutoPaymentEnabled() bool {
+	return conf.enableAutoPayment
+}
+
 func IsTelegramStarsEnabled() bool {
 	return conf.isTelegramStarsEnabled
 }
 
+// IsStarsSubscriptionEnabled sells 30 day tariffs for Stars as Telegram subscriptions renewed every 30 days.
+func IsStarsSubscriptionEnabled() bool {
+	return conf.isStarsSubscriptionEnabled
+}
+
 func GetAdminTelegramId() int64 {
 	return conf.adminTelegramId
 }
 
+// InvoiceTTL is how long an issued invoice can be paid before it expires.
+func InvoiceTTL() time.Duration {
+	return time.Duration(conf.invoiceTTLMinutes) * time.Minute
+}
+
 func GetHealthCheckPort() int {
 	return conf.healthCheckPort
 }
@@ -230,6 +452,13 @@ func envIntDefault(key string, def int) int {
 	return i
 }
 
+func envDefault(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
 func envBool(key string) bool {
 	return os.Getenv(key) == "true"
 }
@@ -258,6 +487,11 @@ func InitConfig() {
 	}()
 
 	conf.trialTrafficLimit = mustEnvInt("TRIAL_TRAFFIC_LIMIT")
+	conf.trialDeviceLimit = envIntDefault("TRIAL_DEVICE_LIMIT", 0)
+	if conf.trialDeviceLimit < 0 {
+		log.Panicf("invalid TRIAL_DEVICE_LIMIT %d", conf.trialDeviceLimit)
+	}
+	conf.trialSquads = parseSquads(os.Getenv("TRIAL_SQUADS"))
 
 	conf.healthCheckPort = envIntDefault("HEALTH_CHECK_PORT", 8080)
 
@@ -265,6 +499,11 @@ func InitConfig() {
 
 	conf.enableAutoPayment = envBool("ENABLE_AUTO_PAYMENT")
 
+	conf.invoiceTTLMinutes = envIntDefault("INVOICE_TTL_MINUTES", 60)
+	if conf.invoiceTTLMinutes <= 0 {
+		panic("INVOICE_TTL_MINUTES must be positive")
+	}
+
 	conf.price1 = mustEnvInt("PRICE_1")
 	conf.price3 = mustEnvInt("PRICE_3")
 	conf.price6 = mustEnvInt("PRICE_6")
@@ -276,25 +515,11 @@ func InitConfig() {
 		conf.starsPrice3 = envIntDefault("STARS_PRICE_3", conf.price3)
 		conf.starsPrice6 = envIntDefault("STARS_PRICE_6", conf.price6)
 		conf.starsPrice12 = envIntDefault("STARS_PRICE_12", conf.price12)
+		conf.isStarsSubscriptionEnabled = envBool("TELEGRAM_STARS_SUBSCRIPTIONS")
 
 	}
 
-	conf.remnawaveUrl = mustEnv("REMNAWAVE_URL")
-
-	conf.remnawaveMode = func() string {
-		v := os.Getenv("REMNAWAVE_MODE")
-		if v != "" {
-			if v != "remote" && v != "local" {
-				panic("REMNAWAVE_MODE .env variable must be either 'remote' or 'local'")
-			} else {
-				return v
-			}
-		} else {
-			return "remote"
-		}
-	}()
-
-	conf.remnawaveToken = mustEnv("REMNAWAVE_TOKEN")
+	conf.panels = parsePanels(os.Getenv("REMNAWAVE_PANELS"))
 
 	conf.databaseURL = mustEnv("DATABASE_URL")
 
@@ -302,6 +527,8 @@ func InitConfig() {
 	if conf.isCryptoEnabled {
 		conf.cryptoPayURL = mustEnv("CRYPTO_PAY_URL")
 		conf.cryptoPayToken = mustEnv("CRYPTO_PAY_TOKEN")
+		conf.cryptoPayWebhookUrl = os.Getenv("CRYPTO_PAY_WEBHOOK_URL")
+		conf.cryptoPayAssets = parseCryptoPayAssets(os.Getenv("CRYPTO_PAY_ASSETS"))
 	}
 
 	conf.isYookasaEnabled = envBool("YOOKASA_ENABLED")
@@ -310,6 +537,16 @@ func InitConfig() {
 		conf.yookasaShopId = mustEnv("YOOKASA_SHOP_ID")
 		conf.yookasaSecretKey = mustEnv("YOOKASA_SECRET_KEY")
 		conf.yookasaEmail = mustEnv("YOOKASA_EMAIL")
+		conf.yookasaWebhookUrl = os.Getenv("YOOKASA_WEBHOOK_URL")
+		conf.yookasaWebhookTrustProxy = envBool("YOOKASA_WEBHOOK_TRUST_PROXY")
+		conf.yookasaVatCode = envIntDefault("YOOKASA_VAT_CODE", 1)
+		if conf.yookasaVatCode < 1 || conf.yookasaVatCode > 12 {
+			log.Panicf("invalid YOOKASA_VAT_CODE %d, expected 1-12", conf.yookasaVatCode)
+		}
+		conf.yookasaPaymentSubject = envDefault("YOOKASA_PAYMENT_SUBJECT", "payment")
+		conf.yookasaPaymentMode = envDefault("YOOKASA_PAYMENT_MODE", "full_payment")
+		conf.yookasaReceiptLanguage = envDefault("YOOKASA_RECEIPT_LANGUAGE", "ru")
+		conf.isYookasaReceiptContactEnabled = envBool("YOOKASA_RECEIPT_CONTACT")
 	}
 
 	conf.trafficLimit = mustEnvInt("TRAFFIC_LIMIT")
@@ -321,29 +558,285 @@ func InitConfig() {
 	conf.channelURL = os.Getenv("CHANNEL_URL")
 	conf.tosURL = os.Getenv("TOS_URL")
 
-	conf.inboundUUIDs = func() map[uuid.UUID]uuid.UUID {
-		v := os.Getenv("INBOUND_UUIDS")
-		if v != "" {
-			uuids := strings.Split(v, ",")
-			var inboundsMap = make(map[uuid.UUID]uuid.UUID)
-			for _, value := range uuids {
-				uuid, err := uuid.Parse(value)
-				if err != nil {
-					panic(err)
-				}
-				inboundsMap[uuid] = uuid
-			}
-			slog.Info("Loaded inbound UUIDs", "uuids", uuids)
-			return inboundsMap
-		} else {
-			slog.Info("No inbound UUIDs specified, all will be used")
-			return map[uuid.UUID]uuid.UUID{}
-		}
-	}()
+	conf.currencies = parseCurrencies("CURRENCIES", "RUB")
+	conf.yookasaCurrencies = parseCurrencies("YOOKASA_CURRENCIES", "RUB")
+	conf.languageCurrencies = parseLanguageCurrencies(os.Getenv("LANGUAGE_CURRENCIES"))
+
+	conf.isBalanceEnabled = envBool("BALANCE_ENABLED")
+	if conf.isBalanceEnabled {
+		conf.referralBalanceBonus = envIntDefault("REFERRAL_BALANCE_BONUS", 0)
+	}
+
+	conf.trafficPackages = parseTrafficPackages(os.Getenv("TRAFFIC_PACKAGES"))
+	conf.deviceTiers = parseDeviceTiers(os.Getenv("DEVICE_TIERS"))
+
+	conf.isReconciliationEnabled = envBool("RECONCILIATION_ENABLED")
+	conf.reconciliationDays = envIntDefault("RECONCILIATION_DAYS", 2)
+	if conf.reconciliationDays <= 0 {
+		log.Panicf("invalid RECONCILIATION_DAYS %d", conf.reconciliationDays)
+	}
+
+	conf.provisioningAlertAttempts = envIntDefault("PROVISIONING_ALERT_ATTEMPTS", 3)
+	if conf.provisioningAlertAttempts <= 0 {
+		log.Panicf("invalid PROVISIONING_ALERT_ATTEMPTS %d", conf.provisioningAlertAttempts)
+	}
+
+	conf.isManualPaymentEnabled = envBool("MANUAL_PAYMENT_ENABLED")
+	if conf.isManualPaymentEnabled {
+		conf.manualPaymentDetails = strings.ReplaceAll(mustEnv("MANUAL_PAYMENT_DETAILS"), `\n`, "\n")
+		conf.manualPaymentCurrencies = parseCurrencies("MANUAL_PAYMENT_CURRENCIES", "RUB")
+	}
 
 	conf.tributeWebhookUrl = os.Getenv("TRIBUTE_WEBHOOK_URL")
 	if conf.tributeWebhookUrl != "" {
 		conf.tributeAPIKey = mustEnv("TRIBUTE_API_KEY")
 		conf.tributePaymentUrl = mustEnv("TRIBUTE_PAYMENT_URL")
+		conf.tributePlans = parseTributePlans(os.Getenv("TRIBUTE_PLANS"))
+	}
+}
+
+// parseCryptoPayAssets reads the comma separated assets invoices can be paid in, USDT by default.
+func parseCryptoPayAssets(v string) []string {
+	if v == "" {
+		return []string{"USDT"}
+	}
+
+	var assets []string
+	for _, asset := range strings.Split(v, ",") {
+		asset = strings.ToUpper(strings.TrimSpace(asset))
+		if asset == "" {
+			log.Panicf("invalid CRYPTO_PAY_ASSETS %q", v)
+		}
+		assets = append(assets, asset)
+	}
+	return assets
+}
+
+// parseSquads reads the comma separated internal squad names or UUIDs of TRIAL_SQUADS, none keeps the panel defaults.
+func parseSquads(v string) []string {
+	if v == "" {
+		return nil
+	}
+
+	var squads []string
+	for _, squad := range strings.Split(v, ",") {
+		squad = strings.TrimSpace(squad)
+		if squad == "" {
+			log.Panicf("invalid TRIAL_SQUADS %q", v)
+		}
+		squads = append(squads, squad)
+	}
+	return squads
+}
+
+// parseCurrencies reads a comma separated list of ISO 4217 codes.
+func parseCurrencies(key string, def string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		v = def
+	}
+
+	var currencies []string
+	for _, currency := range strings.Split(v, ",") {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if len(currency) != 3 {
+			log.Panicf("invalid currency %q in %s", currency, key)
+		}
+		currencies = append(currencies, currency)
+	}
+	return currencies
+}
+
+// parseLanguageCurrencies reads comma separated entries of the form <language>=<currency>,
+// only currencies listed in CURRENCIES are accepted.
+func parseLanguageCurrencies(v string) map[string]string {
+	languageCurrencies := map[string]string{}
+	if v == "" {
+		return languageCurrencies
+	}
+
+	for _, entry := range strings.Split(v, ",") {
+		lang, currency, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			log.Panicf("invalid LANGUAGE_CURRENCIES entry %q", entry)
+		}
+		currency = strings.ToUpper(currency)
+		if !IsCurrencyEnabled(currency) {
+			log.Panicf("currency of LANGUAGE_CURRENCIES entry %q is not in CURRENCIES", entry)
+		}
+		languageCurrencies[lang] = currency
+	}
+	return languageCurrencies
+}
+
+// parseTributePlans reads comma separated entries of the form
+// <subscription_id>[:<period_id>]=<months>[:<traffic_gb>], traffic defaults to TRAFFIC_LIMIT.
+func parseTributePlans(v string) map[string]TributePlan {
+	plans := make(map[string]TributePlan)
+	if v == "" {
+		return plans
+	}
+
+	for _, entry := range strings.Split(v, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			log.Panicf("invalid TRIBUTE_PLANS entry %q", entry)
+		}
+
+		monthsValue, trafficValue, hasTraffic := strings.Cut(value, ":")
+		months, err := strconv.Atoi(monthsValue)
+		if err != nil || months <= 0 {
+			log.Panicf("invalid months in TRIBUTE_PLANS entry %q", entry)
+		}
+
+		plan := TributePlan{Months: months, TrafficLimit: conf.trafficLimit * bytesInGigabyte}
+		if hasTraffic {
+			traffic, err := strconv.Atoi(trafficValue)
+			if err != nil || traffic < 0 {
+				log.Panicf("invalid traffic in TRIBUTE_PLANS entry %q", entry)
+			}
+			plan.TrafficLimit = traffic * bytesInGigabyte
+		}
+		plans[key] = plan
+	}
+
+	slog.Info("Loaded tribute plans", "count", len(plans))
+	return plans
+}
+
+// parseTrafficPackages reads comma separated packages of <gb|reset>:<CURRENCY>=<price>|<CURRENCY>=<price>,
+// e.g. 10:RUB=99|STARS=60,reset:RUB=149.
+func parseTrafficPackages(v string) []TrafficPackage {
+	if v == "" {
+		return nil
+	}
+
+	var packages []TrafficPackage
+	for _, entry := range strings.Split(v, ",") {
+		sizeValue, pricesValue, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found {
+			log.Panicf("invalid TRAFFIC_PACKAGES entry %q", entry)
+		}
+
+		var pkg TrafficPackage
+		if sizeValue != "reset" {
+			gb, err := strconv.Atoi(sizeValue)
+			if err != nil || gb <= 0 {
+				log.Panicf("invalid traffic in TRAFFIC_PACKAGES entry %q", entry)
+			}
+			pkg.GB = gb
+		}
+
+		pkg.Prices = parsePrices("TRAFFIC_PACKAGES", entry, pricesValue)
+		packages = append(packages, pkg)
+	}
+
+	slog.Info("Loaded traffic packages", "count", len(packages))
+	return packages
+}
+
+// parseDeviceTiers reads comma separated tiers of <devices>:<CURRENCY>=<price>|<CURRENCY>=<price>,
+// e.g. 3:RUB=100,5:RUB=180. The tiers are sorted by device count.
+func parseDeviceTiers(v string) []DeviceTier {
+	if v == "" {
+		return nil
+	}
+
+	var tiers []DeviceTier
+	for _, entry := range strings.Split(v, ",") {
+		devicesValue, pricesValue, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found {
+			log.Panicf("invalid DEVICE_TIERS entry %q", entry)
+		}
+
+		devices, err := strconv.Atoi(devicesValue)
+		if err != nil || devices <= 0 {
+			log.Panicf("invalid devices in DEVICE_TIERS entry %q", entry)
+		}
+		tiers = append(tiers, DeviceTier{Devices: devices, Prices: parsePrices("DEVICE_TIERS", entry, pricesValue)})
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].Devices < tiers[j].Devices
+	})
+
+	slog.Info("Loaded device tiers", "count", len(tiers))
+	return tiers
+}
+
+// parsePanels reads the default panel from REMNAWAVE_URL, REMNAWAVE_TOKEN, REMNAWAVE_MODE and INBOUND_UUIDS,
+// named by REMNAWAVE_NAME, followed by the comma separated extra panels, e.g. de,nl, each configured through
+// REMNAWAVE_<NAME>_URL, REMNAWAVE_<NAME>_TOKEN, REMNAWAVE_<NAME>_MODE and REMNAWAVE_<NAME>_INBOUND_UUIDS.
+func parsePanels(v string) []RemnawavePanel {
+	panels := []RemnawavePanel{parsePanel(envDefault("REMNAWAVE_NAME", "default"), "REMNAWAVE", "INBOUND_UUIDS")}
+	if v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			prefix := "REMNAWAVE_" + strings.ToUpper(name)
+			panels = append(panels, parsePanel(name, prefix, prefix+"_INBOUND_UUIDS"))
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, panel := range panels {
+		if seen[panel.Name] {
+			log.Panicf("duplicate panel %q in REMNAWAVE_PANELS", panel.Name)
+		}
+		seen[panel.Name] = true
+	}
+
+	slog.Info("Loaded remnawave panels", "count", len(panels))
+	return panels
+}
+
+func parsePanel(name string, prefix string, inboundsKey string) RemnawavePanel {
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" || len(name) > 50 {
+		log.Panicf("invalid panel name %q, use lowercase letters, digits and underscores", name)
+	}
+
+	mode := envDefault(prefix+"_MODE", "remote")
+	if mode != "remote" && mode != "local" {
+		log.Panicf("%s_MODE .env variable must be either 'remote' or 'local'", prefix)
+	}
+
+	return RemnawavePanel{
+		Name:         name,
+		URL:          mustEnv(prefix + "_URL"),
+		Token:        mustEnv(prefix + "_TOKEN"),
+		Mode:         mode,
+		InboundUUIDs: parseInboundUUIDs(inboundsKey),
+	}
+}
+
+func parseInboundUUIDs(key string) map[uuid.UUID]uuid.UUID {
+	v := os.Getenv(key)
+	if v == "" {
+		slog.Info("No inbound UUIDs specified, all will be used", "key", key)
+		return map[uuid.UUID]uuid.UUID{}
+	}
+
+	uuids := strings.Split(v, ",")
+	inboundsMap := make(map[uuid.UUID]uuid.UUID)
+	for _, value := range uuids {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			panic(err)
+		}
+		inboundsMap[id] = id
+	}
+	slog.Info("Loaded inbound UUIDs", "key", key, "uuids", uuids)
+	return inboundsMap
+}
+
+// parsePrices reads the <CURRENCY>=<price>|<CURRENCY>=<price> prices of a TRAFFIC_PACKAGES or DEVICE_TIERS entry.
+func parsePrices(key string, entry string, v string) map[string]int {
+	prices := make(map[string]int)
+	for _, priceValue := range strings.Split(v, "|") {
+		currency, amount, found := strings.Cut(priceValue, "=")
+		price, err := strconv.Atoi(amount)
+		if !found || err != nil || price <= 0 {
+			log.Panicf("invalid price in %s entry %q", key, entry)
+		}
+		prices[strings.ToUpper(strings.TrimSpace(currency))] = price
 	}
+	return prices
 }
@@ -11,6 +11,8 @@ import (
 type CryptoPayApi interface {
 	CreateInvoice(invoiceReq *InvoiceRequest) (*InvoiceResponse, error)
 	GetInvoices(status, fiat, asset, invoiceIds string, offset, limit int) (*[]InvoiceResponse, error)
+	DeleteInvoice(invoiceID int64) error
+	GetExchangeRates() (*[]ExchangeRate, error)
 }
 
 type Client struct {
@@ -63,7 +65,7 @@ func (c *Client) CreateInvoice(invoiceReq *InvoiceRequest) (*InvoiceResponse, er
 	}
 
 	if !apiResp.Ok {
-		return nil, fmt.Errorf("API create failed: %s", apiResp.Ok)
+		return nil, fmt.Errorf("API create failed: %v", apiResp.Ok)
 	}
 
 	return &apiResp.Result, nil
@@ -87,7 +89,7 @@ func (c *Client) GetInvoices(status, fiat, asset, invoiceIds string, offset, lim
 	}
 
 	if limit > 0 {
-		q.Add("limit", fmt.Sprintf("%d", limit))
+		q.Add("count", fmt.Sprintf("%d", limit))
 	}
 
 	if invoiceIds != "" {
@@ -131,3 +133,81 @@ func (c *Client) GetInvoices(status, fiat, asset, invoiceIds string, offset, lim
 
 	return &apiResp.Result.Items, nil
 }
+
+func (c *Client) DeleteInvoice(invoiceID int64) error {
+	jsonData, err := json.Marshal(map[string]int64{"invoice_id": invoiceID})
+	if err != nil {
+		return fmt.Errorf("error marshaling delete invoice req: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/deleteInvoice", c.baseURL)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("error while creating delete invoice req: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Crypto-Pay-API-Token", c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error while making delete invoice req: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error while reading delete invoice resp: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API return error. Status: %d, Body: %s", resp.StatusCode, string(body))
+	}
+
+	var apiResp ResponseWrapper[bool]
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return fmt.Errorf("error while unmarshiling response: %w", err)
+	}
+
+	if !apiResp.Ok {
+		return fmt.Errorf("API delete invoice failed: %v", apiResp.Ok)
+	}
+
+	return nil
+}
+
+func (c *Client) GetExchangeRates() (*[]ExchangeRate, error) {
+	endpoint := fmt.Sprintf("%s/api/getExchangeRates", c.baseURL)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating exchange rates req: %w", err)
+	}
+
+	req.Header.Set("Crypto-Pay-API-Token", c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while making exchange rates req: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading exchange rates resp: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API return error. Status: %d, Body: %s", resp.StatusCode, string(body))
+	}
+
+	var apiResp ResponseWrapper[[]ExchangeRate]
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return nil, fmt.Errorf("error while unmarshiling response: %w", err)
+	}
+
+	if !apiResp.Ok {
+		return nil, fmt.Errorf("API get exchange rates failed: %v", apiResp.Ok)
+	}
+
+	return &apiResp.Result, nil
+}
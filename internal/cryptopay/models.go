@@ -55,6 +55,46 @@ func (r InvoiceResponse) IsPaid() bool {
 	return r.Status == "paid"
 }
 
+// PaymentFields are the purchase columns recording the asset a paid invoice was settled in.
+func (r InvoiceResponse) PaymentFields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"paid_asset":     nilIfEmpty(r.PaidAsset),
+		"paid_amount":    nilIfEmpty(r.PaidAmount),
+		"paid_fiat_rate": nilIfEmpty(r.PaidFiatRate),
+		"fee_amount":     nil,
+	}
+	if r.FeeAmount != nil {
+		fields["fee_amount"] = nilIfEmpty(*r.FeeAmount)
+	}
+	return fields
+}
+
+func nilIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// ExchangeRate is the price of one Source in Target.
+type ExchangeRate struct {
+	IsValid  bool   `json:"is_valid"`
+	IsCrypto bool   `json:"is_crypto"`
+	IsFiat   bool   `json:"is_fiat"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Rate     string `json:"rate"`
+}
+
+const UpdateTypeInvoicePaid = "invoice_paid"
+
+type Update struct {
+	UpdateID    int64           `json:"update_id"`
+	UpdateType  string          `json:"update_type"`
+	RequestDate *time.Time      `json:"request_date"`
+	Payload     InvoiceResponse `json:"payload"`
+}
+
 type ResponseWrapper[T any] struct {
 	Ok     bool `json:"ok"`
 	Result T    `json:"result"`
@@ -0,0 +1,258 @@
+package cryptopay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/internal/translation"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exchangeRatesTTL = 5 * time.Minute
+	invoicePageLimit = 1000
+)
+
+type Provider struct {
+	client      *Client
+	translation *translation.Manager
+
+	ratesMu        sync.Mutex
+	rates          []ExchangeRate
+	ratesFetchedAt time.Time
+}
+
+func NewProvider(client *Client, translation *translation.Manager) *Provider {
+	return &Provider{client: client, translation: translation}
+}
+
+func (p *Provider) Type() database.InvoiceType {
+	return database.InvoiceTypeCrypto
+}
+
+func (p *Provider) ButtonKey() string {
+	return "crypto_button"
+}
+
+// Currencies are all customer currencies, CryptoPay converts fiat amounts to the accepted assets.
+func (p *Provider) Currencies() []string {
+	return config.Currencies()
+}
+
+// PollSchedule falls back to polling every five minutes once updates arrive through the webhook.
+func (p *Provider) PollSchedule() string {
+	if config.CryptoPayWebhookUrl() != "" {
+		return "0 */5 * * * *"
+	}
+	return "*/5 * * * * *"
+}
+
+func (p *Provider) CreateInvoice(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*payment.Invoice, error) {
+	expiresIn := int(config.InvoiceTTL().Seconds())
+	invoice, err := p.client.CreateInvoice(&InvoiceRequest{
+		CurrencyType:   "fiat",
+		Fiat:           purchase.Currency,
+		Amount:         fmt.Sprintf("%d", int(purchase.Amount)),
+		AcceptedAssets: strings.Join(config.CryptoPayAssets(), ","),
+		Payload:        encodePayload(purchase.ID, usernameFromContext(ctx)),
+		Description:    payment.Description(p.translation, customer.Language, purchase),
+		PaidBtnName:    "callback",
+		PaidBtnUrl:     config.BotURL(),
+		ExpiresIn:      &expiresIn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment.Invoice{
+		URL: invoice.BotInvoiceUrl,
+		Fields: map[string]interface{}{
+			"crypto_invoice_url": invoice.BotInvoiceUrl,
+			"crypto_invoice_id":  invoice.InvoiceID,
+		},
+	}, nil
+}
+
+func (p *Provider) CheckStatus(ctx context.Context, purchase *database.Purchase) (*payment.InvoiceState, error) {
+	if purchase.CryptoInvoiceID == nil {
+		return nil, fmt.Errorf("purchase %d has no crypto invoice", purchase.ID)
+	}
+
+	states, err := p.CheckStatuses(ctx, []database.Purchase{*purchase})
+	if err != nil {
+		return nil, err
+	}
+	if state, ok := states[purchase.ID]; ok {
+		return state, nil
+	}
+	return &payment.InvoiceState{Status: payment.InvoiceStatusPending}, nil
+}
+
+// CheckStatuses looks the invoices of the purchases up with one getInvoices request per page of ids.
+// Only paid invoices are reported, purchases without a crypto invoice are skipped.
+func (p *Provider) CheckStatuses(ctx context.Context, purchases []database.Purchase) (map[int64]*payment.InvoiceState, error) {
+	purchaseIDs := make(map[int64]int64, len(purchases))
+	var invoiceIDs []string
+	for _, purchase := range purchases {
+		if purchase.CryptoInvoiceID == nil {
+			continue
+		}
+		purchaseIDs[*purchase.CryptoInvoiceID] = purchase.ID
+		invoiceIDs = append(invoiceIDs, strconv.FormatInt(*purchase.CryptoInvoiceID, 10))
+	}
+
+	states := make(map[int64]*payment.InvoiceState)
+	for start := 0; start < len(invoiceIDs); start += invoicePageLimit {
+		end := min(start+invoicePageLimit, len(invoiceIDs))
+		invoices, err := p.client.GetInvoices("", "", "", strings.Join(invoiceIDs[start:end], ","), 0, end-start)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, invoice := range *invoices {
+			if invoice.InvoiceID == nil || !invoice.IsPaid() {
+				continue
+			}
+			purchaseID, ok := purchaseIDs[*invoice.InvoiceID]
+			if !ok {
+				continue
+			}
+			_, username, err := decodePayload(invoice.Payload)
+			if err != nil {
+				slog.Error("Error decoding invoice payload", "invoiceId", *invoice.InvoiceID, "error", err)
+			}
+			states[purchaseID] = &payment.InvoiceState{Status: payment.InvoiceStatusPaid, Username: username, Fields: invoice.PaymentFields()}
+		}
+	}
+
+	return states, nil
+}
+
+func (p *Provider) Cancel(ctx context.Context, purchase *database.Purchase) error {
+	if purchase.CryptoInvoiceID == nil {
+		return nil
+	}
+	return p.client.DeleteInvoice(*purchase.CryptoInvoiceID)
+}
+
+// ListPayments pages through all CryptoPay invoices until an empty page, the API has no date filter and doesn't
+// document the order of the list, so the period is applied here.
+func (p *Provider) ListPayments(ctx context.Context, from, to time.Time) ([]payment.ProviderPayment, error) {
+	var payments []payment.ProviderPayment
+	for offset := 0; ; offset += invoicePageLimit {
+		invoices, err := p.client.GetInvoices("", "", "", "", offset, invoicePageLimit)
+		if err != nil {
+			return nil, err
+		}
+		if len(*invoices) == 0 {
+			return payments, nil
+		}
+
+		for _, invoice := range *invoices {
+			if invoice.InvoiceID == nil || invoice.CreatedAt == nil || invoice.CreatedAt.Before(from) || !invoice.CreatedAt.Before(to) {
+				continue
+			}
+			purchaseID, _, err := decodePayload(invoice.Payload)
+			if err != nil {
+				purchaseID = 0
+			}
+			payments = append(payments, payment.ProviderPayment{
+				ID:         strconv.FormatInt(*invoice.InvoiceID, 10),
+				PurchaseID: purchaseID,
+				Paid:       invoice.IsPaid(),
+				Amount:     invoice.Amount,
+				Currency:   invoice.Fiat,
+				CreatedAt:  *invoice.CreatedAt,
+			})
+		}
+	}
+}
+
+// Quote converts a fiat price to the accepted assets at the current CryptoPay rates, e.g. "≈ 2.15 USDT / 0.71 TON".
+func (p *Provider) Quote(ctx context.Context, amount int, currency string) string {
+	rates, err := p.exchangeRates()
+	if err != nil {
+		slog.Error("Error getting exchange rates", "error", err)
+		return ""
+	}
+
+	var quotes []string
+	for _, asset := range config.CryptoPayAssets() {
+		for _, rate := range rates {
+			if !rate.IsValid || rate.Source != asset || rate.Target != currency {
+				continue
+			}
+			value, err := strconv.ParseFloat(rate.Rate, 64)
+			if err != nil || value <= 0 {
+				break
+			}
+			quotes = append(quotes, fmt.Sprintf("%s %s", formatAssetAmount(float64(amount)/value), asset))
+			break
+		}
+	}
+
+	if len(quotes) == 0 {
+		return ""
+	}
+	return "≈ " + strings.Join(quotes, " / ")
+}
+
+func (p *Provider) exchangeRates() ([]ExchangeRate, error) {
+	p.ratesMu.Lock()
+	defer p.ratesMu.Unlock()
+
+	if p.rates != nil && time.Since(p.ratesFetchedAt) < exchangeRatesTTL {
+		return p.rates, nil
+	}
+
+	rates, err := p.client.GetExchangeRates()
+	if err != nil {
+		return nil, err
+	}
+	p.rates = *rates
+	p.ratesFetchedAt = time.Now()
+	return p.rates, nil
+}
+
+// formatAssetAmount keeps three significant digits of small amounts, e.g. 0.0000312 BTC.
+func formatAssetAmount(amount float64) string {
+	decimals := 2
+	for amount > 0 && amount*math.Pow10(decimals) < 100 && decimals < 8 {
+		decimals++
+	}
+	return strconv.FormatFloat(amount, 'f', decimals, 64)
+}
+
+func usernameFromContext(ctx context.Context) string {
+	if username, ok := ctx.Value("username").(string); ok {
+		return username
+	}
+	return ""
+}
+
+func encodePayload(purchaseID int64, username string) string {
+	values := url.Values{}
+	values.Set("purchaseId", strconv.FormatInt(purchaseID, 10))
+	values.Set("username", username)
+	return values.Encode()
+}
+
+func decodePayload(payload string) (purchaseID int64, username string, err error) {
+	values, err := url.ParseQuery(payload)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid invoice payload: %w", err)
+	}
+	purchaseID, err = strconv.ParseInt(values.Get("purchaseId"), 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid purchase id in invoice payload: %w", err)
+	}
+	return purchaseID, values.Get("username"), nil
+}
@@ -0,0 +1,89 @@
+package cryptopay
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/payment"
+	"time"
+)
+
+func (p *Provider) WebhookPath() string {
+	return config.CryptoPayWebhookUrl()
+}
+
+func (p *Provider) WebhookHandler(processor payment.Processor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*60)
+		defer cancel()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			slog.Error("cryptopay webhook: read body error", "error", err)
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		signature := r.Header.Get("crypto-pay-api-signature")
+		if signature == "" {
+			http.Error(w, "missing signature", http.StatusUnauthorized)
+			return
+		}
+
+		if !verifySignature(p.client.token, body, signature) {
+			slog.Warn("cryptopay webhook: bad signature")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var update Update
+		if err := json.Unmarshal(body, &update); err != nil {
+			slog.Error("cryptopay webhook: unmarshal error", "error", err)
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		if update.UpdateType != UpdateTypeInvoicePaid || !update.Payload.IsPaid() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		purchaseId, username, err := decodePayload(update.Payload.Payload)
+		if err != nil {
+			slog.Error("cryptopay webhook: payload error", "invoiceId", update.Payload.InvoiceID, "error", err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		err = processor.SavePaymentDetails(ctx, purchaseId, update.Payload.PaymentFields())
+		if err != nil {
+			slog.Error("cryptopay webhook: save payment details error", "invoiceId", update.Payload.InvoiceID, "purchaseId", purchaseId, "error", err)
+		}
+
+		ctxWithUsername := context.WithValue(ctx, "username", username)
+		err = processor.ProcessPurchaseById(ctxWithUsername, purchaseId)
+		if err != nil {
+			slog.Error("cryptopay webhook: process purchase error", "invoiceId", update.Payload.InvoiceID, "purchaseId", purchaseId, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Invoice processed", "invoiceId", update.Payload.InvoiceID, "purchaseId", purchaseId)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// verifySignature checks the update body against its HMAC-SHA256 signature keyed by SHA256 of the API token.
+func verifySignature(token string, body []byte, signature string) bool {
+	secret := sha256.Sum256([]byte(token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,168 @@
+package cryptopay
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"remnawave-tg-shop-bot/internal/database"
+)
+
+const testToken = "12345:AAtesttoken"
+
+// sign signs a body the way CryptoPay does, an HMAC-SHA256 keyed by SHA256 of the API token.
+func sign(token string, body []byte) string {
+	secret := sha256.Sum256([]byte(token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type fakeProcessor struct {
+	processed []int64
+	username  string
+}
+
+func (f *fakeProcessor) CreatePurchase(ctx context.Context, amount int, currency string, months int, customer *database.Customer, invoiceType database.InvoiceType) (string, int64, error) {
+	return "", 0, nil
+}
+
+func (f *fakeProcessor) ProcessPurchaseById(ctx context.Context, purchaseId int64) error {
+	f.processed = append(f.processed, purchaseId)
+	f.username, _ = ctx.Value("username").(string)
+	return nil
+}
+
+func (f *fakeProcessor) CancelPayment(purchaseId int64) error {
+	return nil
+}
+
+func (f *fakeProcessor) SavePaymentMethod(ctx context.Context, purchaseId int64, paymentMethodID uuid.UUID) error {
+	return nil
+}
+
+func (f *fakeProcessor) SavePaymentDetails(ctx context.Context, purchaseId int64, fields map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeProcessor) ApplyRefund(ctx context.Context, purchaseId int64) error {
+	return nil
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"update_id":1,"update_type":"invoice_paid"}`)
+
+	tests := []struct {
+		name      string
+		token     string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid signature", testToken, body, sign(testToken, body), true},
+		{"tampered body", testToken, []byte(`{"update_id":2,"update_type":"invoice_paid"}`), sign(testToken, body), false},
+		{"wrong token", testToken, body, sign("54321:AAothertoken", body), false},
+		{"signed with the raw token", testToken, body, rawTokenSignature(testToken, body), false},
+		{"empty signature", testToken, body, "", false},
+		{"upper-case hex", testToken, body, strings.ToUpper(sign(testToken, body)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifySignature(tt.token, tt.body, tt.signature); got != tt.want {
+				t.Errorf("verifySignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func rawTokenSignature(token string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	paid := []byte(`{"update_id":1,"update_type":"invoice_paid","payload":{"invoice_id":7,"status":"paid","payload":"` + encodePayload(42, "alice") + `"}}`)
+	active := []byte(`{"update_id":2,"update_type":"invoice_paid","payload":{"invoice_id":8,"status":"active","payload":"` + encodePayload(43, "bob") + `"}}`)
+
+	tests := []struct {
+		name          string
+		body          []byte
+		signature     string
+		wantStatus    int
+		wantProcessed []int64
+	}{
+		{"valid signature", paid, sign(testToken, paid), http.StatusOK, []int64{42}},
+		{"unpaid invoice", active, sign(testToken, active), http.StatusOK, nil},
+		{"tampered body", []byte(strings.Replace(string(paid), "42", "41", 1)), sign(testToken, paid), http.StatusUnauthorized, nil},
+		{"wrong token", paid, sign("54321:AAothertoken", paid), http.StatusUnauthorized, nil},
+		{"missing signature", paid, "", http.StatusUnauthorized, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewProvider(NewCryptoPayClient("http://cryptopay.invalid", testToken), nil)
+			processor := &fakeProcessor{}
+
+			r := httptest.NewRequest(http.MethodPost, "/cryptopay", strings.NewReader(string(tt.body)))
+			if tt.signature != "" {
+				r.Header.Set("crypto-pay-api-signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			provider.WebhookHandler(processor).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if len(processor.processed) != len(tt.wantProcessed) || (len(tt.wantProcessed) > 0 && processor.processed[0] != tt.wantProcessed[0]) {
+				t.Errorf("processed purchases = %v, want %v", processor.processed, tt.wantProcessed)
+			}
+			if len(tt.wantProcessed) > 0 && processor.username != "alice" {
+				t.Errorf("username = %q, want %q", processor.username, "alice")
+			}
+		})
+	}
+}
+
+func TestDecodePayloadRoundTrip(t *testing.T) {
+	tests := []struct {
+		purchaseID int64
+		username   string
+	}{
+		{1, "alice"},
+		{9223372036854775807, ""},
+		{42, "name with spaces&symbols=?"},
+	}
+
+	for _, tt := range tests {
+		purchaseID, username, err := decodePayload(encodePayload(tt.purchaseID, tt.username))
+		if err != nil {
+			t.Fatalf("decodePayload(encodePayload(%d, %q)): %v", tt.purchaseID, tt.username, err)
+		}
+		if purchaseID != tt.purchaseID || username != tt.username {
+			t.Errorf("decodePayload = %d, %q, want %d, %q", purchaseID, username, tt.purchaseID, tt.username)
+		}
+	}
+}
+
+func TestDecodePayloadRejectsMalformedPayload(t *testing.T) {
+	for _, payload := range []string{
+		"",
+		"username=alice",
+		"purchaseId=abc&username=alice",
+		"purchaseId=1%ZZ",
+		"42&alice",
+	} {
+		if _, _, err := decodePayload(payload); err == nil {
+			t.Errorf("decodePayload(%q) succeeded, want an error", payload)
+		}
+	}
+}
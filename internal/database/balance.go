@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+type BalanceReason string
+
+const (
+	BalanceReasonTopUp    BalanceReason = "top_up"
+	BalanceReasonPurchase BalanceReason = "purchase"
+	BalanceReasonRefund   BalanceReason = "refund"
+	BalanceReasonReferral BalanceReason = "referral_bonus"
+)
+
+// BalanceTransaction is an entry of the customer balance ledger, credits are positive and debits negative.
+// PurchaseID references the purchase the entry was made for, each purchase has at most one entry per reason.
+type BalanceTransaction struct {
+	ID         int64         `db:"id"`
+	CustomerID int64         `db:"customer_id"`
+	Amount     float64       `db:"amount"`
+	Currency   string        `db:"currency"`
+	Reason     BalanceReason `db:"reason"`
+	PurchaseID *int64        `db:"purchase_id"`
+	CreatedAt  time.Time     `db:"created_at"`
+}
+
+type BalanceRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewBalanceRepository(pool *pgxpool.Pool) *BalanceRepository {
+	return &BalanceRepository{pool: pool}
+}
+
+// Balances sums the ledger of a customer per currency.
+func (br *BalanceRepository) Balances(ctx context.Context, customerID int64) (map[string]float64, error) {
+	buildSelect := sq.Select("currency", "SUM(amount)").
+		From("balance_transaction").
+		Where(sq.Eq{"customer_id": customerID}).
+		GroupBy("currency").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	rows, err := br.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query balances: %w", err)
+	}
+	defer rows.Close()
+
+	balances := make(map[string]float64)
+	for rows.Next() {
+		var currency string
+		var amount float64
+		if err := rows.Scan(&currency, &amount); err != nil {
+			return nil, fmt.Errorf("failed to scan balance row: %w", err)
+		}
+		balances[currency] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over balance rows: %w", err)
+	}
+
+	return balances, nil
+}
+
+// Credit adds a positive entry to the ledger. It returns false when the purchase already has an entry
+// for the reason, so repeated credits for the same purchase are no-ops.
+func (br *BalanceRepository) Credit(ctx context.Context, entry *BalanceTransaction) (bool, error) {
+	if entry.Amount <= 0 {
+		return false, fmt.Errorf("credit amount must be positive, got %v", entry.Amount)
+	}
+
+	tx, err := br.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	inserted, err := insertBalanceTransaction(ctx, tx, entry)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return inserted, nil
+}
+
+// Debit takes an amount off the balance. The customer row is locked while the balance is checked,
+// so concurrent debits can't overdraw it. Returns ErrInsufficientBalance when the balance is too low.
+// A new purchase paid with the debit moves to pending in the same transaction, so a debited purchase is never left new.
+func (br *BalanceRepository) Debit(ctx context.Context, entry *BalanceTransaction) error {
+	if entry.Amount <= 0 {
+		return fmt.Errorf("debit amount must be positive, got %v", entry.Amount)
+	}
+
+	tx, err := br.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var customerID int64
+	err = tx.QueryRow(ctx, "SELECT id FROM customer WHERE id = $1 FOR UPDATE", entry.CustomerID).Scan(&customerID)
+	if err != nil {
+		return fmt.Errorf("failed to lock customer: %w", err)
+	}
+
+	var balance float64
+	err = tx.QueryRow(ctx, "SELECT COALESCE(SUM(amount), 0) FROM balance_transaction WHERE customer_id = $1 AND currency = $2", entry.CustomerID, entry.Currency).Scan(&balance)
+	if err != nil {
+		return fmt.Errorf("failed to query balance: %w", err)
+	}
+	if balance < entry.Amount {
+		return ErrInsufficientBalance
+	}
+
+	debit := *entry
+	debit.Amount = -entry.Amount
+	inserted, err := insertBalanceTransaction(ctx, tx, &debit)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		return fmt.Errorf("purchase %d is already debited", *entry.PurchaseID)
+	}
+	if entry.Reason == BalanceReasonPurchase && entry.PurchaseID != nil {
+		_, err = tx.Exec(ctx, "UPDATE purchase SET status = $1 WHERE id = $2 AND status = $3", PurchaseStatusPending, *entry.PurchaseID, PurchaseStatusNew)
+		if err != nil {
+			return fmt.Errorf("failed to update purchase status: %w", err)
+		}
+	}
+	entry.ID = debit.ID
+	entry.CreatedAt = debit.CreatedAt
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (br *BalanceRepository) FindByPurchase(ctx context.Context, purchaseID int64, reason BalanceReason) (*BalanceTransaction, error) {
+	buildSelect := sq.Select("id", "customer_id", "amount", "currency", "reason", "purchase_id", "created_at").
+		From("balance_transaction").
+		Where(sq.Eq{"purchase_id": purchaseID, "reason": reason}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	entry := &BalanceTransaction{}
+	err = br.pool.QueryRow(ctx, sql, args...).Scan(&entry.ID, &entry.CustomerID, &entry.Amount, &entry.Currency, &entry.Reason, &entry.PurchaseID, &entry.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query balance transaction: %w", err)
+	}
+	return entry, nil
+}
+
+func insertBalanceTransaction(ctx context.Context, tx pgx.Tx, entry *BalanceTransaction) (bool, error) {
+	buildInsert := sq.Insert("balance_transaction").
+		Columns("customer_id", "amount", "currency", "reason", "purchase_id").
+		Values(entry.CustomerID, entry.Amount, entry.Currency, entry.Reason, entry.PurchaseID).
+		Suffix("ON CONFLICT (purchase_id, reason) WHERE purchase_id IS NOT NULL DO NOTHING RETURNING id, created_at").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildInsert.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to build insert query: %w", err)
+	}
+
+	err = tx.QueryRow(ctx, sql, args...).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to insert balance transaction: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"strings"
+	"time"
+)
+
+// Gift is a subscription paid by one customer and redeemed by another through a deep link.
+type Gift struct {
+	ID          int64      `db:"id"`
+	Code        string     `db:"code"`
+	PurchaseID  int64      `db:"purchase_id"`
+	BuyerID     int64      `db:"buyer_id"`
+	RecipientID *int64     `db:"recipient_id"`
+	RedeemedAt  *time.Time `db:"redeemed_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
+
+var giftColumns = []string{"id", "code", "purchase_id", "buyer_id", "recipient_id", "redeemed_at", "created_at"}
+
+func scanGift(row rowScanner, gift *Gift) error {
+	return row.Scan(
+		&gift.ID,
+		&gift.Code,
+		&gift.PurchaseID,
+		&gift.BuyerID,
+		&gift.RecipientID,
+		&gift.RedeemedAt,
+		&gift.CreatedAt,
+	)
+}
+
+type GiftRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewGiftRepository(pool *pgxpool.Pool) *GiftRepository {
+	return &GiftRepository{pool: pool}
+}
+
+// Create issues the gift of a purchase. A purchase has a single gift, creating it again returns the existing one.
+func (gr *GiftRepository) Create(ctx context.Context, gift *Gift) (*Gift, error) {
+	buildInsert := sq.Insert("gift").
+		Columns("code", "purchase_id", "buyer_id").
+		Values(gift.Code, gift.PurchaseID, gift.BuyerID).
+		Suffix("ON CONFLICT (purchase_id) DO UPDATE SET purchase_id = EXCLUDED.purchase_id RETURNING " + strings.Join(giftColumns, ", ")).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildInsert.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build insert query: %w", err)
+	}
+
+	created := &Gift{}
+	if err := scanGift(gr.pool.QueryRow(ctx, sql, args...), created); err != nil {
+		return nil, fmt.Errorf("failed to insert gift: %w", err)
+	}
+	return created, nil
+}
+
+func (gr *GiftRepository) FindByCode(ctx context.Context, code string) (*Gift, error) {
+	return gr.findOne(ctx, sq.Eq{"code": code})
+}
+
+func (gr *GiftRepository) FindByPurchase(ctx context.Context, purchaseID int64) (*Gift, error) {
+	return gr.findOne(ctx, sq.Eq{"purchase_id": purchaseID})
+}
+
+func (gr *GiftRepository) findOne(ctx context.Context, where sq.Eq) (*Gift, error) {
+	buildSelect := sq.Select(giftColumns...).
+		From("gift").
+		Where(where).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	gift := &Gift{}
+	if err := scanGift(gr.pool.QueryRow(ctx, sql, args...), gift); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query gift: %w", err)
+	}
+	return gift, nil
+}
+
+// FindUnredeemed lists gifts nobody redeemed yet, oldest first.
+func (gr *GiftRepository) FindUnredeemed(ctx context.Context) ([]Gift, error) {
+	buildSelect := sq.Select(giftColumns...).
+		From("gift").
+		Where(sq.Eq{"redeemed_at": nil}).
+		OrderBy("created_at").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	rows, err := gr.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query gifts: %w", err)
+	}
+	defer rows.Close()
+
+	var gifts []Gift
+	for rows.Next() {
+		var gift Gift
+		if err := scanGift(rows, &gift); err != nil {
+			return nil, fmt.Errorf("failed to scan gift row: %w", err)
+		}
+		gifts = append(gifts, gift)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over gift rows: %w", err)
+	}
+
+	return gifts, nil
+}
+
+// Redeem assigns an unredeemed gift to its recipient. It returns nil when the code is unknown or already redeemed,
+// so concurrent redemptions of the same link can't both succeed.
+func (gr *GiftRepository) Redeem(ctx context.Context, code string, recipientID int64) (*Gift, error) {
+	buildUpdate := sq.Update("gift").
+		Set("recipient_id", recipientID).
+		Set("redeemed_at", sq.Expr("NOW()")).
+		Where(sq.And{
+			sq.Eq{"code": code},
+			sq.Eq{"redeemed_at": nil},
+		}).
+		Suffix("RETURNING " + strings.Join(giftColumns, ", ")).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build update query: %w", err)
+	}
+
+	gift := &Gift{}
+	if err := scanGift(gr.pool.QueryRow(ctx, sql, args...), gift); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to redeem gift: %w", err)
+	}
+	return gift, nil
+}
+
+// Unredeem makes a gift redeemable again, used when the subscription of the recipient couldn't be extended.
+func (gr *GiftRepository) Unredeem(ctx context.Context, id int64) error {
+	buildUpdate := sq.Update("gift").
+		Set("recipient_id", nil).
+		Set("redeemed_at", nil).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build update query: %w", err)
+	}
+
+	if _, err := gr.pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("failed to unredeem gift: %w", err)
+	}
+	return nil
+}
+
+// DeleteUnredeemed removes the gift of a purchase unless it was already redeemed, it reports whether it was removed.
+func (gr *GiftRepository) DeleteUnredeemed(ctx context.Context, purchaseID int64) (bool, error) {
+	buildDelete := sq.Delete("gift").
+		Where(sq.And{
+			sq.Eq{"purchase_id": purchaseID},
+			sq.Eq{"redeemed_at": nil},
+		}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildDelete.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to build delete query: %w", err)
+	}
+
+	result, err := gr.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete gift: %w", err)
+	}
+	return result.RowsAffected() > 0, nil
+}
@@ -0,0 +1,258 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type DiscountType string
+
+const (
+	DiscountTypePercent DiscountType = "percent"
+	DiscountTypeFixed   DiscountType = "fixed"
+)
+
+var (
+	ErrPromoCodeExhausted   = errors.New("promo code has been used up")
+	ErrPromoCodeAlreadyUsed = errors.New("promo code already used by customer")
+)
+
+// queryRower is a pool or a transaction.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+type PromoCode struct {
+	ID            int64        `db:"id"`
+	Code          string       `db:"code"`
+	DiscountType  DiscountType `db:"discount_type"`
+	DiscountValue int          `db:"discount_value"`
+	Months        []int32      `db:"months"`
+	MaxUses       *int         `db:"max_uses"`
+	PerUserLimit  int          `db:"per_user_limit"`
+	ValidFrom     *time.Time   `db:"valid_from"`
+	ValidUntil    *time.Time   `db:"valid_until"`
+	CreatedAt     time.Time    `db:"created_at"`
+}
+
+var promoCodeColumns = []string{"id", "code", "discount_type", "discount_value", "months", "max_uses", "per_user_limit", "valid_from", "valid_until", "created_at"}
+
+func scanPromoCode(row rowScanner, promo *PromoCode) error {
+	return row.Scan(
+		&promo.ID,
+		&promo.Code,
+		&promo.DiscountType,
+		&promo.DiscountValue,
+		&promo.Months,
+		&promo.MaxUses,
+		&promo.PerUserLimit,
+		&promo.ValidFrom,
+		&promo.ValidUntil,
+		&promo.CreatedAt,
+	)
+}
+
+type PromoCodeRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPromoCodeRepository(pool *pgxpool.Pool) *PromoCodeRepository {
+	return &PromoCodeRepository{pool: pool}
+}
+
+func (pr *PromoCodeRepository) Create(ctx context.Context, promo *PromoCode) (*PromoCode, error) {
+	buildInsert := sq.Insert("promo_code").
+		Columns("code", "discount_type", "discount_value", "months", "max_uses", "per_user_limit", "valid_from", "valid_until").
+		Values(promo.Code, promo.DiscountType, promo.DiscountValue, promo.Months, promo.MaxUses, promo.PerUserLimit, promo.ValidFrom, promo.ValidUntil).
+		Suffix("RETURNING id, created_at").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildInsert.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build insert query: %w", err)
+	}
+
+	if err := pr.pool.QueryRow(ctx, sql, args...).Scan(&promo.ID, &promo.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to insert promo code: %w", err)
+	}
+	return promo, nil
+}
+
+func (pr *PromoCodeRepository) FindById(ctx context.Context, id int64) (*PromoCode, error) {
+	return pr.findOne(ctx, sq.Eq{"id": id})
+}
+
+func (pr *PromoCodeRepository) FindByCode(ctx context.Context, code string) (*PromoCode, error) {
+	return pr.findOne(ctx, sq.Eq{"code": code})
+}
+
+func (pr *PromoCodeRepository) findOne(ctx context.Context, where sq.Eq) (*PromoCode, error) {
+	buildSelect := sq.Select(promoCodeColumns...).
+		From("promo_code").
+		Where(where).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	promo := &PromoCode{}
+	err = scanPromoCode(pr.pool.QueryRow(ctx, sql, args...), promo)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query promo code: %w", err)
+	}
+	return promo, nil
+}
+
+func (pr *PromoCodeRepository) FindAll(ctx context.Context) ([]PromoCode, error) {
+	buildSelect := sq.Select(promoCodeColumns...).
+		From("promo_code").
+		OrderBy("created_at DESC").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	rows, err := pr.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query promo codes: %w", err)
+	}
+	defer rows.Close()
+
+	var promos []PromoCode
+	for rows.Next() {
+		var promo PromoCode
+		if err := scanPromoCode(rows, &promo); err != nil {
+			return nil, fmt.Errorf("failed to scan promo code row: %w", err)
+		}
+		promos = append(promos, promo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over promo code rows: %w", err)
+	}
+
+	return promos, nil
+}
+
+// CountRedemptions counts paid purchases made with the promo code, limited to one customer when customerID is set.
+func (pr *PromoCodeRepository) CountRedemptions(ctx context.Context, promoCodeID int64, customerID *int64) (int, error) {
+	return countRedemptions(ctx, pr.pool, promoCodeID, customerID)
+}
+
+// Usage counts the uses of the promo code that limit a new redemption by the customer.
+func (pr *PromoCodeRepository) Usage(ctx context.Context, promoCodeID int64, customerID int64) (PromoUsage, error) {
+	return promoUsage(ctx, pr.pool, promoCodeID, customerID)
+}
+
+// PromoUsage is how much of a promo code is taken when a customer redeems it. Unpaid purchases of other customers
+// are reservations: their invoices can still be paid, so they hold a use of the code until they expire.
+type PromoUsage struct {
+	Redemptions         int
+	CustomerRedemptions int
+	Reservations        int
+}
+
+// CheckUsage reports whether the limits of the promo code leave a use for the customer.
+func (p *PromoCode) CheckUsage(usage PromoUsage) error {
+	if p.MaxUses != nil && usage.Redemptions+usage.Reservations >= *p.MaxUses {
+		return ErrPromoCodeExhausted
+	}
+	if p.PerUserLimit > 0 && usage.CustomerRedemptions >= p.PerUserLimit {
+		return ErrPromoCodeAlreadyUsed
+	}
+	return nil
+}
+
+// lockPromoCode locks the promo code row until the transaction ends, so uses of the code are counted one at a time.
+func lockPromoCode(ctx context.Context, tx pgx.Tx, promoCodeID int64) (*PromoCode, error) {
+	buildSelect := sq.Select(promoCodeColumns...).
+		From("promo_code").
+		Where(sq.Eq{"id": promoCodeID}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	promo := &PromoCode{}
+	if err := scanPromoCode(tx.QueryRow(ctx, sql, args...), promo); err != nil {
+		return nil, fmt.Errorf("failed to lock promo code: %w", err)
+	}
+	return promo, nil
+}
+
+func promoUsage(ctx context.Context, q queryRower, promoCodeID int64, customerID int64) (PromoUsage, error) {
+	var usage PromoUsage
+	var err error
+	if usage.Redemptions, err = countRedemptions(ctx, q, promoCodeID, nil); err != nil {
+		return usage, err
+	}
+	if usage.CustomerRedemptions, err = countRedemptions(ctx, q, promoCodeID, &customerID); err != nil {
+		return usage, err
+	}
+	if usage.Reservations, err = countReservations(ctx, q, promoCodeID, customerID); err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+func countRedemptions(ctx context.Context, q queryRower, promoCodeID int64, customerID *int64) (int, error) {
+	where := sq.And{
+		sq.Eq{"promo_code_id": promoCodeID},
+		sq.Eq{"status": []PurchaseStatus{PurchaseStatusProcessing, PurchaseStatusPaid}},
+	}
+	if customerID != nil {
+		where = append(where, sq.Eq{"customer_id": *customerID})
+	}
+
+	buildSelect := sq.Select("COUNT(*)").
+		From("purchase").
+		Where(where).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build count query: %w", err)
+	}
+
+	var count int
+	if err := q.QueryRow(ctx, sql, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count promo code redemptions: %w", err)
+	}
+	return count, nil
+}
+
+func countReservations(ctx context.Context, q queryRower, promoCodeID int64, exceptCustomerID int64) (int, error) {
+	buildSelect := sq.Select("COUNT(*)").
+		From("purchase").
+		Where(sq.And{
+			sq.Eq{"promo_code_id": promoCodeID},
+			sq.Eq{"status": []PurchaseStatus{PurchaseStatusNew, PurchaseStatusPending}},
+			sq.NotEq{"customer_id": exceptCustomerID},
+		}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build count query: %w", err)
+	}
+
+	var count int
+	if err := q.QueryRow(ctx, sql, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count promo code reservations: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"strings"
+	"time"
+)
+
+type ProvisioningJobStatus string
+
+const (
+	ProvisioningJobStatusPending ProvisioningJobStatus = "pending"
+	ProvisioningJobStatusRunning ProvisioningJobStatus = "running"
+	ProvisioningJobStatusDone    ProvisioningJobStatus = "done"
+)
+
+// ProvisioningJob fulfils a purchase whose payment was confirmed. Failed attempts are retried once NextRunAt passes.
+// A running job whose NextRunAt passed was left by a crashed worker and is picked up again.
+// PanelAppliedAt is set once the purchase was applied on the panel, so a retry doesn't apply it twice.
+type ProvisioningJob struct {
+	ID             int64                 `db:"id"`
+	PurchaseID     int64                 `db:"purchase_id"`
+	Status         ProvisioningJobStatus `db:"status"`
+	Attempts       int                   `db:"attempts"`
+	NextRunAt      time.Time             `db:"next_run_at"`
+	LastError      *string               `db:"last_error"`
+	PanelAppliedAt *time.Time            `db:"panel_applied_at"`
+	CreatedAt      time.Time             `db:"created_at"`
+	UpdatedAt      time.Time             `db:"updated_at"`
+}
+
+var provisioningJobColumns = []string{"id", "purchase_id", "status", "attempts", "next_run_at", "last_error", "panel_applied_at", "created_at", "updated_at"}
+
+func scanProvisioningJob(row rowScanner, job *ProvisioningJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.PurchaseID,
+		&job.Status,
+		&job.Attempts,
+		&job.NextRunAt,
+		&job.LastError,
+		&job.PanelAppliedAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+}
+
+type ProvisioningJobRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewProvisioningJobRepository(pool *pgxpool.Pool) *ProvisioningJobRepository {
+	return &ProvisioningJobRepository{pool: pool}
+}
+
+// Start creates the running job of a purchase, or takes over its existing one, leased until the given time.
+func (jr *ProvisioningJobRepository) Start(ctx context.Context, purchaseID int64, leaseUntil time.Time) (*ProvisioningJob, error) {
+	buildInsert := sq.Insert("provisioning_job").
+		Columns("purchase_id", "status", "next_run_at").
+		Values(purchaseID, ProvisioningJobStatusRunning, leaseUntil).
+		Suffix("ON CONFLICT (purchase_id) DO UPDATE SET status = EXCLUDED.status, next_run_at = EXCLUDED.next_run_at, updated_at = NOW() RETURNING " + strings.Join(provisioningJobColumns, ", ")).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildInsert.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build insert query: %w", err)
+	}
+
+	job := &ProvisioningJob{}
+	if err := scanProvisioningJob(jr.pool.QueryRow(ctx, sql, args...), job); err != nil {
+		return nil, fmt.Errorf("failed to insert provisioning job: %w", err)
+	}
+	return job, nil
+}
+
+// ClaimDue leases the jobs due before the given time to the caller, concurrent workers get disjoint jobs.
+func (jr *ProvisioningJobRepository) ClaimDue(ctx context.Context, now time.Time, leaseUntil time.Time, limit uint64) ([]ProvisioningJob, error) {
+	due := sq.Select("id").
+		From("provisioning_job").
+		Where(sq.And{
+			sq.NotEq{"status": ProvisioningJobStatusDone},
+			sq.LtOrEq{"next_run_at": now},
+		}).
+		OrderBy("next_run_at").
+		Limit(limit).
+		Suffix("FOR UPDATE SKIP LOCKED")
+
+	buildUpdate := sq.Update("provisioning_job").
+		Set("status", ProvisioningJobStatusRunning).
+		Set("next_run_at", leaseUntil).
+		Set("updated_at", sq.Expr("NOW()")).
+		Where(sq.Expr("id IN (?)", due)).
+		Suffix("RETURNING " + strings.Join(provisioningJobColumns, ", ")).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build update query: %w", err)
+	}
+
+	rows, err := jr.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim provisioning jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []ProvisioningJob
+	for rows.Next() {
+		var job ProvisioningJob
+		if err := scanProvisioningJob(rows, &job); err != nil {
+			return nil, fmt.Errorf("failed to scan provisioning job row: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over provisioning job rows: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// ClaimPending leases a job waiting for its next attempt right away. It returns nil when the job is running or done.
+func (jr *ProvisioningJobRepository) ClaimPending(ctx context.Context, id int64, leaseUntil time.Time) (*ProvisioningJob, error) {
+	buildUpdate := sq.Update("provisioning_job").
+		Set("status", ProvisioningJobStatusRunning).
+		Set("next_run_at", leaseUntil).
+		Set("updated_at", sq.Expr("NOW()")).
+		Where(sq.And{
+			sq.Eq{"id": id},
+			sq.Eq{"status": ProvisioningJobStatusPending},
+		}).
+		Suffix("RETURNING " + strings.Join(provisioningJobColumns, ", ")).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build update query: %w", err)
+	}
+
+	job := &ProvisioningJob{}
+	if err := scanProvisioningJob(jr.pool.QueryRow(ctx, sql, args...), job); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim provisioning job: %w", err)
+	}
+	return job, nil
+}
+
+func (jr *ProvisioningJobRepository) MarkDone(ctx context.Context, id int64) error {
+	return jr.update(ctx, id, map[string]interface{}{
+		"status": ProvisioningJobStatusDone,
+	})
+}
+
+// MarkPanelApplied records that the purchase of the job was applied on the panel.
+func (jr *ProvisioningJobRepository) MarkPanelApplied(ctx context.Context, id int64) error {
+	return jr.update(ctx, id, map[string]interface{}{
+		"panel_applied_at": sq.Expr("NOW()"),
+	})
+}
+
+// MarkFailed records a failed attempt and schedules the next one.
+func (jr *ProvisioningJobRepository) MarkFailed(ctx context.Context, id int64, attempts int, nextRunAt time.Time, lastError string) error {
+	return jr.update(ctx, id, map[string]interface{}{
+		"status":      ProvisioningJobStatusPending,
+		"attempts":    attempts,
+		"next_run_at": nextRunAt,
+		"last_error":  lastError,
+	})
+}
+
+func (jr *ProvisioningJobRepository) update(ctx context.Context, id int64, updates map[string]interface{}) error {
+	buildUpdate := sq.Update("provisioning_job").
+		Set("updated_at", sq.Expr("NOW()")).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar)
+
+	for field, value := range updates {
+		buildUpdate = buildUpdate.Set(field, value)
+	}
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build update query: %w", err)
+	}
+
+	if _, err := jr.pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("failed to update provisioning job: %w", err)
+	}
+	return nil
+}
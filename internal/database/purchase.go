@@ -18,32 +18,109 @@ const (
 	InvoiceTypeYookasa  InvoiceType = "yookasa"
 	InvoiceTypeTelegram InvoiceType = "telegram"
 	InvoiceTypeTribute  InvoiceType = "tribute"
+	InvoiceTypeBalance  InvoiceType = "balance"
+	InvoiceTypeManual   InvoiceType = "manual"
 )
 
 type PurchaseStatus string
 
 const (
-	PurchaseStatusNew     PurchaseStatus = "new"
-	PurchaseStatusPending PurchaseStatus = "pending"
-	PurchaseStatusPaid    PurchaseStatus = "paid"
-	PurchaseStatusCancel  PurchaseStatus = "cancel"
+	PurchaseStatusNew        PurchaseStatus = "new"
+	PurchaseStatusPending    PurchaseStatus = "pending"
+	PurchaseStatusProcessing PurchaseStatus = "processing"
+	PurchaseStatusPaid       PurchaseStatus = "paid"
+	PurchaseStatusCancel     PurchaseStatus = "cancel"
+	PurchaseStatusRefunded   PurchaseStatus = "refunded"
+	PurchaseStatusExpired    PurchaseStatus = "expired"
+)
+
+// PurchaseKind is what a purchase buys.
+type PurchaseKind string
+
+const (
+	PurchaseKindSubscription PurchaseKind = "subscription"
+	PurchaseKindBalance      PurchaseKind = "balance"
+	PurchaseKindGift         PurchaseKind = "gift"
+	PurchaseKindTraffic      PurchaseKind = "traffic"
+	PurchaseKindDevices      PurchaseKind = "devices"
 )
 
 type Purchase struct {
-	ID                int64          `db:"id"`
-	Amount            float64        `db:"amount"`
-	CustomerID        int64          `db:"customer_id"`
-	CreatedAt         time.Time      `db:"created_at"`
-	Month             int            `db:"month"`
-	PaidAt            *time.Time     `db:"paid_at"`
-	Currency          string         `db:"currency"`
-	ExpireAt          *time.Time     `db:"expire_at"`
-	Status            PurchaseStatus `db:"status"`
-	InvoiceType       InvoiceType    `db:"invoice_type"`
-	CryptoInvoiceID   *int64         `db:"crypto_invoice_id"`
-	CryptoInvoiceLink *string        `db:"crypto_invoice_url"`
-	YookasaURL        *string        `db:"yookasa_url"`
-	YookasaID         *uuid.UUID     `db:"yookasa_id"`
+	ID                  int64          `db:"id"`
+	Amount              float64        `db:"amount"`
+	CustomerID          int64          `db:"customer_id"`
+	CreatedAt           time.Time      `db:"created_at"`
+	Month               int            `db:"month"`
+	PaidAt              *time.Time     `db:"paid_at"`
+	Currency            string         `db:"currency"`
+	ExpireAt            *time.Time     `db:"expire_at"`
+	Status              PurchaseStatus `db:"status"`
+	InvoiceType         InvoiceType    `db:"invoice_type"`
+	CryptoInvoiceID     *int64         `db:"crypto_invoice_id"`
+	CryptoInvoiceLink   *string        `db:"crypto_invoice_url"`
+	YookasaURL          *string        `db:"yookasa_url"`
+	YookasaID           *uuid.UUID     `db:"yookasa_id"`
+	ParentPurchaseID    *int64         `db:"parent_purchase_id"`
+	ExternalID          *string        `db:"external_id"`
+	TelegramChargeID    *string        `db:"telegram_payment_charge_id"`
+	TrafficLimit        *int           `db:"traffic_limit"`
+	PromoCodeID         *int64         `db:"promo_code_id"`
+	Discount            *float64       `db:"discount"`
+	TariffID            *int64         `db:"tariff_id"`
+	DurationDays        *int           `db:"duration_days"`
+	PaidAsset           *string        `db:"paid_asset"`
+	PaidAmount          *float64       `db:"paid_amount"`
+	PaidFiatRate        *float64       `db:"paid_fiat_rate"`
+	FeeAmount           *float64       `db:"fee_amount"`
+	Kind                PurchaseKind   `db:"kind"`
+	DeviceLimit         *int           `db:"device_limit"`
+	PreviousDeviceLimit *int           `db:"previous_device_limit"`
+	AutoRenew           bool           `db:"auto_renew"`
+}
+
+// Days is the subscription length the purchase grants, purchases made before tariffs count 30 days a month.
+func (p *Purchase) Days() int {
+	if p.DurationDays != nil {
+		return *p.DurationDays
+	}
+	return p.Month * 30
+}
+
+var purchaseColumns = []string{"id", "amount", "customer_id", "created_at", "month", "paid_at", "currency", "expire_at", "status", "invoice_type", "crypto_invoice_id", "crypto_invoice_url", "yookasa_url", "yookasa_id", "parent_purchase_id", "external_id", "telegram_payment_charge_id", "traffic_limit", "promo_code_id", "discount", "tariff_id", "duration_days", "paid_asset", "paid_amount", "paid_fiat_rate", "fee_amount", "kind", "device_limit", "previous_device_limit", "auto_renew"}
+
+func scanPurchase(row rowScanner, purchase *Purchase) error {
+	return row.Scan(
+		&purchase.ID,
+		&purchase.Amount,
+		&purchase.CustomerID,
+		&purchase.CreatedAt,
+		&purchase.Month,
+		&purchase.PaidAt,
+		&purchase.Currency,
+		&purchase.ExpireAt,
+		&purchase.Status,
+		&purchase.InvoiceType,
+		&purchase.CryptoInvoiceID,
+		&purchase.CryptoInvoiceLink,
+		&purchase.YookasaURL,
+		&purchase.YookasaID,
+		&purchase.ParentPurchaseID,
+		&purchase.ExternalID,
+		&purchase.TelegramChargeID,
+		&purchase.TrafficLimit,
+		&purchase.PromoCodeID,
+		&purchase.Discount,
+		&purchase.TariffID,
+		&purchase.DurationDays,
+		&purchase.PaidAsset,
+		&purchase.PaidAmount,
+		&purchase.PaidFiatRate,
+		&purchase.FeeAmount,
+		&purchase.Kind,
+		&purchase.DeviceLimit,
+		&purchase.PreviousDeviceLimit,
+		&purchase.AutoRenew,
+	)
 }
 
 type PurchaseRepository struct {
@@ -57,9 +134,57 @@ func NewPurchaseRepository(pool *pgxpool.Pool) *PurchaseRepository {
 }
 
 func (cr *PurchaseRepository) Create(ctx context.Context, purchase *Purchase) (int64, error) {
+	return insertPurchase(ctx, cr.pool, purchase)
+}
+
+// CreateWithPromo creates a purchase made with a promo code once the limits of the code leave a use for the customer.
+// The code is locked while its uses are counted, so concurrent checkouts can't take the same last use.
+func (cr *PurchaseRepository) CreateWithPromo(ctx context.Context, purchase *Purchase) (int64, error) {
+	if purchase.PromoCodeID == nil {
+		return cr.Create(ctx, purchase)
+	}
+
+	tx, err := cr.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkPromoUsage(ctx, tx, *purchase.PromoCodeID, purchase.CustomerID); err != nil {
+		return 0, err
+	}
+
+	id, err := insertPurchase(ctx, tx, purchase)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return id, nil
+}
+
+func checkPromoUsage(ctx context.Context, tx pgx.Tx, promoCodeID int64, customerID int64) error {
+	promo, err := lockPromoCode(ctx, tx, promoCodeID)
+	if err != nil {
+		return err
+	}
+	usage, err := promoUsage(ctx, tx, promoCodeID, customerID)
+	if err != nil {
+		return err
+	}
+	return promo.CheckUsage(usage)
+}
+
+func insertPurchase(ctx context.Context, q queryRower, purchase *Purchase) (int64, error) {
+	if purchase.Kind == "" {
+		purchase.Kind = PurchaseKindSubscription
+	}
+
 	buildInsert := sq.Insert("purchase").
-		Columns("amount", "customer_id", "month", "currency", "expire_at", "status", "invoice_type", "crypto_invoice_id", "crypto_invoice_url", "yookasa_url", "yookasa_id").
-		Values(purchase.Amount, purchase.CustomerID, purchase.Month, purchase.Currency, purchase.ExpireAt, purchase.Status, purchase.InvoiceType, purchase.CryptoInvoiceID, purchase.CryptoInvoiceLink, purchase.YookasaURL, purchase.YookasaID).
+		Columns("amount", "customer_id", "month", "currency", "expire_at", "status", "invoice_type", "crypto_invoice_id", "crypto_invoice_url", "yookasa_url", "yookasa_id", "parent_purchase_id", "external_id", "telegram_payment_charge_id", "traffic_limit", "promo_code_id", "discount", "tariff_id", "duration_days", "kind", "device_limit", "auto_renew").
+		Values(purchase.Amount, purchase.CustomerID, purchase.Month, purchase.Currency, purchase.ExpireAt, purchase.Status, purchase.InvoiceType, purchase.CryptoInvoiceID, purchase.CryptoInvoiceLink, purchase.YookasaURL, purchase.YookasaID, purchase.ParentPurchaseID, purchase.ExternalID, purchase.TelegramChargeID, purchase.TrafficLimit, purchase.PromoCodeID, purchase.Discount, purchase.TariffID, purchase.DurationDays, purchase.Kind, purchase.DeviceLimit, purchase.AutoRenew).
 		Suffix("RETURNING id").
 		PlaceholderFormat(sq.Dollar)
 
@@ -69,7 +194,7 @@ func (cr *PurchaseRepository) Create(ctx context.Context, purchase *Purchase) (i
 	}
 
 	var id int64
-	err = cr.pool.QueryRow(ctx, sql, args...).Scan(&id)
+	err = q.QueryRow(ctx, sql, args...).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
@@ -78,7 +203,7 @@ func (cr *PurchaseRepository) Create(ctx context.Context, purchase *Purchase) (i
 }
 
 func (cr *PurchaseRepository) FindByInvoiceTypeAndStatus(ctx context.Context, invoiceType InvoiceType, status PurchaseStatus) (*[]Purchase, error) {
-	buildSelect := sq.Select("*").
+	buildSelect := sq.Select(purchaseColumns...).
 		From("purchase").
 		Where(sq.And{
 			sq.Eq{"invoice_type": invoiceType},
@@ -100,22 +225,7 @@ func (cr *PurchaseRepository) FindByInvoiceTypeAndStatus(ctx context.Context, in
 	purchases := []Purchase{}
 	for rows.Next() {
 		purchase := Purchase{}
-		err = rows.Scan(
-			&purchase.ID,
-			&purchase.Amount,
-			&purchase.CustomerID,
-			&purchase.CreatedAt,
-			&purchase.Month,
-			&purchase.PaidAt,
-			&purchase.Currency,
-			&purchase.ExpireAt,
-			&purchase.Status,
-			&purchase.InvoiceType,
-			&purchase.CryptoInvoiceID,
-			&purchase.CryptoInvoiceLink,
-			&purchase.YookasaURL,
-			&purchase.YookasaID,
-		)
+		err = scanPurchase(rows, &purchase)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan purchase: %w", err)
 		}
@@ -130,7 +240,7 @@ func (cr *PurchaseRepository) FindByInvoiceTypeAndStatus(ctx context.Context, in
 }
 
 func (cr *PurchaseRepository) FindById(ctx context.Context, id int64) (*Purchase, error) {
-	buildSelect := sq.Select("*").
+	buildSelect := sq.Select(purchaseColumns...).
 		From("purchase").
 		Where(sq.Eq{"id": id}).
 		PlaceholderFormat(sq.Dollar)
@@ -141,23 +251,211 @@ func (cr *PurchaseRepository) FindById(ctx context.Context, id int64) (*Purchase
 	}
 	purchase := &Purchase{}
 
-	err = cr.pool.QueryRow(ctx, sql, args...).Scan(
-		&purchase.ID,
-		&purchase.Amount,
-		&purchase.CustomerID,
-		&purchase.CreatedAt,
-		&purchase.Month,
-		&purchase.PaidAt,
-		&purchase.Currency,
-		&purchase.ExpireAt,
-		&purchase.Status,
-		&purchase.InvoiceType,
-		&purchase.CryptoInvoiceID,
-		&purchase.CryptoInvoiceLink,
-		&purchase.YookasaURL,
-		&purchase.YookasaID,
-	)
+	err = scanPurchase(cr.pool.QueryRow(ctx, sql, args...), purchase)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query purchase: %w", err)
+	}
+
+	return purchase, nil
+}
+
+// FindExpired returns unpaid purchases whose invoice lifetime ended before the given time.
+func (cr *PurchaseRepository) FindExpired(ctx context.Context, before time.Time) (*[]Purchase, error) {
+	buildSelect := sq.Select(purchaseColumns...).
+		From("purchase").
+		Where(sq.And{
+			sq.Eq{"status": []PurchaseStatus{PurchaseStatusNew, PurchaseStatusPending}},
+			sq.NotEq{"expire_at": nil},
+			sq.LtOrEq{"expire_at": before},
+		}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := cr.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query purchases: %w", err)
+	}
+	defer rows.Close()
+
+	purchases := []Purchase{}
+	for rows.Next() {
+		purchase := Purchase{}
+		err = scanPurchase(rows, &purchase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan purchase: %w", err)
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return &purchases, nil
+}
+
+// FindUnpaidWithPromo returns the new and pending purchases of a customer made with the promo code.
+func (cr *PurchaseRepository) FindUnpaidWithPromo(ctx context.Context, customerID int64, promoCodeID int64) (*[]Purchase, error) {
+	buildSelect := sq.Select(purchaseColumns...).
+		From("purchase").
+		Where(sq.And{
+			sq.Eq{"customer_id": customerID},
+			sq.Eq{"promo_code_id": promoCodeID},
+			sq.Eq{"status": []PurchaseStatus{PurchaseStatusNew, PurchaseStatusPending}},
+		}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := cr.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query purchases: %w", err)
+	}
+	defer rows.Close()
+
+	purchases := []Purchase{}
+	for rows.Next() {
+		purchase := Purchase{}
+		err = scanPurchase(rows, &purchase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan purchase: %w", err)
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return &purchases, nil
+}
+
+// FindCreatedBetween returns the purchases of an invoice type created in [from, to).
+func (cr *PurchaseRepository) FindCreatedBetween(ctx context.Context, invoiceType InvoiceType, from, to time.Time) (*[]Purchase, error) {
+	buildSelect := sq.Select(purchaseColumns...).
+		From("purchase").
+		Where(sq.And{
+			sq.Eq{"invoice_type": invoiceType},
+			sq.GtOrEq{"created_at": from},
+			sq.Lt{"created_at": to},
+		}).
+		OrderBy("id").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := cr.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query purchases: %w", err)
+	}
+	defer rows.Close()
+
+	purchases := []Purchase{}
+	for rows.Next() {
+		purchase := Purchase{}
+		err = scanPurchase(rows, &purchase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan purchase: %w", err)
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return &purchases, nil
+}
+
+// FindByExternalID looks up a purchase by the identifier of the provider event that created it.
+func (cr *PurchaseRepository) FindByExternalID(ctx context.Context, invoiceType InvoiceType, externalID string) (*Purchase, error) {
+	buildSelect := sq.Select(purchaseColumns...).
+		From("purchase").
+		Where(sq.And{
+			sq.Eq{"invoice_type": invoiceType},
+			sq.Eq{"external_id": externalID},
+		}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	purchase := &Purchase{}
+
+	err = scanPurchase(cr.pool.QueryRow(ctx, sql, args...), purchase)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query purchase: %w", err)
+	}
+
+	return purchase, nil
+}
+
+// HasPendingRenewal reports whether an auto-renewal charge of the customer at the provider is still awaiting its result.
+func (cr *PurchaseRepository) HasPendingRenewal(ctx context.Context, customerID int64, invoiceType InvoiceType) (bool, error) {
+	buildSelect := sq.Select("1").
+		From("purchase").
+		Where(sq.And{
+			sq.Eq{"customer_id": customerID},
+			sq.Eq{"invoice_type": invoiceType},
+			sq.Eq{"status": []PurchaseStatus{PurchaseStatusNew, PurchaseStatusPending, PurchaseStatusProcessing}},
+			sq.NotEq{"parent_purchase_id": nil},
+		}).
+		Prefix("SELECT EXISTS (").
+		Suffix(")").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	if err := cr.pool.QueryRow(ctx, sql, args...).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to query pending renewal: %w", err)
+	}
+	return exists, nil
+}
+
+// FindLatestPaidOriginal returns the customer's most recent paid purchase that is not itself an auto-renewal charge.
+func (cr *PurchaseRepository) FindLatestPaidOriginal(ctx context.Context, customerID int64, invoiceType InvoiceType) (*Purchase, error) {
+	buildSelect := sq.Select(purchaseColumns...).
+		From("purchase").
+		Where(sq.And{
+			sq.Eq{"customer_id": customerID},
+			sq.Eq{"invoice_type": invoiceType},
+			sq.Eq{"status": PurchaseStatusPaid},
+			sq.Eq{"parent_purchase_id": nil},
+			sq.Eq{"kind": PurchaseKindSubscription},
+		}).
+		OrderBy("paid_at DESC").
+		Limit(1).
+		PlaceholderFormat(sq.Dollar)
 
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	purchase := &Purchase{}
+
+	err = scanPurchase(cr.pool.QueryRow(ctx, sql, args...), purchase)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
@@ -199,6 +497,91 @@ func (p *PurchaseRepository) UpdateFields(ctx context.Context, id int64, updates
 	return nil
 }
 
+// Claim atomically moves an unpaid purchase to processing. Only the caller that gets true
+// may fulfil the purchase, concurrent or repeated confirmations get false.
+// Expired purchases can still be claimed, money confirmed by a provider after expiry is honoured.
+// An expired purchase made with a promo code no longer holds a use of it, claiming it fails with
+// ErrPromoCodeExhausted or ErrPromoCodeAlreadyUsed when the code was used up meanwhile.
+func (pr *PurchaseRepository) Claim(ctx context.Context, purchaseID int64) (bool, error) {
+	return pr.claim(ctx, purchaseID, map[string]interface{}{
+		"status": PurchaseStatusProcessing,
+	})
+}
+
+// ClaimCharge claims a purchase like Claim and records the Telegram charge that paid it in the same update,
+// a charge that loses the claim never overwrites the one of the winner.
+func (pr *PurchaseRepository) ClaimCharge(ctx context.Context, purchaseID int64, chargeID string) (bool, error) {
+	return pr.claim(ctx, purchaseID, map[string]interface{}{
+		"status":                     PurchaseStatusProcessing,
+		"telegram_payment_charge_id": chargeID,
+	})
+}
+
+func (pr *PurchaseRepository) claim(ctx context.Context, purchaseID int64, updates map[string]interface{}) (bool, error) {
+	claimed, err := pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusNew, PurchaseStatusPending}, updates)
+	if err != nil || claimed {
+		return claimed, err
+	}
+
+	tx, err := pr.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var customerID int64
+	var promoCodeID *int64
+	err = tx.QueryRow(ctx, "SELECT customer_id, promo_code_id FROM purchase WHERE id = $1 AND status = $2 FOR UPDATE", purchaseID, PurchaseStatusExpired).Scan(&customerID, &promoCodeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to lock purchase: %w", err)
+	}
+
+	if promoCodeID != nil {
+		if err := checkPromoUsage(ctx, tx, *promoCodeID, customerID); err != nil {
+			return false, err
+		}
+	}
+
+	buildUpdate := sq.Update("purchase").
+		PlaceholderFormat(sq.Dollar).
+		Where(sq.Eq{"id": purchaseID})
+	for field, value := range updates {
+		buildUpdate = buildUpdate.Set(field, value)
+	}
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to build update query: %w", err)
+	}
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		return false, fmt.Errorf("failed to update purchase status: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// ReleaseClaim returns a claimed purchase to pending so it can be confirmed again after a failed fulfilment.
+func (pr *PurchaseRepository) ReleaseClaim(ctx context.Context, purchaseID int64) error {
+	_, err := pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusProcessing}, map[string]interface{}{
+		"status": PurchaseStatusPending,
+	})
+	return err
+}
+
+// Reopen returns a canceled purchase, or one left in processing by a failed fulfilment, to pending.
+// Used when the provider reports the purchase paid after all.
+func (pr *PurchaseRepository) Reopen(ctx context.Context, purchaseID int64) (bool, error) {
+	return pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusCancel, PurchaseStatusProcessing}, map[string]interface{}{
+		"status": PurchaseStatusPending,
+	})
+}
+
 func (pr *PurchaseRepository) MarkAsPaid(ctx context.Context, purchaseID int64) error {
 	currentTime := time.Now()
 
@@ -207,5 +590,69 @@ func (pr *PurchaseRepository) MarkAsPaid(ctx context.Context, purchaseID int64)
 		"paid_at": currentTime,
 	}
 
-	return pr.UpdateFields(ctx, purchaseID, updates)
+	ok, err := pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusProcessing}, updates)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("purchase %d is not being processed", purchaseID)
+	}
+	return nil
+}
+
+// MarkAsExpired moves an unpaid purchase to expired and reports whether this call did it.
+func (pr *PurchaseRepository) MarkAsExpired(ctx context.Context, purchaseID int64) (bool, error) {
+	return pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusNew, PurchaseStatusPending}, map[string]interface{}{
+		"status": PurchaseStatusExpired,
+	})
+}
+
+// MarkAsCanceled moves an unpaid purchase to canceled and reports whether this call did it.
+// Purchases claimed, paid or refunded meanwhile are left as they are.
+func (pr *PurchaseRepository) MarkAsCanceled(ctx context.Context, purchaseID int64) (bool, error) {
+	return pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusNew, PurchaseStatusPending}, map[string]interface{}{
+		"status": PurchaseStatusCancel,
+	})
+}
+
+// MarkAsRefunded moves a paid purchase to refunded and reports whether this call did it.
+func (pr *PurchaseRepository) MarkAsRefunded(ctx context.Context, purchaseID int64) (bool, error) {
+	return pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusPaid}, map[string]interface{}{
+		"status": PurchaseStatusRefunded,
+	})
+}
+
+// MarkRejectedAsRefunded moves a canceled purchase, recording a payment that arrived for an invoice no longer
+// payable, to refunded once the payment was returned. Nothing was delivered for it, so there is nothing to roll back.
+func (pr *PurchaseRepository) MarkRejectedAsRefunded(ctx context.Context, purchaseID int64) (bool, error) {
+	return pr.transition(ctx, purchaseID, []PurchaseStatus{PurchaseStatusCancel}, map[string]interface{}{
+		"status": PurchaseStatusRefunded,
+	})
+}
+
+// transition applies updates only while the purchase is in one of the from statuses
+// and reports whether the row was changed.
+func (pr *PurchaseRepository) transition(ctx context.Context, purchaseID int64, from []PurchaseStatus, updates map[string]interface{}) (bool, error) {
+	buildUpdate := sq.Update("purchase").
+		PlaceholderFormat(sq.Dollar).
+		Where(sq.And{
+			sq.Eq{"id": purchaseID},
+			sq.Eq{"status": from},
+		})
+
+	for field, value := range updates {
+		buildUpdate = buildUpdate.Set(field, value)
+	}
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to build update query: %w", err)
+	}
+
+	result, err := pr.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update purchase status: %w", err)
+	}
+
+	return result.RowsAffected() == 1, nil
 }
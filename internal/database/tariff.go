@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+const bytesInGigabyte = 1073741824
+
+type Tariff struct {
+	ID              int64             `db:"id"`
+	Name            map[string]string `db:"name"`
+	DurationDays    int               `db:"duration_days"`
+	TrafficLimitGB  int               `db:"traffic_limit_gb"`
+	TrafficStrategy string            `db:"traffic_strategy"`
+	DeviceLimit     *int              `db:"device_limit"`
+	Inbounds        []string          `db:"inbounds"`
+	Squads          []string          `db:"squads"`
+	Prices          map[string]int    `db:"prices"`
+	SortOrder       int               `db:"sort_order"`
+	Active          bool              `db:"active"`
+	Panel           *string           `db:"panel"`
+	CreatedAt       time.Time         `db:"created_at"`
+}
+
+// DisplayName returns the name in the given language, falling back to any configured name.
+func (t *Tariff) DisplayName(langCode string) string {
+	if name, ok := t.Name[langCode]; ok && name != "" {
+		return name
+	}
+	for _, name := range t.Name {
+		if name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d days", t.DurationDays)
+}
+
+// Price returns the price in a provider currency, tariffs without a price are not sold for it.
+func (t *Tariff) Price(currency string) (int, bool) {
+	price, ok := t.Prices[currency]
+	return price, ok && price > 0
+}
+
+func (t *Tariff) TrafficLimitBytes() int {
+	return t.TrafficLimitGB * bytesInGigabyte
+}
+
+// IsAvailableOn reports whether customers of a panel can buy the tariff, tariffs without a panel are sold on every panel.
+func (t *Tariff) IsAvailableOn(panel string) bool {
+	return t.Panel == nil || *t.Panel == panel
+}
+
+// Months rounds the duration to whole months for month based receipts and promo restrictions.
+func (t *Tariff) Months() int {
+	months := (t.DurationDays + 15) / 30
+	if months == 0 {
+		return 1
+	}
+	return months
+}
+
+var tariffColumns = []string{"id", "name", "duration_days", "traffic_limit_gb", "traffic_strategy", "device_limit", "inbounds", "squads", "prices", "sort_order", "active", "panel", "created_at"}
+
+func scanTariff(row rowScanner, tariff *Tariff) error {
+	return row.Scan(
+		&tariff.ID,
+		&tariff.Name,
+		&tariff.DurationDays,
+		&tariff.TrafficLimitGB,
+		&tariff.TrafficStrategy,
+		&tariff.DeviceLimit,
+		&tariff.Inbounds,
+		&tariff.Squads,
+		&tariff.Prices,
+		&tariff.SortOrder,
+		&tariff.Active,
+		&tariff.Panel,
+		&tariff.CreatedAt,
+	)
+}
+
+type TariffRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewTariffRepository(pool *pgxpool.Pool) *TariffRepository {
+	return &TariffRepository{pool: pool}
+}
+
+func (tr *TariffRepository) Create(ctx context.Context, tariff *Tariff) (*Tariff, error) {
+	buildInsert := sq.Insert("tariff").
+		Columns("name", "duration_days", "traffic_limit_gb", "traffic_strategy", "device_limit", "inbounds", "squads", "prices", "sort_order", "active", "panel").
+		Values(tariff.Name, tariff.DurationDays, tariff.TrafficLimitGB, tariff.TrafficStrategy, tariff.DeviceLimit, tariff.Inbounds, tariff.Squads, tariff.Prices, tariff.SortOrder, tariff.Active, tariff.Panel).
+		Suffix("RETURNING id, created_at").
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildInsert.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build insert query: %w", err)
+	}
+
+	if err := tr.pool.QueryRow(ctx, sql, args...).Scan(&tariff.ID, &tariff.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to insert tariff: %w", err)
+	}
+	return tariff, nil
+}
+
+func (tr *TariffRepository) FindById(ctx context.Context, id int64) (*Tariff, error) {
+	buildSelect := sq.Select(tariffColumns...).
+		From("tariff").
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	tariff := &Tariff{}
+	err = scanTariff(tr.pool.QueryRow(ctx, sql, args...), tariff)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query tariff: %w", err)
+	}
+	return tariff, nil
+}
+
+// FindActive returns the tariffs offered to customers in display order.
+func (tr *TariffRepository) FindActive(ctx context.Context) ([]Tariff, error) {
+	return tr.find(ctx, sq.Eq{"active": true})
+}
+
+func (tr *TariffRepository) FindAll(ctx context.Context) ([]Tariff, error) {
+	return tr.find(ctx, nil)
+}
+
+func (tr *TariffRepository) find(ctx context.Context, where sq.Sqlizer) ([]Tariff, error) {
+	buildSelect := sq.Select(tariffColumns...).
+		From("tariff").
+		OrderBy("sort_order", "duration_days", "id").
+		PlaceholderFormat(sq.Dollar)
+	if where != nil {
+		buildSelect = buildSelect.Where(where)
+	}
+
+	sql, args, err := buildSelect.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build select query: %w", err)
+	}
+
+	rows, err := tr.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tariffs: %w", err)
+	}
+	defer rows.Close()
+
+	var tariffs []Tariff
+	for rows.Next() {
+		var tariff Tariff
+		if err := scanTariff(rows, &tariff); err != nil {
+			return nil, fmt.Errorf("failed to scan tariff row: %w", err)
+		}
+		tariffs = append(tariffs, tariff)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over tariff rows: %w", err)
+	}
+
+	return tariffs, nil
+}
+
+func (tr *TariffRepository) UpdateFields(ctx context.Context, id int64, updates map[string]interface{}) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	buildUpdate := sq.Update("tariff").
+		PlaceholderFormat(sq.Dollar).
+		Where(sq.Eq{"id": id})
+
+	for field, value := range updates {
+		buildUpdate = buildUpdate.Set(field, value)
+	}
+
+	sql, args, err := buildUpdate.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build update query: %w", err)
+	}
+
+	result, err := tr.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update tariff: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("no tariff found with id: %d", id)
+	}
+	return nil
+}
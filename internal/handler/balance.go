@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/cache"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+)
+
+const maxTopUpAmount = 1000000
+
+// newTopUpInputCache remembers, per chat, the index of the provider a top up amount is being entered for.
+func newTopUpInputCache() *cache.Cache {
+	return cache.NewCache(10 * time.Minute)
+}
+
+func (h Handler) BalanceCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	balances, err := h.paymentService.Balances(ctx, customer.ID)
+	if err != nil {
+		slog.Error("Error getting balances", "error", err)
+		return
+	}
+
+	var keyboard [][]models.InlineKeyboardButton
+	for _, provider := range h.paymentService.Providers() {
+		if !h.paymentService.CanTopUp(provider, customer) {
+			continue
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: h.translation.GetText(langCode, provider.ButtonKey()), CallbackData: fmt.Sprintf("%s?invoiceType=%s", CallbackTopUp, provider.Type())},
+		})
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackStart},
+	})
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(h.translation.GetText(langCode, "balance_info"), formatBalances(balances, payment.PreferredCurrency(customer))),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: keyboard,
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending balance message", "error", err)
+	}
+}
+
+func (h Handler) TopUpCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	invoiceType := database.InvoiceType(callbackQuery["invoiceType"])
+	index := -1
+	var currency string
+	for i, provider := range h.paymentService.Providers() {
+		if provider.Type() == invoiceType && h.paymentService.CanTopUp(provider, customer) {
+			index = i
+			currency = payment.SettlementCurrency(provider, payment.PreferredCurrency(customer))
+			break
+		}
+	}
+	if index < 0 {
+		slog.Error("Invoice type can't top up the balance", "invoiceType", invoiceType)
+		return
+	}
+
+	h.resetInputs(callback.Chat.ID)
+	h.topUpInput.Set(callback.Chat.ID, index)
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      fmt.Sprintf(h.translation.GetText(langCode, "balance_enter_amount"), currency),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackBalance}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending top up message", "error", err)
+	}
+}
+
+// IsTopUpAmountInput matches plain text sent while the chat is expected to enter a top up amount.
+func (h Handler) IsTopUpAmountInput(update *models.Update) bool {
+	if update.Message == nil || update.Message.Text == "" || strings.HasPrefix(update.Message.Text, "/") {
+		return false
+	}
+	_, waiting := h.topUpInput.Get(update.Message.Chat.ID)
+	return waiting
+}
+
+func (h Handler) TopUpAmountMessageHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	langCode := update.Message.From.LanguageCode
+
+	index, waiting := h.topUpInput.Get(chatID)
+	if !waiting {
+		return
+	}
+	providers := h.paymentService.Providers()
+	if index >= len(providers) {
+		h.topUpInput.Delete(chatID)
+		return
+	}
+	provider := providers[index]
+
+	amount, err := strconv.Atoi(strings.TrimSpace(update.Message.Text))
+	if err != nil || amount <= 0 || amount > maxTopUpAmount {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   h.translation.GetText(langCode, "balance_invalid_amount"),
+		})
+		if err != nil {
+			slog.Error("Error sending top up message", "error", err)
+		}
+		return
+	}
+	h.topUpInput.Delete(chatID)
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, chatID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	ctxWithUsername := context.WithValue(ctx, "username", update.Message.From.Username)
+	paymentURL, purchaseId, err := h.paymentService.CreateTopUpPurchase(ctxWithUsername, amount, customer, provider.Type())
+	if err != nil {
+		slog.Error("Error creating top up", "error", err)
+		return
+	}
+
+	currency := payment.SettlementCurrency(provider, payment.PreferredCurrency(customer))
+	message, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    chatID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(h.translation.GetText(langCode, "balance_top_up_invoice"), payment.FormatPrice(amount, currency)),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{
+					{Text: h.translation.GetText(langCode, "pay_button"), URL: paymentURL},
+					{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackBalance},
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending top up message", "error", err)
+		return
+	}
+	h.cache.Set(purchaseId, message.ID)
+}
+
+// balanceButton shows the balance in the preferred currency of the customer on the main menu.
+func (h Handler) balanceButton(ctx context.Context, customer *database.Customer, langCode string) []models.InlineKeyboardButton {
+	balances, err := h.paymentService.Balances(ctx, customer.ID)
+	if err != nil {
+		slog.Error("Error getting balances", "error", err)
+		balances = map[string]float64{}
+	}
+
+	currency := payment.PreferredCurrency(customer)
+	return []models.InlineKeyboardButton{
+		{Text: fmt.Sprintf(h.translation.GetText(langCode, "balance_button"), payment.FormatPrice(int(balances[currency]), currency)), CallbackData: CallbackBalance},
+	}
+}
+
+// formatBalances lists non-zero balances one per line, the preferred currency first.
+func formatBalances(balances map[string]float64, preferred string) string {
+	currencies := make([]string, 0, len(balances))
+	for currency, amount := range balances {
+		if amount != 0 && currency != preferred {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.Strings(currencies)
+	currencies = append([]string{preferred}, currencies...)
+
+	lines := make([]string, 0, len(currencies))
+	for _, currency := range currencies {
+		lines = append(lines, payment.FormatPrice(int(balances[currency]), currency))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h Handler) sendInsufficientBalance(ctx context.Context, b *bot.Bot, callback *models.Message, langCode string, backData string) {
+	_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      h.translation.GetText(langCode, "balance_insufficient"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "balance_top_up_button"), CallbackData: CallbackBalance}},
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: backData}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending insufficient balance message", "error", err)
+	}
+}
@@ -1,12 +1,27 @@
 package handler
 
 const (
-	CallbackBuy           = "buy"
-	CallbackSell          = "sell"
-	CallbackStart         = "start"
-	CallbackConnect       = "connect"
-	CallbackPayment       = "payment"
-	CallbackTrial         = "trial"
-	CallbackActivateTrial = "activate_trial"
-	CallbackReferral      = "referral"
+	CallbackBuy               = "buy"
+	CallbackSell              = "sell"
+	CallbackStart             = "start"
+	CallbackConnect           = "connect"
+	CallbackPayment           = "payment"
+	CallbackTrial             = "trial"
+	CallbackActivateTrial     = "activate_trial"
+	CallbackReferral          = "referral"
+	CallbackAutoRenewOff      = "auto_renew_off"
+	CallbackPromo             = "promo"
+	CallbackCurrency          = "currency"
+	CallbackBalance           = "balance"
+	CallbackTopUp             = "top_up"
+	CallbackStarsCancel       = "stars_cancel"
+	CallbackReprocess         = "reprocess"
+	CallbackTraffic           = "extra_traffic"
+	CallbackTrafficPay        = "traffic_pay"
+	CallbackDeviceTiers       = "device_tiers"
+	CallbackDevicesPay        = "upgrade_devices"
+	CallbackManualApprove     = "manual_approve"
+	CallbackManualReject      = "manual_reject"
+	CallbackProvisioningRetry = "provisioning_retry"
+	CallbackLocation          = "location"
 )
@@ -10,7 +10,9 @@ import (
 	"github.com/go-telegram/bot/models"
 	"log/slog"
 
+	"remnawave-tg-shop-bot/internal/config"
 	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
 	"remnawave-tg-shop-bot/internal/translation"
 	"remnawave-tg-shop-bot/utils"
 )
@@ -18,7 +20,7 @@ import (
 func (h Handler) ConnectCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	customer, err := h.customerRepository.FindByTelegramId(ctx, update.Message.Chat.ID)
 	if err != nil {
-		slog.Error("Error finding customer", err)
+		slog.Error("Error finding customer", "error", err)
 		return
 	}
 	if customer == nil {
@@ -31,20 +33,18 @@ func (h Handler) ConnectCommandHandler(ctx context.Context, b *bot.Bot, update *
 	isDisabled := true
 	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID:    update.Message.Chat.ID,
-		Text:      buildConnectText(customer, langCode),
+		Text:      buildConnectText(customer, h.connectUsage(ctx, customer), langCode),
 		ParseMode: models.ParseModeHTML,
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			IsDisabled: &isDisabled,
 		},
 		ReplyMarkup: models.InlineKeyboardMarkup{
-			InlineKeyboard: [][]models.InlineKeyboardButton{
-				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackStart}},
-			},
+			InlineKeyboard: h.buildConnectKeyboard(customer, langCode),
 		},
 	})
 
 	if err != nil {
-		slog.Error("Error sending connect message", err)
+		slog.Error("Error sending connect message", "error", err)
 	}
 }
 
@@ -53,7 +53,7 @@ func (h Handler) ConnectCallbackHandler(ctx context.Context, b *bot.Bot, update
 
 	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
 	if err != nil {
-		slog.Error("Error finding customer", err)
+		slog.Error("Error finding customer", "error", err)
 		return
 	}
 	if customer == nil {
@@ -68,23 +68,136 @@ func (h Handler) ConnectCallbackHandler(ctx context.Context, b *bot.Bot, update
 		ChatID:    callback.Chat.ID,
 		MessageID: callback.ID,
 		ParseMode: models.ParseModeHTML,
-		Text:      buildConnectText(customer, langCode),
+		Text:      buildConnectText(customer, h.connectUsage(ctx, customer), langCode),
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			IsDisabled: &isDisabled,
 		},
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: h.buildConnectKeyboard(customer, langCode),
+		},
+	})
+
+	if err != nil {
+		slog.Error("Error sending connect message", "error", err)
+	}
+}
+
+func (h Handler) AutoRenewOffCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		slog.Error("customer not exist", "telegramId", utils.MaskHalfInt64(callback.Chat.ID))
+		return
+	}
+
+	err = h.paymentService.DisableAutoRenewal(ctx, customer.ID)
+	if err != nil {
+		slog.Error("Error disabling auto renewal", "error", err)
+		return
+	}
+
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      h.translation.GetText(langCode, "auto_renew_disabled"),
 		ReplyMarkup: models.InlineKeyboardMarkup{
 			InlineKeyboard: [][]models.InlineKeyboardButton{
-				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackStart}},
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackConnect}},
 			},
 		},
 	})
 
 	if err != nil {
-		slog.Error("Error sending connect message", err)
+		slog.Error("Error sending auto renewal message", "error", err)
 	}
 }
 
-func buildConnectText(customer *database.Customer, langCode string) string {
+func (h Handler) StarsCancelCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		slog.Error("customer not exist", "telegramId", utils.MaskHalfInt64(callback.Chat.ID))
+		return
+	}
+	if customer.StarsSubID == nil {
+		return
+	}
+
+	err = h.paymentService.CancelStarsSubscription(ctx, customer)
+	if err != nil {
+		slog.Error("Error cancelling stars subscription", "error", err)
+		return
+	}
+
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      h.translation.GetText(langCode, "stars_subscription_cancelled"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackConnect}},
+			},
+		},
+	})
+
+	if err != nil {
+		slog.Error("Error sending stars subscription message", "error", err)
+	}
+}
+
+func (h Handler) buildConnectKeyboard(customer *database.Customer, langCode string) [][]models.InlineKeyboardButton {
+	var keyboard [][]models.InlineKeyboardButton
+
+	if customer.AutoRenew {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: h.translation.GetText(langCode, "auto_renew_off_button"), CallbackData: CallbackAutoRenewOff},
+		})
+	}
+
+	if customer.StarsSubID != nil {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: h.translation.GetText(langCode, "stars_subscription_cancel_button"), CallbackData: CallbackStarsCancel},
+		})
+	}
+
+	if len(config.DeviceTiers()) > 0 && customer.ExpireAt != nil && customer.ExpireAt.After(time.Now()) {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: h.translation.GetText(langCode, "devices_button"), CallbackData: CallbackDeviceTiers},
+		})
+	}
+
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackStart},
+	})
+	return keyboard
+}
+
+// connectUsage fetches the live panel usage of a customer, nil if the panel can't be reached.
+// The Connect screen then falls back to the stored subscription.
+func (h Handler) connectUsage(ctx context.Context, customer *database.Customer) *payment.Usage {
+	usage, err := h.paymentService.Usage(ctx, customer)
+	if err != nil {
+		slog.Error("Error getting panel usage", "error", err)
+		return nil
+	}
+	return usage
+}
+
+func buildConnectText(customer *database.Customer, usage *payment.Usage, langCode string) string {
 	var info strings.Builder
 
 	tm := translation.GetInstance()
@@ -98,10 +211,22 @@ func buildConnectText(customer *database.Customer, langCode string) string {
 			subscriptionActiveText := tm.GetText(langCode, "subscription_active")
 			info.WriteString(fmt.Sprintf(subscriptionActiveText, formattedDate))
 
+			if usage != nil {
+				writeUsage(&info, usage, langCode)
+			}
+
 			if customer.SubscriptionLink != nil && *customer.SubscriptionLink != "" {
 				subscriptionLinkText := tm.GetText(langCode, "subscription_link")
 				info.WriteString(fmt.Sprintf(subscriptionLinkText, *customer.SubscriptionLink))
 			}
+
+			if customer.AutoRenew {
+				info.WriteString(tm.GetText(langCode, "auto_renew_enabled"))
+			}
+
+			if customer.StarsSubID != nil {
+				info.WriteString(tm.GetText(langCode, "stars_subscription_active"))
+			}
 		} else {
 			noSubscriptionText := tm.GetText(langCode, "no_subscription")
 			info.WriteString(noSubscriptionText)
@@ -113,3 +238,34 @@ func buildConnectText(customer *database.Customer, langCode string) string {
 
 	return info.String()
 }
+
+// writeUsage lists the traffic, connection and device usage of the panel user.
+func writeUsage(info *strings.Builder, usage *payment.Usage, langCode string) {
+	tm := translation.GetInstance()
+
+	if usage.TrafficLimit > 0 {
+		info.WriteString(fmt.Sprintf(tm.GetText(langCode, "usage_traffic"), payment.FormatTraffic(usage.UsedTraffic), payment.FormatTraffic(float64(usage.TrafficLimit))))
+	} else {
+		info.WriteString(fmt.Sprintf(tm.GetText(langCode, "usage_traffic_unlimited"), payment.FormatTraffic(usage.UsedTraffic)))
+	}
+	if usage.NextReset != nil {
+		info.WriteString(fmt.Sprintf(tm.GetText(langCode, "usage_next_reset"), usage.NextReset.Format("02.01.2006 15:04")))
+	}
+
+	switch {
+	case usage.Online():
+		info.WriteString(tm.GetText(langCode, "usage_online"))
+	case usage.OnlineAt != nil:
+		info.WriteString(fmt.Sprintf(tm.GetText(langCode, "usage_last_seen"), usage.OnlineAt.Format("02.01.2006 15:04")))
+	default:
+		info.WriteString(tm.GetText(langCode, "usage_never_connected"))
+	}
+
+	if usage.Devices != nil {
+		if usage.DeviceLimit > 0 {
+			info.WriteString(fmt.Sprintf(tm.GetText(langCode, "usage_devices"), *usage.Devices, usage.DeviceLimit))
+		} else {
+			info.WriteString(fmt.Sprintf(tm.GetText(langCode, "usage_devices_unlimited"), *usage.Devices))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/config"
+)
+
+// CurrencyCallbackHandler lists the currencies to choose from and, once one is picked,
+// remembers it and returns to the tariffs.
+func (h Handler) CurrencyCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	if code, ok := callbackQuery["code"]; ok {
+		if !config.IsCurrencyEnabled(code) {
+			slog.Error("Unknown currency", "currency", code)
+			return
+		}
+
+		customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+		if err != nil || customer == nil {
+			slog.Error("Error finding customer", "error", err)
+			return
+		}
+
+		err = h.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+			"currency": code,
+		})
+		if err != nil {
+			slog.Error("Error updating customer currency", "error", err)
+			return
+		}
+
+		h.BuyCallbackHandler(ctx, b, update)
+		return
+	}
+
+	var keyboard [][]models.InlineKeyboardButton
+	for _, currency := range config.Currencies() {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: currency, CallbackData: fmt.Sprintf("%s?code=%s", CallbackCurrency, currency)},
+		})
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackBuy},
+	})
+
+	_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      h.translation.GetText(langCode, "currency_choose"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: keyboard,
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending currency message", "error", err)
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+)
+
+// DeviceTiersCallbackHandler shows the device limit with the tiers it can be upgraded to for the rest of the period,
+// or the payment methods of a tier once one is chosen.
+func (h Handler) DeviceTiersCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil || customer.ExpireAt == nil {
+		return
+	}
+
+	user, err := h.paymentService.PanelUser(ctx, customer)
+	if err != nil {
+		slog.Error("Error finding panel user", "error", err)
+		return
+	}
+	if user == nil {
+		return
+	}
+	currentLimit := payment.DeviceLimit(user)
+
+	if index, err := strconv.Atoi(callbackQuery["tier"]); err == nil {
+		if index < 0 || index >= len(config.DeviceTiers()) {
+			slog.Error("Unknown device tier", "tier", index)
+			return
+		}
+		_, err = b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+			ChatID:    callback.Chat.ID,
+			MessageID: callback.ID,
+			ReplyMarkup: models.InlineKeyboardMarkup{
+				InlineKeyboard: h.buildDeviceUpgradeKeyboard(ctx, langCode, index, currentLimit, customer),
+			},
+		})
+		if err != nil {
+			slog.Error("Error sending device tiers message", "error", err)
+		}
+		return
+	}
+
+	text := h.translation.GetText(langCode, "devices_default")
+	if currentLimit > 0 {
+		text = fmt.Sprintf(h.translation.GetText(langCode, "devices_info"), currentLimit)
+	}
+
+	var keyboard [][]models.InlineKeyboardButton
+	currency := payment.PreferredCurrency(customer)
+	for i, tier := range config.DeviceTiers() {
+		if currentLimit > 0 && tier.Devices <= currentLimit {
+			continue
+		}
+		label := fmt.Sprintf(h.translation.GetText(langCode, "devices_tier"), tier.Devices)
+		if price, ok := payment.DeviceUpgradePrice(tier, currentLimit, currency, *customer.ExpireAt); ok {
+			label = fmt.Sprintf("%s · %s", label, payment.FormatPrice(price, currency))
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: label, CallbackData: fmt.Sprintf("%s?tier=%d", CallbackDeviceTiers, i)},
+		})
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackConnect},
+	})
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		ParseMode: models.ParseModeHTML,
+		Text:      text,
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: keyboard,
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending device tiers message", "error", err)
+	}
+}
+
+// buildDeviceUpgradeKeyboard lists the payment methods an upgrade to the tier is priced for in the currency they would bill in.
+func (h Handler) buildDeviceUpgradeKeyboard(ctx context.Context, langCode string, index int, currentLimit int, customer *database.Customer) [][]models.InlineKeyboardButton {
+	tier := config.DeviceTiers()[index]
+	currency := payment.PreferredCurrency(customer)
+
+	var keyboard [][]models.InlineKeyboardButton
+	for _, provider := range h.paymentService.Providers() {
+		if _, ok := provider.(payment.LinkProvider); ok {
+			continue
+		}
+		settlementCurrency := payment.SettlementCurrency(provider, currency)
+		price, ok := payment.DeviceUpgradePrice(tier, currentLimit, settlementCurrency, *customer.ExpireAt)
+		if !ok {
+			continue
+		}
+		text := h.translation.GetText(langCode, provider.ButtonKey())
+		if quoter, ok := provider.(payment.QuoteProvider); ok {
+			if quote := quoter.Quote(ctx, price, settlementCurrency); quote != "" {
+				text = fmt.Sprintf("%s (%s)", text, quote)
+			}
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: text, CallbackData: fmt.Sprintf("%s?tier=%d&invoiceType=%s", CallbackDevicesPay, index, provider.Type())},
+		})
+	}
+
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackDeviceTiers},
+	})
+	return keyboard
+}
+
+func (h Handler) DeviceUpgradeCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	index, err := strconv.Atoi(callbackQuery["tier"])
+	if err != nil {
+		slog.Error("Error parsing device tier", "error", err)
+		return
+	}
+
+	invoiceType := database.InvoiceType(callbackQuery["invoiceType"])
+	if _, ok := h.paymentService.Provider(invoiceType); !ok {
+		slog.Error("Unknown invoice type", "invoiceType", invoiceType)
+		return
+	}
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	backData := fmt.Sprintf("%s?tier=%d", CallbackDeviceTiers, index)
+	if invoiceType == database.InvoiceTypeYookasa && callbackQuery["s"] != "1" && needsReceiptContact(customer) {
+		h.askReceiptContact(ctx, b, callback, langCode, update.CallbackQuery.Data, backData)
+		return
+	}
+
+	ctxWithUsername := context.WithValue(ctx, "username", update.CallbackQuery.From.Username)
+	paymentURL, purchaseId, err := h.paymentService.CreateDeviceUpgradePurchase(ctxWithUsername, index, customer, invoiceType)
+	if errors.Is(err, database.ErrInsufficientBalance) {
+		h.sendInsufficientBalance(ctx, b, callback, langCode, backData)
+		return
+	}
+	if err != nil {
+		slog.Error("Error creating device upgrade payment", "error", err)
+		return
+	}
+
+	h.showInvoice(ctx, b, callback, langCode, invoiceType, paymentURL, purchaseId, backData)
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+)
+
+// redeemGift redeems the gift code of a /start gift_<code> link, successful redemptions are announced by the payment service.
+func (h Handler) redeemGift(ctx context.Context, b *bot.Bot, customer *database.Customer, code string) {
+	err := h.paymentService.RedeemGift(ctx, code, customer)
+	if err == nil {
+		return
+	}
+
+	var key string
+	switch {
+	case errors.Is(err, payment.ErrGiftNotFound):
+		key = "gift_not_found"
+	case errors.Is(err, payment.ErrGiftRedeemed):
+		key = "gift_already_redeemed"
+	default:
+		slog.Error("Error redeeming gift", "error", err)
+		key = "gift_redeem_failed"
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: customer.TelegramID,
+		Text:   h.translation.GetText(customer.Language, key),
+	})
+	if err != nil {
+		slog.Error("Error sending gift message", "error", err)
+	}
+}
+
+// GiftListCommandHandler handles /gift_list, listing gifts that were paid but not redeemed yet.
+func (h Handler) GiftListCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	gifts, err := h.giftRepository.FindUnredeemed(ctx)
+	if err != nil {
+		slog.Error("Error finding gifts", "error", err)
+		return
+	}
+
+	var info strings.Builder
+	if len(gifts) == 0 {
+		info.WriteString("No unredeemed gifts")
+	}
+	for _, gift := range gifts {
+		info.WriteString(fmt.Sprintf("%s: purchase %d, bought %s\n", gift.Code, gift.PurchaseID, gift.CreatedAt.Format("02.01.2006")))
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   info.String(),
+	})
+	if err != nil {
+		slog.Error("Error sending gift list message", "error", err)
+	}
+}
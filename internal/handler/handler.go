@@ -5,6 +5,7 @@ import (
 	"remnawave-tg-shop-bot/internal/cryptopay"
 	"remnawave-tg-shop-bot/internal/database"
 	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/internal/promo"
 	"remnawave-tg-shop-bot/internal/sync"
 	"remnawave-tg-shop-bot/internal/translation"
 	"remnawave-tg-shop-bot/internal/yookasa"
@@ -20,6 +21,15 @@ type Handler struct {
 	syncService        *sync.SyncService
 	referralRepository *database.ReferralRepository
 	cache              *cache.Cache
+	promoService       *promo.Service
+	promoRepository    *database.PromoCodeRepository
+	promoInput         *cache.Cache
+	tariffRepository   *database.TariffRepository
+	topUpInput         *cache.Cache
+	giftRepository     *database.GiftRepository
+	receiptInput       *cache.TypedCache[string]
+	receiptPhotoInput  *cache.TypedCache[int64]
+	rejectReasonInput  *cache.TypedCache[int64]
 }
 
 func NewHandler(
@@ -29,7 +39,9 @@ func NewHandler(
 	customerRepository *database.CustomerRepository,
 	purchaseRepository *database.PurchaseRepository,
 	cryptoPayClient *cryptopay.Client,
-	yookasaClient *yookasa.Client, referralRepository *database.ReferralRepository, cache *cache.Cache) *Handler {
+	yookasaClient *yookasa.Client, referralRepository *database.ReferralRepository, cache *cache.Cache,
+	promoService *promo.Service, promoRepository *database.PromoCodeRepository,
+	tariffRepository *database.TariffRepository, giftRepository *database.GiftRepository) *Handler {
 	return &Handler{
 		syncService:        syncService,
 		paymentService:     paymentService,
@@ -40,5 +52,23 @@ func NewHandler(
 		translation:        translation,
 		referralRepository: referralRepository,
 		cache:              cache,
+		promoService:       promoService,
+		promoRepository:    promoRepository,
+		promoInput:         newPromoInputCache(),
+		tariffRepository:   tariffRepository,
+		topUpInput:         newTopUpInputCache(),
+		giftRepository:     giftRepository,
+		receiptInput:       newReceiptInputCache(),
+		receiptPhotoInput:  newManualInputCache(),
+		rejectReasonInput:  newManualInputCache(),
 	}
 }
+
+// resetInputs stops waiting for text input in a chat, so a message only answers the latest prompt.
+func (h Handler) resetInputs(chatID int64) {
+	h.promoInput.Delete(chatID)
+	h.topUpInput.Delete(chatID)
+	h.receiptInput.Delete(chatID)
+	h.receiptPhotoInput.Delete(chatID)
+	h.rejectReasonInput.Delete(chatID)
+}
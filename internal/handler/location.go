@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+)
+
+// LocationCallbackHandler lists the panels to choose from and, once one is picked, moves the customer
+// there and returns to the tariffs. Customers with an active subscription stay where it lives.
+func (h Handler) LocationCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil || customer == nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+
+	if customer.HasActiveSubscription() {
+		h.sendLocationLocked(ctx, b, callback, customer, langCode)
+		return
+	}
+
+	if panel, ok := callbackQuery["panel"]; ok {
+		if !config.IsPanelConfigured(panel) {
+			slog.Error("Unknown panel", "panel", panel)
+			return
+		}
+
+		err = h.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+			"panel": panel,
+		})
+		if err != nil {
+			slog.Error("Error updating customer panel", "error", err)
+			return
+		}
+
+		h.BuyCallbackHandler(ctx, b, update)
+		return
+	}
+
+	var keyboard [][]models.InlineKeyboardButton
+	for _, panel := range config.Panels() {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: h.locationName(langCode, panel.Name), CallbackData: fmt.Sprintf("%s?panel=%s", CallbackLocation, panel.Name)},
+		})
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackBuy},
+	})
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      h.translation.GetText(langCode, "location_choose"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: keyboard,
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending location message", "error", err)
+	}
+}
+
+func (h Handler) sendLocationLocked(ctx context.Context, b *bot.Bot, callback *models.Message, customer *database.Customer, langCode string) {
+	_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      fmt.Sprintf(h.translation.GetText(langCode, "location_locked"), h.locationName(langCode, h.paymentService.CustomerPanel(customer))),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackBuy}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending location message", "error", err)
+	}
+}
+
+// locationName is the translated name of a panel, panel_<name> in the translations, or the panel name itself.
+func (h Handler) locationName(langCode string, panel string) string {
+	key := "panel_" + panel
+	if name := h.translation.GetText(langCode, key); name != key {
+		return name
+	}
+	return panel
+}
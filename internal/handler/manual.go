@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/cache"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+)
+
+// newManualInputCache remembers, per chat, the manual purchase a receipt or a rejection reason is expected for.
+func newManualInputCache() *cache.TypedCache[int64] {
+	return cache.NewTypedCache[int64](config.InvoiceTTL())
+}
+
+// showPaymentDetails replaces the message with the transfer details of a manual purchase and waits for the receipt.
+func (h Handler) showPaymentDetails(ctx context.Context, b *bot.Bot, callback *models.Message, langCode string, details string, purchaseId int64, backData string) {
+	purchase, err := h.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil || purchase == nil {
+		slog.Error("Error finding purchase", "purchaseId", purchaseId, "error", err)
+		return
+	}
+
+	h.resetInputs(callback.Chat.ID)
+	h.receiptPhotoInput.Set(callback.Chat.ID, purchaseId)
+
+	message, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(h.translation.GetText(langCode, "manual_payment_details"), payment.FormatPrice(int(purchase.Amount), purchase.Currency), details, purchaseId),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: backData}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending payment details message", "error", err)
+		return
+	}
+	h.cache.Set(purchaseId, message.ID)
+}
+
+// IsManualReceiptInput matches a photo or file sent while the chat is expected to send a transfer receipt.
+func (h Handler) IsManualReceiptInput(update *models.Update) bool {
+	if update.Message == nil || (len(update.Message.Photo) == 0 && update.Message.Document == nil) {
+		return false
+	}
+	_, waiting := h.receiptPhotoInput.Get(update.Message.Chat.ID)
+	return waiting
+}
+
+// ManualReceiptMessageHandler forwards the receipt of a manual purchase to the admin with approve and reject buttons.
+func (h Handler) ManualReceiptMessageHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	langCode := update.Message.From.LanguageCode
+
+	purchaseId, waiting := h.receiptPhotoInput.Get(chatID)
+	if !waiting {
+		return
+	}
+	h.receiptPhotoInput.Delete(chatID)
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, chatID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	purchase, err := h.paymentService.SubmitManualPayment(ctx, purchaseId, customer)
+	if errors.Is(err, payment.ErrNotAwaitingReview) {
+		h.sendManualMessage(ctx, b, chatID, h.translation.GetText(langCode, "manual_receipt_closed"))
+		return
+	}
+	if err != nil {
+		slog.Error("Error submitting manual payment", "purchaseId", purchaseId, "error", err)
+		return
+	}
+
+	caption := fmt.Sprintf("Manual payment, purchase %d\nCustomer: %d @%s\nAmount: %s\nKind: %s",
+		purchase.ID, customer.TelegramID, update.Message.From.Username, payment.FormatPrice(int(purchase.Amount), purchase.Currency), purchase.Kind)
+	if update.Message.Caption != "" {
+		caption += "\nComment: " + update.Message.Caption
+	}
+
+	_, err = b.CopyMessage(ctx, &bot.CopyMessageParams{
+		ChatID:     config.GetAdminTelegramId(),
+		FromChatID: chatID,
+		MessageID:  update.Message.ID,
+		Caption:    caption,
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{
+					{Text: "Approve", CallbackData: fmt.Sprintf("%s?purchase=%d", CallbackManualApprove, purchase.ID)},
+					{Text: "Reject", CallbackData: fmt.Sprintf("%s?purchase=%d", CallbackManualReject, purchase.ID)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error forwarding manual payment receipt", "purchaseId", purchase.ID, "error", err)
+		return
+	}
+
+	h.sendManualMessage(ctx, b, chatID, h.translation.GetText(langCode, "manual_receipt_received"))
+}
+
+// ManualApproveCallbackHandler activates a manual purchase and marks the receipt as approved.
+func (h Handler) ManualApproveCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+
+	purchaseId, err := strconv.ParseInt(callbackQuery["purchase"], 10, 64)
+	if err != nil {
+		slog.Error("Error parsing purchase id", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	err = h.paymentService.ApproveManualPayment(ctx, purchaseId)
+	switch {
+	case errors.Is(err, payment.ErrNotAwaitingReview):
+		h.sendManualMessage(ctx, b, callback.Chat.ID, fmt.Sprintf("Purchase %d is not awaiting review", purchaseId))
+		return
+	case err != nil:
+		slog.Error("Error approving manual payment", "purchaseId", purchaseId, "error", err)
+		h.sendManualMessage(ctx, b, callback.Chat.ID, fmt.Sprintf("Approving purchase %d failed: %v", purchaseId, err))
+		return
+	}
+
+	_, err = b.EditMessageCaption(ctx, &bot.EditMessageCaptionParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Caption:   callback.Caption + "\n\nApproved",
+	})
+	if err != nil {
+		slog.Error("Error updating manual payment message", "error", err)
+	}
+}
+
+// ManualRejectCallbackHandler asks the admin for the reason the manual purchase is rejected with.
+func (h Handler) ManualRejectCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+
+	purchaseId, err := strconv.ParseInt(callbackQuery["purchase"], 10, 64)
+	if err != nil {
+		slog.Error("Error parsing purchase id", "error", err)
+		return
+	}
+
+	h.resetInputs(callback.Chat.ID)
+	h.rejectReasonInput.Set(callback.Chat.ID, purchaseId)
+
+	h.sendManualMessage(ctx, b, callback.Chat.ID, fmt.Sprintf("Send the reason purchase %d is rejected with, it is forwarded to the customer", purchaseId))
+}
+
+// IsRejectReasonInput matches plain text sent while the chat is expected to enter a rejection reason.
+func (h Handler) IsRejectReasonInput(update *models.Update) bool {
+	if update.Message == nil || update.Message.Text == "" || strings.HasPrefix(update.Message.Text, "/") {
+		return false
+	}
+	_, waiting := h.rejectReasonInput.Get(update.Message.Chat.ID)
+	return waiting
+}
+
+func (h Handler) RejectReasonMessageHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+
+	purchaseId, waiting := h.rejectReasonInput.Get(chatID)
+	if !waiting {
+		return
+	}
+	h.rejectReasonInput.Delete(chatID)
+
+	var text string
+	err := h.paymentService.RejectManualPayment(ctx, purchaseId, strings.TrimSpace(update.Message.Text))
+	switch {
+	case errors.Is(err, payment.ErrNotAwaitingReview):
+		text = fmt.Sprintf("Purchase %d is not awaiting review", purchaseId)
+	case err != nil:
+		slog.Error("Error rejecting manual payment", "purchaseId", purchaseId, "error", err)
+		text = fmt.Sprintf("Rejecting purchase %d failed: %v", purchaseId, err)
+	default:
+		text = fmt.Sprintf("Purchase %d rejected", purchaseId)
+	}
+	h.sendManualMessage(ctx, b, chatID, text)
+}
+
+func (h Handler) sendManualMessage(ctx context.Context, b *bot.Bot, chatID int64, text string) {
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	})
+	if err != nil {
+		slog.Error("Error sending manual payment message", "error", err)
+	}
+}
+
+// reviewProvider returns the provider of the invoice type if its payments are reviewed by an admin.
+func (h Handler) reviewProvider(invoiceType database.InvoiceType) (payment.ReviewProvider, bool) {
+	provider, ok := h.paymentService.Provider(invoiceType)
+	if !ok {
+		return nil, false
+	}
+	reviewed, ok := provider.(payment.ReviewProvider)
+	return reviewed, ok
+}
@@ -23,7 +23,7 @@ func (h Handler) CreateCustomerIfNotExistMiddleware(next bot.HandlerFunc) bot.Ha
 		}
 		existingCustomer, err := h.customerRepository.FindByTelegramId(ctx, telegramId)
 		if err != nil {
-			slog.Error("error finding customer by telegram id", err)
+			slog.Error("error finding customer by telegram id", "error", err)
 			return
 		}
 
@@ -33,7 +33,7 @@ func (h Handler) CreateCustomerIfNotExistMiddleware(next bot.HandlerFunc) bot.Ha
 				Language:   langCode,
 			})
 			if err != nil {
-				slog.Error("error creating customer", err)
+				slog.Error("error creating customer", "error", err)
 				return
 			}
 		} else {
@@ -43,7 +43,7 @@ func (h Handler) CreateCustomerIfNotExistMiddleware(next bot.HandlerFunc) bot.Ha
 
 			err = h.customerRepository.UpdateFields(ctx, existingCustomer.ID, updates)
 			if err != nil {
-				slog.Error("Error updating customer", err)
+				slog.Error("Error updating customer", "error", err)
 				return
 			}
 		}
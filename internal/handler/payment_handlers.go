@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -13,56 +14,72 @@ import (
 
 	"remnawave-tg-shop-bot/internal/config"
 	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/internal/promo"
 )
 
 func (h Handler) BuyCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	callback := update.CallbackQuery.Message.Message
 	langCode := update.CallbackQuery.From.LanguageCode
 
-	var priceButtons []models.InlineKeyboardButton
-
-	if config.Price1() > 0 {
-		priceButtons = append(priceButtons, models.InlineKeyboardButton{
-			Text:         h.translation.GetText(langCode, "month_1"),
-			CallbackData: fmt.Sprintf("%s?month=%d&amount=%d", CallbackSell, 1, config.Price1()),
-		})
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil || customer == nil {
+		slog.Error("Error finding customer", "error", err)
+		return
 	}
+	currency := payment.PreferredCurrency(customer)
+	panel := h.paymentService.CustomerPanel(customer)
 
-	if config.Price3() > 0 {
-		priceButtons = append(priceButtons, models.InlineKeyboardButton{
-			Text:         h.translation.GetText(langCode, "month_3"),
-			CallbackData: fmt.Sprintf("%s?month=%d&amount=%d", CallbackSell, 3, config.Price3()),
-		})
+	tariffs, err := h.tariffRepository.FindActive(ctx)
+	if err != nil {
+		slog.Error("Error finding tariffs", "error", err)
+		return
 	}
 
-	if config.Price6() > 0 {
-		priceButtons = append(priceButtons, models.InlineKeyboardButton{
-			Text:         h.translation.GetText(langCode, "month_6"),
-			CallbackData: fmt.Sprintf("%s?month=%d&amount=%d", CallbackSell, 6, config.Price6()),
+	keyboard := [][]models.InlineKeyboardButton{}
+
+	var row []models.InlineKeyboardButton
+	for _, tariff := range tariffs {
+		if !tariff.IsAvailableOn(panel) {
+			continue
+		}
+		text := tariff.DisplayName(langCode)
+		if tariff.DeviceLimit != nil {
+			text = fmt.Sprintf(h.translation.GetText(langCode, "tariff_devices"), text, *tariff.DeviceLimit)
+		}
+		if price, ok := tariff.Price(currency); ok {
+			text = fmt.Sprintf("%s · %s", text, payment.FormatPrice(price, currency))
+		}
+		row = append(row, models.InlineKeyboardButton{
+			Text:         text,
+			CallbackData: fmt.Sprintf("%s?tariff=%d", CallbackSell, tariff.ID),
 		})
+		if len(row) == 2 {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
 	}
 
-	if config.Price12() > 0 {
-		priceButtons = append(priceButtons, models.InlineKeyboardButton{
-			Text:         h.translation.GetText(langCode, "month_12"),
-			CallbackData: fmt.Sprintf("%s?month=%d&amount=%d", CallbackSell, 12, config.Price12()),
+	if len(config.Currencies()) > 1 {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: fmt.Sprintf(h.translation.GetText(langCode, "currency_button"), currency), CallbackData: CallbackCurrency},
 		})
 	}
 
-	keyboard := [][]models.InlineKeyboardButton{}
-
-	if len(priceButtons) == 4 {
-		keyboard = append(keyboard, priceButtons[:2])
-		keyboard = append(keyboard, priceButtons[2:])
-	} else if len(priceButtons) > 0 {
-		keyboard = append(keyboard, priceButtons)
+	if len(config.Panels()) > 1 {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: fmt.Sprintf(h.translation.GetText(langCode, "location_button"), h.locationName(langCode, panel)), CallbackData: CallbackLocation},
+		})
 	}
 
 	keyboard = append(keyboard, []models.InlineKeyboardButton{
 		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackStart},
 	})
 
-	_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
 		ChatID:    callback.Chat.ID,
 		MessageID: callback.ID,
 		ParseMode: models.ParseModeHTML,
@@ -73,7 +90,7 @@ func (h Handler) BuyCallbackHandler(ctx context.Context, b *bot.Bot, update *mod
 	})
 
 	if err != nil {
-		slog.Error("Error sending buy message", err)
+		slog.Error("Error sending buy message", "error", err)
 	}
 }
 
@@ -81,75 +98,191 @@ func (h Handler) SellCallbackHandler(ctx context.Context, b *bot.Bot, update *mo
 	callback := update.CallbackQuery.Message.Message
 	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
 	langCode := update.CallbackQuery.From.LanguageCode
-	month := callbackQuery["month"]
-	amount := callbackQuery["amount"]
 
+	tariff, err := h.findActiveTariff(ctx, callbackQuery["tariff"])
+	if err != nil {
+		slog.Error("Error finding tariff", "error", err)
+		return
+	}
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil || customer == nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+
+	_, err = b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: h.buildSellKeyboard(ctx, langCode, tariff, payment.PreferredCurrency(customer), nil, callbackQuery["gift"] == "1"),
+		},
+	})
+
+	if err != nil {
+		slog.Error("Error sending sell message", "error", err)
+	}
+}
+
+// findActiveTariff resolves the tariff id of a callback, tariffs disabled since the menu was shown are not sold.
+func (h Handler) findActiveTariff(ctx context.Context, id string) (*database.Tariff, error) {
+	tariffId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tariff id %q: %w", id, err)
+	}
+
+	tariff, err := h.tariffRepository.FindById(ctx, tariffId)
+	if err != nil {
+		return nil, err
+	}
+	if tariff == nil || !tariff.Active {
+		return nil, fmt.Errorf("tariff %d is not available", tariffId)
+	}
+	return tariff, nil
+}
+
+// buildSellKeyboard lists the payment methods a tariff is priced for in the currency they would bill the customer in,
+// promoCode is passed on to the payment callback once applied. Gifts are bought without promo codes.
+func (h Handler) buildSellKeyboard(ctx context.Context, langCode string, tariff *database.Tariff, currency string, promoCode *database.PromoCode, gift bool) [][]models.InlineKeyboardButton {
 	var keyboard [][]models.InlineKeyboardButton
 
-	if config.IsCryptoPayEnabled() {
+	for _, provider := range h.paymentService.Providers() {
+		text := h.translation.GetText(langCode, provider.ButtonKey())
+		if link, ok := provider.(payment.LinkProvider); ok {
+			keyboard = append(keyboard, []models.InlineKeyboardButton{
+				{Text: text, URL: link.PaymentURL()},
+			})
+			continue
+		}
+		settlementCurrency := payment.SettlementCurrency(provider, currency)
+		price, ok := tariff.Price(settlementCurrency)
+		if !ok {
+			continue
+		}
+		if quoter, ok := provider.(payment.QuoteProvider); ok {
+			if promoCode != nil {
+				rubPrice, _ := tariff.Price(payment.CurrencyRUB)
+				price = promo.Apply(promoCode, price, rubPrice)
+			}
+			if quote := quoter.Quote(ctx, price, settlementCurrency); quote != "" {
+				text = fmt.Sprintf("%s (%s)", text, quote)
+			}
+		}
+		callbackData := paymentCallback{TariffID: tariff.ID, InvoiceType: provider.Type(), Gift: gift}
+		if promoCode != nil {
+			callbackData.PromoID = &promoCode.ID
+		}
 		keyboard = append(keyboard, []models.InlineKeyboardButton{
-			{Text: h.translation.GetText(langCode, "crypto_button"), CallbackData: fmt.Sprintf("%s?month=%s&invoiceType=%s&amount=%s", CallbackPayment, month, database.InvoiceTypeCrypto, amount)},
+			{Text: text, CallbackData: callbackData.String()},
 		})
+		if !gift && payment.CanAutoRenew(provider) {
+			callbackData.AutoRenew = true
+			keyboard = append(keyboard, []models.InlineKeyboardButton{
+				{Text: h.translation.GetText(langCode, "auto_renew_pay_button"), CallbackData: callbackData.String()},
+			})
+		}
 	}
 
-	if config.IsYookasaEnabled() {
+	if promoCode == nil && !gift {
 		keyboard = append(keyboard, []models.InlineKeyboardButton{
-			{Text: h.translation.GetText(langCode, "card_button"), CallbackData: fmt.Sprintf("%s?month=%s&invoiceType=%s&amount=%s", CallbackPayment, month, database.InvoiceTypeYookasa, amount)},
+			{Text: h.translation.GetText(langCode, "promo_button"), CallbackData: fmt.Sprintf("%s?tariff=%d", CallbackPromo, tariff.ID)},
 		})
 	}
 
-	if config.IsTelegramStarsEnabled() {
+	if gift {
 		keyboard = append(keyboard, []models.InlineKeyboardButton{
-			{Text: h.translation.GetText(langCode, "stars_button"), CallbackData: fmt.Sprintf("%s?month=%s&invoiceType=%s&amount=%s", CallbackPayment, month, database.InvoiceTypeTelegram, amount)},
+			{Text: h.translation.GetText(langCode, "gift_self_button"), CallbackData: fmt.Sprintf("%s?tariff=%d", CallbackSell, tariff.ID)},
 		})
-	}
-
-	if config.GetTributeWebHookUrl() != "" {
+	} else if promoCode == nil {
 		keyboard = append(keyboard, []models.InlineKeyboardButton{
-			{Text: h.translation.GetText(langCode, "tribute_button"), URL: config.GetTributePaymentUrl()},
+			{Text: h.translation.GetText(langCode, "gift_button"), CallbackData: fmt.Sprintf("%s?tariff=%d&gift=1", CallbackSell, tariff.ID)},
 		})
 	}
 
 	keyboard = append(keyboard, []models.InlineKeyboardButton{
 		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackBuy},
 	})
+	return keyboard
+}
 
-	_, err := b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
-		ChatID:    callback.Chat.ID,
-		MessageID: callback.ID,
-		ReplyMarkup: models.InlineKeyboardMarkup{
-			InlineKeyboard: keyboard,
-		},
-	})
+// paymentCallback is the state of a tariff payment button. Keys are single letters and ids are in base 36,
+// Telegram rejects the whole keyboard when the callback data of a button is over 64 bytes.
+type paymentCallback struct {
+	TariffID    int64
+	InvoiceType database.InvoiceType
+	PromoID     *int64
+	Gift        bool
+	AutoRenew   bool
+	SkipReceipt bool
+}
+
+func (c paymentCallback) String() string {
+	data := fmt.Sprintf("%s?t=%s&i=%s", CallbackPayment, strconv.FormatInt(c.TariffID, 36), c.InvoiceType)
+	if c.PromoID != nil {
+		data += "&c=" + strconv.FormatInt(*c.PromoID, 36)
+	}
+	if c.Gift {
+		data += "&g=1"
+	}
+	if c.AutoRenew {
+		data += "&r=1"
+	}
+	if c.SkipReceipt {
+		data += "&s=1"
+	}
+	return data
+}
 
+func parsePaymentCallback(data string) (paymentCallback, error) {
+	query := parseCallbackData(data)
+	tariffId, err := strconv.ParseInt(query["t"], 36, 64)
 	if err != nil {
-		slog.Error("Error sending sell message", err)
+		return paymentCallback{}, fmt.Errorf("invalid tariff id %q: %w", query["t"], err)
+	}
+
+	callback := paymentCallback{
+		TariffID:    tariffId,
+		InvoiceType: database.InvoiceType(query["i"]),
+		Gift:        query["g"] == "1",
+		AutoRenew:   query["r"] == "1",
+		SkipReceipt: query["s"] == "1",
 	}
+	if promo, ok := query["c"]; ok {
+		promoId, err := strconv.ParseInt(promo, 36, 64)
+		if err != nil {
+			return paymentCallback{}, fmt.Errorf("invalid promo code id %q: %w", promo, err)
+		}
+		callback.PromoID = &promoId
+	}
+	return callback, nil
 }
 
 func (h Handler) PaymentCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	callback := update.CallbackQuery.Message.Message
-	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
-	month, err := strconv.Atoi(callbackQuery["month"])
+	callbackQuery, err := parsePaymentCallback(update.CallbackQuery.Data)
 	if err != nil {
-		slog.Error("Error getting month from query", err)
+		slog.Error("Error parsing payment callback", "error", err)
 		return
 	}
 
-	invoiceType := database.InvoiceType(callbackQuery["invoiceType"])
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
 
-	var price int
-	if invoiceType == database.InvoiceTypeTelegram {
-		price = config.StarsPrice(month)
-	} else {
-		price = config.Price(month)
+	tariff, err := h.findActiveTariff(ctx, strconv.FormatInt(callbackQuery.TariffID, 10))
+	if err != nil {
+		slog.Error("Error finding tariff", "error", err)
+		return
+	}
+
+	invoiceType := callbackQuery.InvoiceType
+	if _, ok := h.paymentService.Provider(invoiceType); !ok {
+		slog.Error("Unknown invoice type", "invoiceType", invoiceType)
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
 	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
 	if err != nil {
-		slog.Error("Error finding customer", err)
+		slog.Error("Error finding customer", "error", err)
 		return
 	}
 	if customer == nil {
@@ -157,57 +290,91 @@ func (h Handler) PaymentCallbackHandler(ctx context.Context, b *bot.Bot, update
 		return
 	}
 
-	ctxWithUsername := context.WithValue(ctx, "username", update.CallbackQuery.From.Username)
-	paymentURL, purchaseId, err := h.paymentService.CreatePurchase(ctxWithUsername, price, month, customer, invoiceType)
-	if err != nil {
-		slog.Error("Error creating payment", err)
-		return
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	var promoCode *database.PromoCode
+	if callbackQuery.PromoID != nil {
+		promoCode, err = h.promoService.ValidateById(ctx, *callbackQuery.PromoID, customer.ID, tariff.Months())
+		if err != nil {
+			h.sendPromoError(ctx, b, callback.Chat.ID, langCode, err)
+			return
+		}
 	}
 
-	langCode := update.CallbackQuery.From.LanguageCode
+	if invoiceType == database.InvoiceTypeYookasa && !callbackQuery.SkipReceipt && needsReceiptContact(customer) {
+		h.askReceiptContact(ctx, b, callback, langCode, update.CallbackQuery.Data, fmt.Sprintf("%s?tariff=%d", CallbackSell, tariff.ID))
+		return
+	}
 
-	message, err := b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
-		ChatID:    callback.Chat.ID,
-		MessageID: callback.ID,
-		ReplyMarkup: models.InlineKeyboardMarkup{
-			InlineKeyboard: [][]models.InlineKeyboardButton{
-				{
-					{Text: h.translation.GetText(langCode, "pay_button"), URL: paymentURL},
-					{Text: h.translation.GetText(langCode, "back_button"), CallbackData: fmt.Sprintf("%s?month=%d&amount=%d", CallbackSell, month, price)},
-				},
-			},
-		},
-	})
+	ctxWithUsername := context.WithValue(ctx, "username", update.CallbackQuery.From.Username)
+	gift := callbackQuery.Gift
+	paymentURL, purchaseId, err := h.paymentService.CreateTariffPurchase(ctxWithUsername, tariff, customer, invoiceType, promoCode, gift, callbackQuery.AutoRenew)
+	if errors.Is(err, database.ErrInsufficientBalance) {
+		h.sendInsufficientBalance(ctx, b, callback, langCode, fmt.Sprintf("%s?tariff=%d", CallbackSell, tariff.ID))
+		return
+	}
+	if errors.Is(err, promo.ErrExhausted) || errors.Is(err, promo.ErrAlreadyUsed) {
+		h.sendPromoError(ctx, b, callback.Chat.ID, langCode, err)
+		return
+	}
 	if err != nil {
-		slog.Error("Error updating sell message", err)
+		slog.Error("Error creating payment", "error", err)
 		return
 	}
-	h.cache.Set(purchaseId, message.ID)
+
+	backData := fmt.Sprintf("%s?tariff=%d", CallbackSell, tariff.ID)
+	if gift {
+		backData += "&gift=1"
+	}
+	h.showInvoice(ctx, b, callback, langCode, invoiceType, paymentURL, purchaseId, backData)
 }
 
 func (h Handler) PreCheckoutCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	_, err := b.AnswerPreCheckoutQuery(ctx, &bot.AnswerPreCheckoutQueryParams{
+	params := &bot.AnswerPreCheckoutQueryParams{
 		PreCheckoutQueryID: update.PreCheckoutQuery.ID,
 		OK:                 true,
-	})
+	}
+
+	payload := strings.Split(update.PreCheckoutQuery.InvoicePayload, "&")
+	if purchaseId, err := strconv.ParseInt(payload[0], 10, 64); err == nil {
+		purchase, err := h.purchaseRepository.FindById(ctx, purchaseId)
+		switch {
+		case err != nil:
+			slog.Error("Error finding purchase", "error", err)
+		case purchase != nil && purchase.Status == database.PurchaseStatusExpired:
+			params.OK = false
+			params.ErrorMessage = h.translation.GetText(update.PreCheckoutQuery.From.LanguageCode, "invoice_expired")
+		case purchase == nil || (purchase.Status != database.PurchaseStatusNew && purchase.Status != database.PurchaseStatusPending):
+			params.OK = false
+			params.ErrorMessage = h.translation.GetText(update.PreCheckoutQuery.From.LanguageCode, "invoice_unavailable")
+		}
+	}
+
+	_, err := b.AnswerPreCheckoutQuery(ctx, params)
 	if err != nil {
-		slog.Error("Error sending answer pre checkout query", err)
+		slog.Error("Error sending answer pre checkout query", "error", err)
 	}
 }
 
 func (h Handler) SuccessPaymentHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	payload := strings.Split(update.Message.SuccessfulPayment.InvoicePayload, "&")
+	successfulPayment := update.Message.SuccessfulPayment
+	payload := strings.Split(successfulPayment.InvoicePayload, "&")
 	purchaseId, err := strconv.Atoi(payload[0])
 	username := payload[1]
 	if err != nil {
-		slog.Error("Error parsing purchase id", err)
+		slog.Error("Error parsing purchase id", "error", err)
 		return
 	}
 
 	ctxWithUsername := context.WithValue(ctx, "username", username)
-	err = h.paymentService.ProcessPurchaseById(ctxWithUsername, int64(purchaseId))
+	err = h.paymentService.ProcessStarsPayment(ctxWithUsername, int64(purchaseId), payment.StarsPayment{
+		ChargeID:         successfulPayment.TelegramPaymentChargeID,
+		Amount:           successfulPayment.TotalAmount,
+		IsRecurring:      successfulPayment.IsRecurring,
+		IsFirstRecurring: successfulPayment.IsFirstRecurring,
+	})
 	if err != nil {
-		slog.Error("Error processing purchase", err)
+		slog.Error("Error processing purchase", "error", err)
 	}
 }
 
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"remnawave-tg-shop-bot/internal/database"
+)
+
+func TestPaymentCallbackFitsTelegramLimit(t *testing.T) {
+	invoiceTypes := []database.InvoiceType{
+		database.InvoiceTypeCrypto,
+		database.InvoiceTypeYookasa,
+		database.InvoiceTypeTelegram,
+		database.InvoiceTypeTribute,
+		database.InvoiceTypeBalance,
+		database.InvoiceTypeManual,
+	}
+
+	promoId := int64(math.MaxInt64)
+	for _, invoiceType := range invoiceTypes {
+		data := paymentCallback{
+			TariffID:    math.MaxInt64,
+			InvoiceType: invoiceType,
+			PromoID:     &promoId,
+			Gift:        true,
+			AutoRenew:   true,
+			SkipReceipt: true,
+		}.String()
+
+		if len(data) > 64 {
+			t.Errorf("callback data %q is %d bytes, Telegram allows 64", data, len(data))
+		}
+	}
+}
+
+func TestPaymentCallbackRoundTrip(t *testing.T) {
+	promoId := int64(123456789)
+	tests := []paymentCallback{
+		{TariffID: 1, InvoiceType: database.InvoiceTypeCrypto},
+		{TariffID: 42, InvoiceType: database.InvoiceTypeYookasa, PromoID: &promoId, AutoRenew: true, SkipReceipt: true},
+		{TariffID: math.MaxInt64, InvoiceType: database.InvoiceTypeTelegram, Gift: true},
+	}
+
+	for _, want := range tests {
+		got, err := parsePaymentCallback(want.String())
+		if err != nil {
+			t.Fatalf("parsePaymentCallback(%q): %v", want.String(), err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parsePaymentCallback(%q) = %+v, want %+v", want.String(), got, want)
+		}
+	}
+}
+
+func TestParsePaymentCallbackReceiptSkip(t *testing.T) {
+	data := paymentCallback{TariffID: 7, InvoiceType: database.InvoiceTypeYookasa}.String() + "&s=1"
+
+	got, err := parsePaymentCallback(data)
+	if err != nil {
+		t.Fatalf("parsePaymentCallback(%q): %v", data, err)
+	}
+	if !got.SkipReceipt {
+		t.Errorf("parsePaymentCallback(%q) did not skip the receipt contact", data)
+	}
+}
+
+func TestParsePaymentCallbackRejectsInvalidIds(t *testing.T) {
+	for _, data := range []string{
+		"payment?i=crypto",
+		"payment?t=!&i=crypto",
+		"payment?t=1&i=crypto&c=!",
+	} {
+		if _, err := parsePaymentCallback(data); err == nil {
+			t.Errorf("parsePaymentCallback(%q) succeeded, want an error", data)
+		}
+	}
+}
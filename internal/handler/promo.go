@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/cache"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/internal/promo"
+)
+
+// newPromoInputCache remembers, per chat, the tariff a promo code is being entered for.
+func newPromoInputCache() *cache.Cache {
+	return cache.NewCache(10 * time.Minute)
+}
+
+func (h Handler) PromoCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	tariff, err := h.findActiveTariff(ctx, callbackQuery["tariff"])
+	if err != nil {
+		slog.Error("Error finding tariff", "error", err)
+		return
+	}
+
+	h.resetInputs(callback.Chat.ID)
+	h.promoInput.Set(callback.Chat.ID, int(tariff.ID))
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      h.translation.GetText(langCode, "promo_enter"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: fmt.Sprintf("%s?tariff=%d", CallbackSell, tariff.ID)}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending promo message", "error", err)
+	}
+}
+
+// IsPromoCodeInput matches plain text sent while the chat is expected to enter a promo code.
+func (h Handler) IsPromoCodeInput(update *models.Update) bool {
+	if update.Message == nil || update.Message.Text == "" || strings.HasPrefix(update.Message.Text, "/") {
+		return false
+	}
+	_, waiting := h.promoInput.Get(update.Message.Chat.ID)
+	return waiting
+}
+
+func (h Handler) PromoCodeMessageHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	langCode := update.Message.From.LanguageCode
+
+	tariffId, waiting := h.promoInput.Get(chatID)
+	if !waiting {
+		return
+	}
+
+	tariff, err := h.findActiveTariff(ctx, strconv.Itoa(tariffId))
+	if err != nil {
+		slog.Error("Error finding tariff", "error", err)
+		h.promoInput.Delete(chatID)
+		return
+	}
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, chatID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	promoCode, err := h.promoService.Validate(ctx, update.Message.Text, customer.ID, tariff.Months())
+	if err != nil {
+		h.sendPromoError(ctx, b, chatID, langCode, err)
+		return
+	}
+	h.promoInput.Delete(chatID)
+
+	currency := payment.PreferredCurrency(customer)
+	price, ok := tariff.Price(currency)
+	if !ok {
+		currency = payment.CurrencyRUB
+		price, _ = tariff.Price(currency)
+	}
+	rubPrice, _ := tariff.Price(payment.CurrencyRUB)
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    chatID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(h.translation.GetText(langCode, "promo_applied"), promoCode.Code, formatDiscount(promoCode), payment.FormatPrice(promo.Apply(promoCode, price, rubPrice), currency)),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: h.buildSellKeyboard(ctx, langCode, tariff, payment.PreferredCurrency(customer), promoCode, false),
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending promo message", "error", err)
+	}
+}
+
+func (h Handler) sendPromoError(ctx context.Context, b *bot.Bot, chatID int64, langCode string, err error) {
+	var key string
+	switch {
+	case errors.Is(err, promo.ErrNotFound), errors.Is(err, promo.ErrInvalidFormat):
+		key = "promo_not_found"
+	case errors.Is(err, promo.ErrNotActive):
+		key = "promo_not_active"
+	case errors.Is(err, promo.ErrPlanNotFit):
+		key = "promo_plan_not_fit"
+	case errors.Is(err, promo.ErrExhausted):
+		key = "promo_exhausted"
+	case errors.Is(err, promo.ErrAlreadyUsed):
+		key = "promo_already_used"
+	default:
+		slog.Error("Error validating promo code", "error", err)
+		return
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   h.translation.GetText(langCode, key),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackBuy}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending promo message", "error", err)
+	}
+}
+
+// PromoCreateCommandHandler handles
+// /promo_create <code> <20%|150> [max=100] [per_user=1] [months=1,3] [from=2006-01-02] [until=2006-01-02]
+func (h Handler) PromoCreateCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	var text string
+
+	promoCode, err := parsePromoCreateArgs(strings.Fields(update.Message.Text)[1:])
+	if err != nil {
+		text = fmt.Sprintf("%v\nUsage: /promo_create <code> <20%%|150> [max=100] [per_user=1] [months=1,3] [from=2006-01-02] [until=2006-01-02]", err)
+	} else if _, err := h.promoRepository.Create(ctx, promoCode); err != nil {
+		slog.Error("Error creating promo code", "error", err)
+		text = fmt.Sprintf("Failed to create promo code: %v", err)
+	} else {
+		text = fmt.Sprintf("Promo code %s created", promoCode.Code)
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	if err != nil {
+		slog.Error("Error sending promo message", "error", err)
+	}
+}
+
+func (h Handler) PromoListCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	promoCodes, err := h.promoRepository.FindAll(ctx)
+	if err != nil {
+		slog.Error("Error finding promo codes", "error", err)
+		return
+	}
+
+	var info strings.Builder
+	if len(promoCodes) == 0 {
+		info.WriteString("No promo codes")
+	}
+	for _, promoCode := range promoCodes {
+		uses, err := h.promoRepository.CountRedemptions(ctx, promoCode.ID, nil)
+		if err != nil {
+			slog.Error("Error counting promo code redemptions", "error", err)
+			return
+		}
+
+		info.WriteString(fmt.Sprintf("%s: -%s, used %d", promoCode.Code, formatDiscount(&promoCode), uses))
+		if promoCode.MaxUses != nil {
+			info.WriteString(fmt.Sprintf("/%d", *promoCode.MaxUses))
+		}
+		info.WriteString(fmt.Sprintf(", per user %d", promoCode.PerUserLimit))
+		if len(promoCode.Months) > 0 {
+			months := make([]string, 0, len(promoCode.Months))
+			for _, m := range promoCode.Months {
+				months = append(months, strconv.Itoa(int(m)))
+			}
+			info.WriteString(fmt.Sprintf(", months %s", strings.Join(months, ",")))
+		}
+		if promoCode.ValidFrom != nil {
+			info.WriteString(fmt.Sprintf(", from %s", promoCode.ValidFrom.Format("02.01.2006")))
+		}
+		if promoCode.ValidUntil != nil {
+			info.WriteString(fmt.Sprintf(", until %s", promoCode.ValidUntil.Format("02.01.2006")))
+		}
+		info.WriteString("\n")
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   info.String(),
+	})
+	if err != nil {
+		slog.Error("Error sending promo list message", "error", err)
+	}
+}
+
+func parsePromoCreateArgs(args []string) (*database.PromoCode, error) {
+	if len(args) < 2 {
+		return nil, errors.New("code and discount are required")
+	}
+
+	promoCode := &database.PromoCode{
+		Code:         promo.Normalize(args[0]),
+		PerUserLimit: 1,
+	}
+	if promoCode.Code == "" || len(promoCode.Code) > 64 {
+		return nil, errors.New("invalid code")
+	}
+
+	discount := args[1]
+	if strings.HasSuffix(discount, "%") {
+		value, err := strconv.Atoi(strings.TrimSuffix(discount, "%"))
+		if err != nil || value <= 0 || value > 100 {
+			return nil, fmt.Errorf("invalid percent discount %q", discount)
+		}
+		promoCode.DiscountType = database.DiscountTypePercent
+		promoCode.DiscountValue = value
+	} else {
+		value, err := strconv.Atoi(discount)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid fixed discount %q", discount)
+		}
+		promoCode.DiscountType = database.DiscountTypeFixed
+		promoCode.DiscountValue = value
+	}
+
+	for _, arg := range args[2:] {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid option %q", arg)
+		}
+
+		switch key {
+		case "max":
+			maxUses, err := strconv.Atoi(value)
+			if err != nil || maxUses <= 0 {
+				return nil, fmt.Errorf("invalid max %q", value)
+			}
+			promoCode.MaxUses = &maxUses
+		case "per_user":
+			perUser, err := strconv.Atoi(value)
+			if err != nil || perUser < 0 {
+				return nil, fmt.Errorf("invalid per_user %q", value)
+			}
+			promoCode.PerUserLimit = perUser
+		case "months":
+			for _, m := range strings.Split(value, ",") {
+				month, err := strconv.Atoi(m)
+				if err != nil || month <= 0 {
+					return nil, fmt.Errorf("invalid months %q", value)
+				}
+				promoCode.Months = append(promoCode.Months, int32(month))
+			}
+		case "from", "until":
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s date %q", key, value)
+			}
+			if key == "from" {
+				promoCode.ValidFrom = &date
+			} else {
+				until := date.AddDate(0, 0, 1)
+				promoCode.ValidUntil = &until
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	return promoCode, nil
+}
+
+func formatDiscount(promoCode *database.PromoCode) string {
+	if promoCode.DiscountType == database.DiscountTypePercent {
+		return fmt.Sprintf("%d%%", promoCode.DiscountValue)
+	}
+	return fmt.Sprintf("%d ₽", promoCode.DiscountValue)
+}
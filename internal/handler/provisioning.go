@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/payment"
+)
+
+// ProvisioningRetryCallbackHandler runs a failed provisioning job from the admin alert right away.
+func (h Handler) ProvisioningRetryCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+
+	jobId, err := strconv.ParseInt(callbackQuery["job"], 10, 64)
+	if err != nil {
+		slog.Error("Error parsing provisioning job id", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	var text string
+	err = h.paymentService.RetryProvisioningJob(ctx, jobId)
+	switch {
+	case errors.Is(err, payment.ErrJobNotPending):
+		text = fmt.Sprintf("Provisioning job %d is already running or done", jobId)
+	case err != nil:
+		text = fmt.Sprintf("Provisioning job %d failed again: %v", jobId, err)
+	default:
+		text = fmt.Sprintf("Provisioning job %d done", jobId)
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: callback.Chat.ID,
+		Text:   text,
+	})
+	if err != nil {
+		slog.Error("Error sending provisioning message", "error", err)
+	}
+}
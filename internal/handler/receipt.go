@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/cache"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+)
+
+// newReceiptInputCache remembers, per chat, the payment callback to continue with once the receipt contact is entered.
+func newReceiptInputCache() *cache.TypedCache[string] {
+	return cache.NewTypedCache[string](10 * time.Minute)
+}
+
+// needsReceiptContact reports whether the customer should be asked where to send YooKassa receipts.
+func needsReceiptContact(customer *database.Customer) bool {
+	return config.IsYookasaReceiptContactEnabled() && customer.ReceiptEmail == nil && customer.ReceiptPhone == nil
+}
+
+// askReceiptContact offers to enter an e-mail or phone for the receipt, skipping continues with the shop e-mail.
+func (h Handler) askReceiptContact(ctx context.Context, b *bot.Bot, callback *models.Message, langCode string, paymentData string, backData string) {
+	h.resetInputs(callback.Chat.ID)
+	h.receiptInput.Set(callback.Chat.ID, paymentData)
+
+	_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		Text:      h.translation.GetText(langCode, "receipt_contact_enter"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "receipt_contact_skip_button"), CallbackData: paymentData + "&s=1"}},
+				{{Text: h.translation.GetText(langCode, "back_button"), CallbackData: backData}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending receipt contact message", "error", err)
+	}
+}
+
+// IsReceiptContactInput matches plain text sent while the chat is expected to enter a receipt contact.
+func (h Handler) IsReceiptContactInput(update *models.Update) bool {
+	if update.Message == nil || update.Message.Text == "" || strings.HasPrefix(update.Message.Text, "/") {
+		return false
+	}
+	_, waiting := h.receiptInput.Get(update.Message.Chat.ID)
+	return waiting
+}
+
+func (h Handler) ReceiptContactMessageHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatID := update.Message.Chat.ID
+	langCode := update.Message.From.LanguageCode
+
+	paymentData, waiting := h.receiptInput.Get(chatID)
+	if !waiting {
+		return
+	}
+
+	field, value, ok := parseReceiptContact(update.Message.Text)
+	if !ok {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   h.translation.GetText(langCode, "receipt_contact_invalid"),
+		})
+		if err != nil {
+			slog.Error("Error sending receipt contact message", "error", err)
+		}
+		return
+	}
+	h.receiptInput.Delete(chatID)
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, chatID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	err = h.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+		field: value,
+	})
+	if err != nil {
+		slog.Error("Error saving receipt contact", "error", err)
+		return
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf(h.translation.GetText(langCode, "receipt_contact_saved"), value),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: h.translation.GetText(langCode, "receipt_contact_continue_button"), CallbackData: paymentData}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending receipt contact message", "error", err)
+	}
+}
+
+// parseReceiptContact accepts an e-mail or a phone number, phones are reduced to the digits YooKassa expects.
+func parseReceiptContact(text string) (field string, value string, ok bool) {
+	text = strings.TrimSpace(text)
+	if strings.Contains(text, "@") {
+		address, err := mail.ParseAddress(text)
+		if err != nil || address.Address != text || len(text) > 255 {
+			return "", "", false
+		}
+		return "receipt_email", text, true
+	}
+
+	var digits strings.Builder
+	for i, r := range text {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0, r == ' ', r == '-', r == '(', r == ')':
+		default:
+			return "", "", false
+		}
+	}
+	phone := digits.String()
+	if strings.HasPrefix(phone, "8") && len(phone) == 11 {
+		phone = "7" + phone[1:]
+	}
+	if len(phone) < 10 || len(phone) > 15 {
+		return "", "", false
+	}
+	return "receipt_phone", phone, true
+}
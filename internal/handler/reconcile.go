@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/payment"
+)
+
+// maxReportMismatches keeps the reconciliation report within a single message.
+const maxReportMismatches = 30
+
+// ReconcileCommandHandler handles /reconcile [days], comparing the purchases of the last days with the providers.
+func (h Handler) ReconcileCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	days := config.ReconciliationDays()
+
+	args := strings.Fields(update.Message.Text)
+	if len(args) == 2 {
+		parsed, err := strconv.Atoi(args[1])
+		if err != nil || parsed <= 0 {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Usage: /reconcile [days]",
+			})
+			if err != nil {
+				slog.Error("Error sending reconciliation message", "error", err)
+			}
+			return
+		}
+		days = parsed
+	}
+
+	h.SendReconciliationReport(ctx, b, update.Message.Chat.ID, days, false)
+}
+
+// SendReconciliationReport sends the mismatches of the last days with a reprocess button per unprocessed payment.
+// A quiet report is not sent when nothing is found.
+func (h Handler) SendReconciliationReport(ctx context.Context, b *bot.Bot, chatID int64, days int, quiet bool) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -days)
+
+	mismatches, err := h.paymentService.Reconcile(ctx, from, to)
+	if err != nil {
+		slog.Error("Error reconciling payments", "error", err)
+		mismatches = nil
+		if quiet {
+			return
+		}
+	}
+	if len(mismatches) == 0 && quiet {
+		return
+	}
+
+	var text strings.Builder
+	var keyboard [][]models.InlineKeyboardButton
+	switch {
+	case err != nil:
+		text.WriteString(fmt.Sprintf("Reconciliation failed: %v", err))
+	case len(mismatches) == 0:
+		text.WriteString(fmt.Sprintf("No mismatches since %s", from.Format("02.01.2006 15:04")))
+	default:
+		text.WriteString(fmt.Sprintf("%d mismatches since %s\n\n", len(mismatches), from.Format("02.01.2006 15:04")))
+		for i, mismatch := range mismatches {
+			if i == maxReportMismatches {
+				text.WriteString(fmt.Sprintf("...and %d more\n", len(mismatches)-i))
+				break
+			}
+			text.WriteString(formatMismatch(mismatch))
+			text.WriteString("\n")
+			if mismatch.Kind == payment.MismatchUnprocessed {
+				keyboard = append(keyboard, []models.InlineKeyboardButton{
+					{Text: fmt.Sprintf("Reprocess %d", mismatch.PurchaseID), CallbackData: fmt.Sprintf("%s?purchase=%d", CallbackReprocess, mismatch.PurchaseID)},
+				})
+			}
+		}
+	}
+
+	params := &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text.String(),
+	}
+	if len(keyboard) > 0 {
+		params.ReplyMarkup = models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	}
+	_, err = b.SendMessage(ctx, params)
+	if err != nil {
+		slog.Error("Error sending reconciliation message", "error", err)
+	}
+}
+
+func (h Handler) ReprocessCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+
+	var text string
+	purchaseId, err := strconv.ParseInt(callbackQuery["purchase"], 10, 64)
+	if err != nil {
+		slog.Error("Error parsing purchase id", "error", err)
+		return
+	}
+
+	err = h.paymentService.ReprocessPurchase(ctx, purchaseId)
+	switch {
+	case errors.Is(err, payment.ErrNotPaid):
+		text = fmt.Sprintf("Purchase %d is not paid at the provider", purchaseId)
+	case err != nil:
+		slog.Error("Error reprocessing purchase", "purchaseId", purchaseId, "error", err)
+		text = fmt.Sprintf("Reprocessing purchase %d failed: %v", purchaseId, err)
+	default:
+		text = fmt.Sprintf("Purchase %d reprocessed", purchaseId)
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: callback.Chat.ID,
+		Text:   text,
+	})
+	if err != nil {
+		slog.Error("Error sending reconciliation message", "error", err)
+	}
+}
+
+func formatMismatch(mismatch payment.Mismatch) string {
+	switch mismatch.Kind {
+	case payment.MismatchUnprocessed:
+		return fmt.Sprintf("%s: purchase %d is %s, paid as %s (%s %s)", mismatch.InvoiceType, mismatch.PurchaseID, mismatch.Status, mismatch.PaymentID, mismatch.Amount, mismatch.Currency)
+	case payment.MismatchMissingPayment:
+		return fmt.Sprintf("%s: purchase %d is paid, no provider payment (%s %s)", mismatch.InvoiceType, mismatch.PurchaseID, mismatch.Amount, mismatch.Currency)
+	default:
+		return fmt.Sprintf("%s: payment %s has no purchase (%s %s)", mismatch.InvoiceType, mismatch.PaymentID, mismatch.Amount, mismatch.Currency)
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/config"
+)
+
+func (h Handler) RefundCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	var text string
+
+	args := strings.Fields(update.Message.Text)
+	toBalance := len(args) == 3 && args[2] == "balance"
+	if len(args) != 2 && !toBalance {
+		text = "Usage: /refund <purchaseId> [balance]"
+	} else if purchaseId, err := strconv.ParseInt(args[1], 10, 64); err != nil {
+		text = fmt.Sprintf("Invalid purchase id: %s", args[1])
+	} else if err := h.refund(ctx, purchaseId, toBalance); err != nil {
+		slog.Error("Error refunding purchase", "purchaseId", purchaseId, "error", err)
+		text = fmt.Sprintf("Refund of purchase %d failed: %v", purchaseId, err)
+	} else if toBalance {
+		text = fmt.Sprintf("Purchase %d refunded to balance", purchaseId)
+	} else {
+		text = fmt.Sprintf("Purchase %d refunded", purchaseId)
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	if err != nil {
+		slog.Error("Error sending refund message", "error", err)
+	}
+}
+
+func (h Handler) refund(ctx context.Context, purchaseId int64, toBalance bool) error {
+	if toBalance {
+		if !config.IsBalanceEnabled() {
+			return errors.New("balance is disabled")
+		}
+		return h.paymentService.RefundToBalance(ctx, purchaseId)
+	}
+	return h.paymentService.RefundPurchase(ctx, purchaseId)
+}
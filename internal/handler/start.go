@@ -12,6 +12,7 @@ import (
 
 	"remnawave-tg-shop-bot/internal/config"
 	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
 	"remnawave-tg-shop-bot/utils"
 )
 
@@ -21,7 +22,7 @@ func (h Handler) StartCommandHandler(ctx context.Context, b *bot.Bot, update *mo
 	langCode := update.Message.From.LanguageCode
 	existingCustomer, err := h.customerRepository.FindByTelegramId(ctx, update.Message.Chat.ID)
 	if err != nil {
-		slog.Error("error finding customer by telegram id", err)
+		slog.Error("error finding customer by telegram id", "error", err)
 		return
 	}
 
@@ -31,7 +32,7 @@ func (h Handler) StartCommandHandler(ctx context.Context, b *bot.Bot, update *mo
 			Language:   langCode,
 		})
 		if err != nil {
-			slog.Error("error creating customer", err)
+			slog.Error("error creating customer", "error", err)
 			return
 		}
 
@@ -41,14 +42,14 @@ func (h Handler) StartCommandHandler(ctx context.Context, b *bot.Bot, update *mo
 				code := strings.TrimPrefix(arg, "ref_")
 				referrerId, err := strconv.ParseInt(code, 10, 64)
 				if err != nil {
-					slog.Error("error parsing referrer id", err)
+					slog.Error("error parsing referrer id", "error", err)
 					return
 				}
 				_, err = h.customerRepository.FindByTelegramId(ctx, referrerId)
 				if err == nil {
 					_, err := h.referralRepository.Create(ctx, referrerId, existingCustomer.TelegramID)
 					if err != nil {
-						slog.Error("error creating referral", err)
+						slog.Error("error creating referral", "error", err)
 						return
 					}
 					slog.Info("referral created", "referrerId", utils.MaskHalfInt64(referrerId), "refereeId", utils.MaskHalfInt64(existingCustomer.TelegramID))
@@ -62,12 +63,17 @@ func (h Handler) StartCommandHandler(ctx context.Context, b *bot.Bot, update *mo
 
 		err = h.customerRepository.UpdateFields(ctx, existingCustomer.ID, updates)
 		if err != nil {
-			slog.Error("Error updating customer", err)
+			slog.Error("Error updating customer", "error", err)
 			return
 		}
+		existingCustomer.Language = langCode
 	}
 
-	inlineKeyboard := h.buildStartKeyboard(existingCustomer, langCode)
+	if args := strings.Fields(update.Message.Text); len(args) > 1 && strings.HasPrefix(args[1], payment.GiftStartPrefix) {
+		h.redeemGift(ctx, b, existingCustomer, strings.TrimPrefix(args[1], payment.GiftStartPrefix))
+	}
+
+	inlineKeyboard := h.buildStartKeyboard(ctx, existingCustomer, langCode)
 
 	m, err := b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
@@ -78,7 +84,7 @@ func (h Handler) StartCommandHandler(ctx context.Context, b *bot.Bot, update *mo
 	})
 
 	if err != nil {
-		slog.Error("Error sending removing reply keyboard", err)
+		slog.Error("Error sending removing reply keyboard", "error", err)
 		return
 	}
 
@@ -88,7 +94,7 @@ func (h Handler) StartCommandHandler(ctx context.Context, b *bot.Bot, update *mo
 	})
 
 	if err != nil {
-		slog.Error("Error deleting message", err)
+		slog.Error("Error deleting message", "error", err)
 		return
 	}
 
@@ -101,7 +107,7 @@ func (h Handler) StartCommandHandler(ctx context.Context, b *bot.Bot, update *mo
 		Text: h.translation.GetText(langCode, "greeting"),
 	})
 	if err != nil {
-		slog.Error("Error sending /start message", err)
+		slog.Error("Error sending /start message", "error", err)
 	}
 }
 
@@ -114,11 +120,11 @@ func (h Handler) StartCallbackHandler(ctx context.Context, b *bot.Bot, update *m
 
 	existingCustomer, err := h.customerRepository.FindByTelegramId(ctxWithTime, callback.From.ID)
 	if err != nil {
-		slog.Error("error finding customer by telegram id", err)
+		slog.Error("error finding customer by telegram id", "error", err)
 		return
 	}
 
-	inlineKeyboard := h.buildStartKeyboard(existingCustomer, langCode)
+	inlineKeyboard := h.buildStartKeyboard(ctxWithTime, existingCustomer, langCode)
 
 	_, err = b.EditMessageText(ctxWithTime, &bot.EditMessageTextParams{
 		ChatID:    callback.Message.Message.Chat.ID,
@@ -130,7 +136,7 @@ func (h Handler) StartCallbackHandler(ctx context.Context, b *bot.Bot, update *m
 		Text: h.translation.GetText(langCode, "greeting"),
 	})
 	if err != nil {
-		slog.Error("Error sending /start message", err)
+		slog.Error("Error sending /start message", "error", err)
 	}
 }
 
@@ -151,7 +157,7 @@ func (h Handler) resolveConnectButton(lang string) []models.InlineKeyboardButton
 	return inlineKeyboard
 }
 
-func (h Handler) buildStartKeyboard(existingCustomer *database.Customer, langCode string) [][]models.InlineKeyboardButton {
+func (h Handler) buildStartKeyboard(ctx context.Context, existingCustomer *database.Customer, langCode string) [][]models.InlineKeyboardButton {
 	var inlineKeyboard [][]models.InlineKeyboardButton
 
 	if existingCustomer.SubscriptionLink == nil && config.TrialDays() > 0 {
@@ -162,6 +168,13 @@ func (h Handler) buildStartKeyboard(existingCustomer *database.Customer, langCod
 
 	if existingCustomer.SubscriptionLink != nil && existingCustomer.ExpireAt.After(time.Now()) {
 		inlineKeyboard = append(inlineKeyboard, h.resolveConnectButton(langCode))
+		if len(config.TrafficPackages()) > 0 {
+			inlineKeyboard = append(inlineKeyboard, []models.InlineKeyboardButton{{Text: h.translation.GetText(langCode, "traffic_button"), CallbackData: CallbackTraffic}})
+		}
+	}
+
+	if config.IsBalanceEnabled() {
+		inlineKeyboard = append(inlineKeyboard, h.balanceButton(ctx, existingCustomer, langCode))
 	}
 
 	if config.GetReferralDays() > 0 {
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+)
+
+const tariffOptionsUsage = "[price_rub=199] [price_stars=150] [name_en=1_month] [name_ru=1_месяц] [traffic=100] [strategy=MONTH] [devices=3|none] [inbounds=uuid,uuid|none] [squads=name,name|none] [panel=name|none] [sort=1]"
+
+var trafficStrategies = map[string]bool{"NO_RESET": true, "DAY": true, "WEEK": true, "MONTH": true}
+
+// TariffAddCommandHandler handles /tariff_add <days> [options]
+func (h Handler) TariffAddCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	var text string
+
+	tariff, err := parseTariffAddArgs(strings.Fields(update.Message.Text)[1:])
+	if err != nil {
+		text = fmt.Sprintf("%v\nUsage: /tariff_add <days> %s", err, tariffOptionsUsage)
+	} else if _, err := h.tariffRepository.Create(ctx, tariff); err != nil {
+		slog.Error("Error creating tariff", "error", err)
+		text = fmt.Sprintf("Failed to create tariff: %v", err)
+	} else {
+		text = fmt.Sprintf("Tariff %d created", tariff.ID)
+	}
+
+	h.sendTariffMessage(ctx, b, update.Message.Chat.ID, text)
+}
+
+// TariffSetCommandHandler handles /tariff_set <id> [options] [days=30] [active=true|false]
+func (h Handler) TariffSetCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args := strings.Fields(update.Message.Text)[1:]
+	usage := fmt.Sprintf("Usage: /tariff_set <id> [days=30] [active=true|false] %s", tariffOptionsUsage)
+	if len(args) < 2 {
+		h.sendTariffMessage(ctx, b, update.Message.Chat.ID, usage)
+		return
+	}
+
+	tariffId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		h.sendTariffMessage(ctx, b, update.Message.Chat.ID, fmt.Sprintf("Invalid tariff id: %s", args[0]))
+		return
+	}
+
+	tariff, err := h.tariffRepository.FindById(ctx, tariffId)
+	if err != nil {
+		slog.Error("Error finding tariff", "error", err)
+		return
+	}
+	if tariff == nil {
+		h.sendTariffMessage(ctx, b, update.Message.Chat.ID, fmt.Sprintf("Tariff %d not found", tariffId))
+		return
+	}
+
+	var text string
+	if err := applyTariffOptions(tariff, args[1:]); err != nil {
+		text = fmt.Sprintf("%v\n%s", err, usage)
+	} else if err := h.tariffRepository.UpdateFields(ctx, tariff.ID, map[string]interface{}{
+		"name":             tariff.Name,
+		"duration_days":    tariff.DurationDays,
+		"traffic_limit_gb": tariff.TrafficLimitGB,
+		"traffic_strategy": tariff.TrafficStrategy,
+		"device_limit":     tariff.DeviceLimit,
+		"inbounds":         tariff.Inbounds,
+		"squads":           tariff.Squads,
+		"prices":           tariff.Prices,
+		"sort_order":       tariff.SortOrder,
+		"active":           tariff.Active,
+		"panel":            tariff.Panel,
+	}); err != nil {
+		slog.Error("Error updating tariff", "error", err)
+		text = fmt.Sprintf("Failed to update tariff: %v", err)
+	} else {
+		text = fmt.Sprintf("Tariff %d updated\n%s", tariff.ID, formatTariff(tariff))
+	}
+
+	h.sendTariffMessage(ctx, b, update.Message.Chat.ID, text)
+}
+
+func (h Handler) TariffListCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	tariffs, err := h.tariffRepository.FindAll(ctx)
+	if err != nil {
+		slog.Error("Error finding tariffs", "error", err)
+		return
+	}
+
+	var info strings.Builder
+	if len(tariffs) == 0 {
+		info.WriteString("No tariffs")
+	}
+	for _, tariff := range tariffs {
+		info.WriteString(formatTariff(&tariff))
+		info.WriteString("\n")
+	}
+
+	h.sendTariffMessage(ctx, b, update.Message.Chat.ID, info.String())
+}
+
+func (h Handler) sendTariffMessage(ctx context.Context, b *bot.Bot, chatID int64, text string) {
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	})
+	if err != nil {
+		slog.Error("Error sending tariff message", "error", err)
+	}
+}
+
+func parseTariffAddArgs(args []string) (*database.Tariff, error) {
+	if len(args) < 1 {
+		return nil, errors.New("duration in days is required")
+	}
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days <= 0 {
+		return nil, fmt.Errorf("invalid days %q", args[0])
+	}
+
+	tariff := &database.Tariff{
+		Name:            map[string]string{},
+		DurationDays:    days,
+		TrafficStrategy: "MONTH",
+		Prices:          map[string]int{},
+		Active:          true,
+	}
+	if err := applyTariffOptions(tariff, args[1:]); err != nil {
+		return nil, err
+	}
+	if len(tariff.Prices) == 0 {
+		return nil, errors.New("at least one price is required")
+	}
+	return tariff, nil
+}
+
+// applyTariffOptions sets key=value options on a tariff. Underscores in names stand for spaces.
+func applyTariffOptions(tariff *database.Tariff, args []string) error {
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return fmt.Errorf("invalid option %q", arg)
+		}
+
+		switch {
+		case strings.HasPrefix(key, "price_"):
+			price, err := strconv.Atoi(value)
+			if err != nil || price < 0 {
+				return fmt.Errorf("invalid %s %q", key, value)
+			}
+			currency := strings.ToUpper(strings.TrimPrefix(key, "price_"))
+			if price == 0 {
+				delete(tariff.Prices, currency)
+			} else {
+				tariff.Prices[currency] = price
+			}
+		case strings.HasPrefix(key, "name_"):
+			tariff.Name[strings.TrimPrefix(key, "name_")] = strings.ReplaceAll(value, "_", " ")
+		case key == "days":
+			days, err := strconv.Atoi(value)
+			if err != nil || days <= 0 {
+				return fmt.Errorf("invalid days %q", value)
+			}
+			tariff.DurationDays = days
+		case key == "traffic":
+			traffic, err := strconv.Atoi(value)
+			if err != nil || traffic < 0 {
+				return fmt.Errorf("invalid traffic %q", value)
+			}
+			tariff.TrafficLimitGB = traffic
+		case key == "strategy":
+			strategy := strings.ToUpper(value)
+			if !trafficStrategies[strategy] {
+				return fmt.Errorf("invalid strategy %q, expected NO_RESET, DAY, WEEK or MONTH", value)
+			}
+			tariff.TrafficStrategy = strategy
+		case key == "devices":
+			if value == "none" {
+				tariff.DeviceLimit = nil
+				continue
+			}
+			devices, err := strconv.Atoi(value)
+			if err != nil || devices < 0 {
+				return fmt.Errorf("invalid devices %q", value)
+			}
+			tariff.DeviceLimit = &devices
+		case key == "inbounds":
+			if value == "none" {
+				tariff.Inbounds = nil
+				continue
+			}
+			var inbounds []string
+			for _, inbound := range strings.Split(value, ",") {
+				if _, err := uuid.Parse(inbound); err != nil {
+					return fmt.Errorf("invalid inbound %q", inbound)
+				}
+				inbounds = append(inbounds, inbound)
+			}
+			tariff.Inbounds = inbounds
+		case key == "squads":
+			if value == "none" {
+				tariff.Squads = nil
+				continue
+			}
+			var squads []string
+			for _, squad := range strings.Split(value, ",") {
+				if squad == "" {
+					return fmt.Errorf("invalid squads %q", value)
+				}
+				squads = append(squads, squad)
+			}
+			tariff.Squads = squads
+		case key == "panel":
+			if value == "none" {
+				tariff.Panel = nil
+				continue
+			}
+			if !config.IsPanelConfigured(value) {
+				return fmt.Errorf("unknown panel %q", value)
+			}
+			panel := value
+			tariff.Panel = &panel
+		case key == "sort":
+			sortOrder, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid sort %q", value)
+			}
+			tariff.SortOrder = sortOrder
+		case key == "active":
+			active, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid active %q", value)
+			}
+			tariff.Active = active
+		default:
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return nil
+}
+
+func formatTariff(tariff *database.Tariff) string {
+	var info strings.Builder
+	info.WriteString(fmt.Sprintf("#%d %s: %d days", tariff.ID, tariff.DisplayName("en"), tariff.DurationDays))
+
+	currencies := make([]string, 0, len(tariff.Prices))
+	for currency := range tariff.Prices {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		info.WriteString(fmt.Sprintf(", %d %s", tariff.Prices[currency], currency))
+	}
+
+	if tariff.TrafficLimitGB > 0 {
+		info.WriteString(fmt.Sprintf(", %d GB/%s", tariff.TrafficLimitGB, tariff.TrafficStrategy))
+	} else {
+		info.WriteString(", unlimited traffic")
+	}
+	if tariff.DeviceLimit != nil {
+		info.WriteString(fmt.Sprintf(", %d devices", *tariff.DeviceLimit))
+	}
+	if tariff.Inbounds != nil {
+		info.WriteString(fmt.Sprintf(", inbounds %s", strings.Join(tariff.Inbounds, ",")))
+	}
+	if tariff.Squads != nil {
+		info.WriteString(fmt.Sprintf(", squads %s", strings.Join(tariff.Squads, ",")))
+	}
+	if tariff.Panel != nil {
+		info.WriteString(fmt.Sprintf(", panel %s", *tariff.Panel))
+	}
+	if !tariff.Active {
+		info.WriteString(", inactive")
+	}
+	return info.String()
+}
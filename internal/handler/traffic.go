@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+)
+
+// TrafficCallbackHandler shows the used traffic with the extra traffic packages,
+// or the payment methods of a package once one is chosen.
+func (h Handler) TrafficCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	if index, err := strconv.Atoi(callbackQuery["package"]); err == nil {
+		if index < 0 || index >= len(config.TrafficPackages()) {
+			slog.Error("Unknown traffic package", "package", index)
+			return
+		}
+		_, err = b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+			ChatID:    callback.Chat.ID,
+			MessageID: callback.ID,
+			ReplyMarkup: models.InlineKeyboardMarkup{
+				InlineKeyboard: h.buildTrafficPaymentKeyboard(ctx, langCode, index, payment.PreferredCurrency(customer)),
+			},
+		})
+		if err != nil {
+			slog.Error("Error sending traffic message", "error", err)
+		}
+		return
+	}
+
+	user, err := h.paymentService.PanelUser(ctx, customer)
+	if err != nil {
+		slog.Error("Error finding panel user", "error", err)
+		return
+	}
+
+	var text string
+	var keyboard [][]models.InlineKeyboardButton
+	if user == nil || payment.TrafficLimit(user) == 0 {
+		text = h.translation.GetText(langCode, "traffic_unlimited")
+	} else {
+		text = fmt.Sprintf(h.translation.GetText(langCode, "traffic_info"), payment.FormatTraffic(user.UsedTrafficBytes), payment.FormatTraffic(float64(payment.TrafficLimit(user))))
+		currency := payment.PreferredCurrency(customer)
+		for i, pkg := range config.TrafficPackages() {
+			label := h.trafficPackageLabel(langCode, pkg)
+			if price, ok := pkg.Price(currency); ok {
+				label = fmt.Sprintf("%s · %s", label, payment.FormatPrice(price, currency))
+			}
+			keyboard = append(keyboard, []models.InlineKeyboardButton{
+				{Text: label, CallbackData: fmt.Sprintf("%s?package=%d", CallbackTraffic, i)},
+			})
+		}
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackStart},
+	})
+
+	_, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		ParseMode: models.ParseModeHTML,
+		Text:      text,
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: keyboard,
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending traffic message", "error", err)
+	}
+}
+
+// buildTrafficPaymentKeyboard lists the payment methods a traffic package is priced for in the currency they would bill in.
+func (h Handler) buildTrafficPaymentKeyboard(ctx context.Context, langCode string, index int, currency string) [][]models.InlineKeyboardButton {
+	pkg := config.TrafficPackages()[index]
+
+	var keyboard [][]models.InlineKeyboardButton
+	for _, provider := range h.paymentService.Providers() {
+		if _, ok := provider.(payment.LinkProvider); ok {
+			continue
+		}
+		settlementCurrency := payment.SettlementCurrency(provider, currency)
+		price, ok := pkg.Price(settlementCurrency)
+		if !ok {
+			continue
+		}
+		text := h.translation.GetText(langCode, provider.ButtonKey())
+		if quoter, ok := provider.(payment.QuoteProvider); ok {
+			if quote := quoter.Quote(ctx, price, settlementCurrency); quote != "" {
+				text = fmt.Sprintf("%s (%s)", text, quote)
+			}
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{
+			{Text: text, CallbackData: fmt.Sprintf("%s?package=%d&invoiceType=%s", CallbackTrafficPay, index, provider.Type())},
+		})
+	}
+
+	keyboard = append(keyboard, []models.InlineKeyboardButton{
+		{Text: h.translation.GetText(langCode, "back_button"), CallbackData: CallbackTraffic},
+	})
+	return keyboard
+}
+
+func (h Handler) TrafficPaymentCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	callback := update.CallbackQuery.Message.Message
+	callbackQuery := parseCallbackData(update.CallbackQuery.Data)
+	langCode := update.CallbackQuery.From.LanguageCode
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	index, err := strconv.Atoi(callbackQuery["package"])
+	if err != nil {
+		slog.Error("Error parsing traffic package", "error", err)
+		return
+	}
+
+	invoiceType := database.InvoiceType(callbackQuery["invoiceType"])
+	if _, ok := h.paymentService.Provider(invoiceType); !ok {
+		slog.Error("Unknown invoice type", "invoiceType", invoiceType)
+		return
+	}
+
+	customer, err := h.customerRepository.FindByTelegramId(ctx, callback.Chat.ID)
+	if err != nil {
+		slog.Error("Error finding customer", "error", err)
+		return
+	}
+	if customer == nil {
+		return
+	}
+
+	backData := fmt.Sprintf("%s?package=%d", CallbackTraffic, index)
+	if invoiceType == database.InvoiceTypeYookasa && callbackQuery["s"] != "1" && needsReceiptContact(customer) {
+		h.askReceiptContact(ctx, b, callback, langCode, update.CallbackQuery.Data, backData)
+		return
+	}
+
+	ctxWithUsername := context.WithValue(ctx, "username", update.CallbackQuery.From.Username)
+	paymentURL, purchaseId, err := h.paymentService.CreateTrafficPurchase(ctxWithUsername, index, customer, invoiceType)
+	if errors.Is(err, database.ErrInsufficientBalance) {
+		h.sendInsufficientBalance(ctx, b, callback, langCode, backData)
+		return
+	}
+	if err != nil {
+		slog.Error("Error creating traffic payment", "error", err)
+		return
+	}
+
+	h.showInvoice(ctx, b, callback, langCode, invoiceType, paymentURL, purchaseId, backData)
+}
+
+// showInvoice replaces the keyboard of the message with the pay button of an invoice.
+// Invoices reviewed by an admin show the payment details instead, invoices settled right away,
+// e.g. balance payments, have no URL and the message is removed.
+func (h Handler) showInvoice(ctx context.Context, b *bot.Bot, callback *models.Message, langCode string, invoiceType database.InvoiceType, paymentURL string, purchaseId int64, backData string) {
+	if reviewed, ok := h.reviewProvider(invoiceType); ok {
+		h.showPaymentDetails(ctx, b, callback, langCode, reviewed.PaymentDetails(), purchaseId, backData)
+		return
+	}
+
+	if paymentURL == "" {
+		_, err := b.DeleteMessage(ctx, &bot.DeleteMessageParams{
+			ChatID:    callback.Chat.ID,
+			MessageID: callback.ID,
+		})
+		if err != nil {
+			slog.Error("Error deleting invoice message", "error", err)
+		}
+		return
+	}
+
+	message, err := b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+		ChatID:    callback.Chat.ID,
+		MessageID: callback.ID,
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{
+					{Text: h.translation.GetText(langCode, "pay_button"), URL: paymentURL},
+					{Text: h.translation.GetText(langCode, "back_button"), CallbackData: backData},
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error updating invoice message", "error", err)
+		return
+	}
+	h.cache.Set(purchaseId, message.ID)
+}
+
+func (h Handler) trafficPackageLabel(langCode string, pkg config.TrafficPackage) string {
+	if pkg.IsReset() {
+		return h.translation.GetText(langCode, "traffic_reset_package")
+	}
+	return fmt.Sprintf(h.translation.GetText(langCode, "traffic_package"), pkg.GB)
+}
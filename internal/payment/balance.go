@@ -0,0 +1,75 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+)
+
+// BalanceProvider pays purchases from the customer balance, the invoice is settled as soon as it is issued.
+type BalanceProvider struct {
+	balanceRepository *database.BalanceRepository
+}
+
+func NewBalanceProvider(balanceRepository *database.BalanceRepository) *BalanceProvider {
+	return &BalanceProvider{balanceRepository: balanceRepository}
+}
+
+func (p *BalanceProvider) Type() database.InvoiceType {
+	return database.InvoiceTypeBalance
+}
+
+func (p *BalanceProvider) ButtonKey() string {
+	return "balance_pay_button"
+}
+
+func (p *BalanceProvider) Currencies() []string {
+	return config.Currencies()
+}
+
+// CreateInvoice debits the balance, it fails with database.ErrInsufficientBalance when the balance is too low.
+func (p *BalanceProvider) CreateInvoice(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*Invoice, error) {
+	err := p.balanceRepository.Debit(ctx, &database.BalanceTransaction{
+		CustomerID: customer.ID,
+		Amount:     purchase.Amount,
+		Currency:   purchase.Currency,
+		Reason:     database.BalanceReasonPurchase,
+		PurchaseID: &purchase.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Invoice{Paid: true}, nil
+}
+
+// CheckStatus reports purchases whose debit went through as paid, so a failed activation is retried.
+func (p *BalanceProvider) CheckStatus(ctx context.Context, purchase *database.Purchase) (*InvoiceState, error) {
+	debit, err := p.balanceRepository.FindByPurchase(ctx, purchase.ID, database.BalanceReasonPurchase)
+	if err != nil {
+		return nil, err
+	}
+	if debit == nil {
+		return &InvoiceState{Status: InvoiceStatusCanceled}, nil
+	}
+	return &InvoiceState{Status: InvoiceStatusPaid}, nil
+}
+
+func (p *BalanceProvider) Cancel(ctx context.Context, purchase *database.Purchase) error {
+	return nil
+}
+
+// Refund returns the price of a purchase paid from the balance back to it.
+func (p *BalanceProvider) Refund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	_, err := p.balanceRepository.Credit(ctx, &database.BalanceTransaction{
+		CustomerID: customer.ID,
+		Amount:     purchase.Amount,
+		Currency:   purchase.Currency,
+		Reason:     database.BalanceReasonRefund,
+		PurchaseID: &purchase.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to credit refund: %w", err)
+	}
+	return nil
+}
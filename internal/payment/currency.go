@@ -0,0 +1,41 @@
+package payment
+
+import (
+	"fmt"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+)
+
+// PreferredCurrency is the currency a customer chose, or the one of their language.
+func PreferredCurrency(customer *database.Customer) string {
+	if customer.Currency != nil && config.IsCurrencyEnabled(*customer.Currency) {
+		return *customer.Currency
+	}
+	return config.CurrencyForLanguage(customer.Language)
+}
+
+// SettlementCurrency is the currency a provider bills a customer preferring the given currency in.
+func SettlementCurrency(provider Provider, preferred string) string {
+	currencies := provider.Currencies()
+	for _, currency := range currencies {
+		if currency == preferred {
+			return currency
+		}
+	}
+	return currencies[0]
+}
+
+func FormatPrice(amount int, currency string) string {
+	switch currency {
+	case CurrencyRUB:
+		return fmt.Sprintf("%d ₽", amount)
+	case CurrencyUSD:
+		return fmt.Sprintf("$%d", amount)
+	case CurrencyEUR:
+		return fmt.Sprintf("%d €", amount)
+	case CurrencyStars:
+		return fmt.Sprintf("%d ⭐", amount)
+	default:
+		return fmt.Sprintf("%d %s", amount, currency)
+	}
+}
@@ -0,0 +1,167 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	remapi "github.com/Jolymmiles/remnawave-api-go/api"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+	"math"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/utils"
+	"time"
+)
+
+// DeviceLimit is the HWID device limit of a panel user, 0 when the panel default applies.
+func DeviceLimit(user *remapi.UserDto) int {
+	limit, ok := user.HwidDeviceLimit.Get()
+	if !ok {
+		return 0
+	}
+	return limit
+}
+
+// DeviceUpgradePrice prices an upgrade to the tier for the rest of the period. The tier price is per 30 days,
+// the price of the current tier, if it is one of DEVICE_TIERS, is deducted. Tiers not above the current limit aren't offered.
+func DeviceUpgradePrice(tier config.DeviceTier, currentLimit int, currency string, expireAt time.Time) (int, bool) {
+	if currentLimit > 0 && tier.Devices <= currentLimit {
+		return 0, false
+	}
+	price, ok := tier.Price(currency)
+	if !ok {
+		return 0, false
+	}
+	for _, current := range config.DeviceTiers() {
+		if current.Devices == currentLimit {
+			currentPrice, _ := current.Price(currency)
+			price -= currentPrice
+		}
+	}
+
+	remaining := time.Until(expireAt)
+	if price <= 0 || remaining <= 0 {
+		return 0, false
+	}
+	days := math.Ceil(remaining.Hours() / 24)
+	return int(math.Max(1, math.Ceil(float64(price)*days/30))), true
+}
+
+// CreateDeviceUpgradePurchase issues an invoice raising the device limit to the tier at the given index of DEVICE_TIERS
+// until the end of the current period.
+func (s PaymentService) CreateDeviceUpgradePurchase(ctx context.Context, index int, customer *database.Customer, invoiceType database.InvoiceType) (url string, purchaseId int64, err error) {
+	tiers := config.DeviceTiers()
+	if index < 0 || index >= len(tiers) {
+		return "", 0, fmt.Errorf("unknown device tier %d", index)
+	}
+	tier := tiers[index]
+
+	if customer.ExpireAt == nil {
+		return "", 0, fmt.Errorf("customer %s has no subscription", utils.MaskHalfInt64(customer.ID))
+	}
+
+	provider, ok := s.providers.Get(invoiceType)
+	if !ok {
+		return "", 0, fmt.Errorf("unknown invoice type: %s", invoiceType)
+	}
+
+	user, err := s.panel(customer).FindUser(ctx, customer.TelegramID)
+	if err != nil {
+		return "", 0, err
+	}
+	if user == nil {
+		return "", 0, fmt.Errorf("customer %s has no panel user", utils.MaskHalfInt64(customer.ID))
+	}
+
+	currency := SettlementCurrency(provider, PreferredCurrency(customer))
+	price, ok := DeviceUpgradePrice(tier, DeviceLimit(user), currency, *customer.ExpireAt)
+	if !ok {
+		return "", 0, fmt.Errorf("device tier %d can't be bought in %s", index, currency)
+	}
+
+	purchase := &database.Purchase{
+		Amount:      float64(price),
+		Currency:    currency,
+		DeviceLimit: &tier.Devices,
+		Kind:        database.PurchaseKindDevices,
+	}
+	return s.createPurchase(ctx, provider, purchase, customer)
+}
+
+// processDeviceUpgrade raises the device limit of a claimed upgrade purchase, remembering the previous one for refunds.
+func (s PaymentService) processDeviceUpgrade(ctx context.Context, job *database.ProvisioningJob, purchase *database.Purchase, customer *database.Customer) error {
+	// Once applied, the current limit is the upgraded one and mustn't replace the remembered previous limit.
+	_, err := s.applyOnce(ctx, job, s.panel(customer), customer.TelegramID, func() (*remapi.UserDto, error) {
+		previousLimit, err := s.deviceLimitOf(ctx, customer)
+		if err != nil {
+			return nil, err
+		}
+		err = s.purchaseRepository.UpdateFields(ctx, purchase.ID, map[string]interface{}{
+			"previous_device_limit": previousLimit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s.panel(customer).SetDeviceLimit(ctx, customer.TelegramID, purchase.DeviceLimit)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.purchaseRepository.MarkAsPaid(ctx, purchase.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(customer.Language, "devices_upgraded"), *purchase.DeviceLimit),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: s.createConnectKeyboard(customer),
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending device upgrade notification", "error", err)
+	}
+
+	slog.Info("device limit upgraded", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// deviceLimitOf is the current device limit of the customer's panel user, nil for the panel default.
+func (s PaymentService) deviceLimitOf(ctx context.Context, customer *database.Customer) (*int, error) {
+	user, err := s.panel(customer).FindUser(ctx, customer.TelegramID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("customer %s has no panel user", utils.MaskHalfInt64(customer.ID))
+	}
+	if limit := DeviceLimit(user); limit > 0 {
+		return &limit, nil
+	}
+	return nil, nil
+}
+
+// applyDeviceUpgradeRefund restores the device limit the customer had before the upgrade.
+func (s PaymentService) applyDeviceUpgradeRefund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	_, err := s.panel(customer).SetDeviceLimit(ctx, customer.TelegramID, purchase.PreviousDeviceLimit)
+	if err != nil {
+		slog.Error("device upgrade refunded but limit was not restored", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", err)
+		return err
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      s.translation.GetText(customer.Language, "devices_refunded"),
+	})
+	if err != nil {
+		slog.Error("Error sending refund notification", "error", err)
+	}
+
+	slog.Info("device upgrade refunded", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
@@ -0,0 +1,231 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	remapi "github.com/Jolymmiles/remnawave-api-go/api"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+	"net/url"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/utils"
+)
+
+const GiftStartPrefix = "gift_"
+
+var (
+	ErrGiftNotFound = errors.New("gift not found")
+	ErrGiftRedeemed = errors.New("gift already redeemed")
+)
+
+// GiftLink is the deep link a gift is redeemed through.
+func GiftLink(code string) string {
+	return fmt.Sprintf("%s?start=%s%s", config.BotURL(), GiftStartPrefix, code)
+}
+
+// processGift issues the gift code of a claimed gift purchase, the buyer's own subscription is left as is.
+func (s PaymentService) processGift(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	code, err := newGiftCode()
+	if err != nil {
+		return err
+	}
+
+	gift, err := s.giftRepository.Create(ctx, &database.Gift{
+		Code:       code,
+		PurchaseID: purchase.ID,
+		BuyerID:    customer.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.purchaseRepository.MarkAsPaid(ctx, purchase.ID)
+	if err != nil {
+		return err
+	}
+
+	link := GiftLink(gift.Code)
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(customer.Language, "gift_purchased"), link),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: s.translation.GetText(customer.Language, "gift_share_button"), URL: "https://telegram.me/share/url?url=" + url.QueryEscape(link)}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending gift message", "error", err)
+	}
+
+	slog.Info("gift purchased", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// RedeemGift extends the recipient by the tariff of the gift purchase and notifies the buyer.
+// A gift is redeemed once, the code is released again if the subscription couldn't be extended.
+func (s PaymentService) RedeemGift(ctx context.Context, code string, recipient *database.Customer) error {
+	gift, err := s.giftRepository.Redeem(ctx, code, recipient.ID)
+	if err != nil {
+		return err
+	}
+	if gift == nil {
+		existing, err := s.giftRepository.FindByCode(ctx, code)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return ErrGiftNotFound
+		}
+		return ErrGiftRedeemed
+	}
+
+	user, err := s.applyGift(ctx, gift, recipient)
+	if err != nil {
+		if unredeemErr := s.giftRepository.Unredeem(ctx, gift.ID); unredeemErr != nil {
+			slog.Error("Error releasing gift", "gift_id", gift.ID, "error", unredeemErr)
+		}
+		return err
+	}
+
+	err = s.customerRepository.UpdateFields(ctx, recipient.ID, map[string]interface{}{
+		"subscription_link": user.SubscriptionUrl,
+		"expire_at":         user.ExpireAt,
+		"panel":             recipient.Panel,
+	})
+	if err != nil {
+		return err
+	}
+	recipient.SubscriptionLink = &user.SubscriptionUrl
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    recipient.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(recipient.Language, "gift_redeemed"), user.ExpireAt.Format("02.01.2006 15:04")),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: s.createConnectKeyboard(recipient),
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending gift message", "error", err)
+	}
+
+	buyer, err := s.customerRepository.FindById(ctx, gift.BuyerID)
+	if err != nil {
+		slog.Error("Error finding gift buyer", "error", err)
+	} else if buyer != nil && buyer.ID != recipient.ID {
+		_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    buyer.TelegramID,
+			ParseMode: models.ParseModeHTML,
+			Text:      s.translation.GetText(buyer.Language, "gift_redeemed_buyer"),
+		})
+		if err != nil {
+			slog.Error("Error sending gift message", "error", err)
+		}
+	}
+
+	slog.Info("gift redeemed", "gift_id", gift.ID, "purchase_id", utils.MaskHalfInt64(gift.PurchaseID), "customer_id", utils.MaskHalfInt64(recipient.ID))
+	return nil
+}
+
+// applyGift extends the recipient by the purchased tariff, gifts of purchases refunded meanwhile are not honoured.
+func (s PaymentService) applyGift(ctx context.Context, gift *database.Gift, recipient *database.Customer) (*remapi.UserDto, error) {
+	purchase, err := s.purchaseRepository.FindById(ctx, gift.PurchaseID)
+	if err != nil {
+		return nil, err
+	}
+	if purchase == nil || purchase.Status != database.PurchaseStatusPaid {
+		return nil, ErrGiftNotFound
+	}
+
+	plan, err := s.purchasePlan(ctx, purchase)
+	if err != nil {
+		return nil, err
+	}
+
+	panel, err := s.purchasePanel(ctx, purchase, recipient)
+	if err != nil {
+		return nil, err
+	}
+	recipient.Panel = &panel
+	return s.panels.Get(&panel).ApplyPlan(ctx, recipient.ID, recipient.TelegramID, plan)
+}
+
+// applyGiftRefund revokes the gift of a refunded purchase, or takes the period off the recipient once redeemed.
+func (s PaymentService) applyGiftRefund(ctx context.Context, purchase *database.Purchase, buyer *database.Customer) error {
+	revoked, err := s.giftRepository.DeleteUnredeemed(ctx, purchase.ID)
+	if err != nil {
+		return err
+	}
+
+	if !revoked {
+		gift, err := s.giftRepository.FindByPurchase(ctx, purchase.ID)
+		if err != nil {
+			return err
+		}
+		if gift != nil && gift.RecipientID != nil {
+			if err := s.shortenGiftRecipient(ctx, purchase, *gift.RecipientID); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    buyer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      s.translation.GetText(buyer.Language, "gift_refunded"),
+	})
+	if err != nil {
+		slog.Error("Error sending refund notification", "error", err)
+	}
+
+	slog.Info("gift refunded", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(buyer.ID))
+	return nil
+}
+
+func (s PaymentService) shortenGiftRecipient(ctx context.Context, purchase *database.Purchase, recipientID int64) error {
+	recipient, err := s.customerRepository.FindById(ctx, recipientID)
+	if err != nil {
+		return err
+	}
+	if recipient == nil {
+		return nil
+	}
+
+	user, err := s.panel(recipient).ShortenSubscription(ctx, recipient.TelegramID, purchase.Days())
+	if err != nil {
+		slog.Error("gift refunded but subscription was not shortened", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", err)
+		return err
+	}
+
+	err = s.customerRepository.UpdateFields(ctx, recipient.ID, map[string]interface{}{
+		"expire_at": user.ExpireAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    recipient.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(recipient.Language, "gift_revoked"), user.ExpireAt.Format("02.01.2006 15:04")),
+	})
+	if err != nil {
+		slog.Error("Error sending refund notification", "error", err)
+	}
+	return nil
+}
+
+func newGiftCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate gift code: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,145 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"html"
+	"log/slog"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/utils"
+)
+
+var ErrNotAwaitingReview = errors.New("purchase is not awaiting manual review")
+
+// ManualProvider is paid by a bank transfer to the details from config. The payer sends a photo of the receipt
+// to the bot and an admin approves or rejects the purchase.
+type ManualProvider struct{}
+
+func NewManualProvider() *ManualProvider {
+	return &ManualProvider{}
+}
+
+func (p *ManualProvider) Type() database.InvoiceType {
+	return database.InvoiceTypeManual
+}
+
+func (p *ManualProvider) ButtonKey() string {
+	return "manual_button"
+}
+
+func (p *ManualProvider) Currencies() []string {
+	return config.ManualPaymentCurrencies()
+}
+
+// CreateInvoice issues nothing, the payer is shown the payment details instead of a link.
+func (p *ManualProvider) CreateInvoice(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*Invoice, error) {
+	return &Invoice{}, nil
+}
+
+// CheckStatus is not supported, manual payments are confirmed by an admin.
+func (p *ManualProvider) CheckStatus(ctx context.Context, purchase *database.Purchase) (*InvoiceState, error) {
+	return nil, ErrStatusCheckUnsupported
+}
+
+func (p *ManualProvider) Cancel(ctx context.Context, purchase *database.Purchase) error {
+	return nil
+}
+
+// Refund only records the refund, the admin returns the transfer by hand.
+func (p *ManualProvider) Refund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	return nil
+}
+
+func (p *ManualProvider) PaymentDetails() string {
+	return config.ManualPaymentDetails()
+}
+
+// SubmitManualPayment marks a pending manual purchase of the customer as awaiting review.
+// The purchase no longer expires, it stays pending until an admin approves or rejects it.
+func (s PaymentService) SubmitManualPayment(ctx context.Context, purchaseId int64, customer *database.Customer) (*database.Purchase, error) {
+	purchase, err := s.manualPurchase(ctx, purchaseId)
+	if err != nil {
+		return nil, err
+	}
+	if purchase.CustomerID != customer.ID {
+		return nil, ErrNotAwaitingReview
+	}
+
+	err = s.purchaseRepository.UpdateFields(ctx, purchase.ID, map[string]interface{}{
+		"expire_at": nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purchase, nil
+}
+
+// ApproveManualPayment processes a manual purchase an admin confirmed the transfer of.
+func (s PaymentService) ApproveManualPayment(ctx context.Context, purchaseId int64) error {
+	if _, err := s.manualPurchase(ctx, purchaseId); err != nil {
+		return err
+	}
+	return s.ProcessPurchaseById(ctx, purchaseId)
+}
+
+// RejectManualPayment cancels a manual purchase and sends the reason to the customer.
+func (s PaymentService) RejectManualPayment(ctx context.Context, purchaseId int64, reason string) error {
+	purchase, err := s.manualPurchase(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+
+	canceled, err := s.purchaseRepository.MarkAsCanceled(ctx, purchase.ID)
+	if err != nil {
+		return err
+	}
+	if !canceled {
+		return ErrNotAwaitingReview
+	}
+
+	customer, err := s.customerRepository.FindById(ctx, purchase.CustomerID)
+	if err != nil {
+		return err
+	}
+	if customer == nil {
+		return fmt.Errorf("customer %s not found", utils.MaskHalfInt64(purchase.CustomerID))
+	}
+
+	if messageId, ok := s.cache.Get(purchase.ID); ok {
+		_, err = s.telegramBot.DeleteMessage(ctx, &bot.DeleteMessageParams{
+			ChatID:    customer.TelegramID,
+			MessageID: messageId,
+		})
+		if err != nil {
+			slog.Error("Error deleting message", "error", err)
+		}
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(customer.Language, "manual_rejected"), html.EscapeString(reason)),
+	})
+	if err != nil {
+		slog.Error("Error sending manual payment message", "error", err)
+	}
+
+	slog.Info("manual payment rejected", "purchase_id", utils.MaskHalfInt64(purchase.ID), "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// manualPurchase finds a manual purchase still waiting to be paid.
+func (s PaymentService) manualPurchase(ctx context.Context, purchaseId int64) (*database.Purchase, error) {
+	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil {
+		return nil, err
+	}
+	if purchase == nil || purchase.InvoiceType != database.InvoiceTypeManual || purchase.Status != database.PurchaseStatusPending {
+		return nil, ErrNotAwaitingReview
+	}
+	return purchase, nil
+}
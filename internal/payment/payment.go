@@ -2,64 +2,81 @@ package payment
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	remapi "github.com/Jolymmiles/remnawave-api-go/api"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
 	"log/slog"
 	"remnawave-tg-shop-bot/internal/cache"
 	"remnawave-tg-shop-bot/internal/config"
-	"remnawave-tg-shop-bot/internal/cryptopay"
 	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/promo"
 	"remnawave-tg-shop-bot/internal/remnawave"
 	"remnawave-tg-shop-bot/internal/translation"
-	"remnawave-tg-shop-bot/internal/yookasa"
 	"remnawave-tg-shop-bot/utils"
 	"time"
 )
 
 type PaymentService struct {
-	purchaseRepository *database.PurchaseRepository
-	remnawaveClient    *remnawave.Client
-	customerRepository *database.CustomerRepository
-	telegramBot        *bot.Bot
-	translation        *translation.Manager
-	cryptoPayClient    *cryptopay.Client
-	yookasaClient      *yookasa.Client
-	referralRepository *database.ReferralRepository
-	cache              *cache.Cache
+	purchaseRepository        *database.PurchaseRepository
+	panels                    *remnawave.Panels
+	customerRepository        *database.CustomerRepository
+	telegramBot               *bot.Bot
+	translation               *translation.Manager
+	providers                 *Registry
+	referralRepository        *database.ReferralRepository
+	cache                     *cache.Cache
+	tariffRepository          *database.TariffRepository
+	balanceRepository         *database.BalanceRepository
+	giftRepository            *database.GiftRepository
+	provisioningJobRepository *database.ProvisioningJobRepository
+	usage                     *cache.TypedCache[*Usage]
 }
 
 func NewPaymentService(
 	translation *translation.Manager,
 	purchaseRepository *database.PurchaseRepository,
-	remnawaveClient *remnawave.Client,
+	panels *remnawave.Panels,
 	customerRepository *database.CustomerRepository,
 	telegramBot *bot.Bot,
-	cryptoPayClient *cryptopay.Client,
-	yookasaClient *yookasa.Client,
+	providers *Registry,
 	referralRepository *database.ReferralRepository,
 	cache *cache.Cache,
+	tariffRepository *database.TariffRepository,
+	balanceRepository *database.BalanceRepository,
+	giftRepository *database.GiftRepository,
+	provisioningJobRepository *database.ProvisioningJobRepository,
 ) *PaymentService {
 	return &PaymentService{
-		purchaseRepository: purchaseRepository,
-		remnawaveClient:    remnawaveClient,
-		customerRepository: customerRepository,
-		telegramBot:        telegramBot,
-		translation:        translation,
-		cryptoPayClient:    cryptoPayClient,
-		yookasaClient:      yookasaClient,
-		referralRepository: referralRepository,
-		cache:              cache,
+		purchaseRepository:        purchaseRepository,
+		panels:                    panels,
+		customerRepository:        customerRepository,
+		telegramBot:               telegramBot,
+		translation:               translation,
+		providers:                 providers,
+		referralRepository:        referralRepository,
+		cache:                     cache,
+		tariffRepository:          tariffRepository,
+		balanceRepository:         balanceRepository,
+		giftRepository:            giftRepository,
+		provisioningJobRepository: provisioningJobRepository,
+		usage:                     newUsageCache(),
 	}
 }
 
+// ProcessPurchaseById fulfils a purchase whose payment was confirmed. The fulfilment is recorded as a provisioning job
+// before it is attempted, so a purchase that couldn't be fulfilled is retried by ProcessProvisioningJobs instead of being lost.
+// Once the purchase is claimed it returns nil even when the fulfilment fails: the payment is accepted and the failure is
+// left to the job, so webhooks acknowledge it rather than have the provider redeliver it.
 func (s PaymentService) ProcessPurchaseById(ctx context.Context, purchaseId int64) error {
 	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
 	if err != nil {
 		return err
 	}
 	if purchase == nil {
-		return fmt.Errorf("purchase with crypto invoice id %d not found", utils.MaskHalfInt64(purchaseId))
+		return fmt.Errorf("purchase with crypto invoice id %s not found", utils.MaskHalfInt64(purchaseId))
 	}
 
 	customer, err := s.customerRepository.FindById(ctx, purchase.CustomerID)
@@ -70,17 +87,87 @@ func (s PaymentService) ProcessPurchaseById(ctx context.Context, purchaseId int6
 		return fmt.Errorf("customer %s not found", utils.MaskHalfInt64(purchase.CustomerID))
 	}
 
+	claimed, err := s.purchaseRepository.Claim(ctx, purchase.ID)
+	if errors.Is(err, database.ErrPromoCodeExhausted) || errors.Is(err, database.ErrPromoCodeAlreadyUsed) {
+		s.alertUnclaimablePayment(ctx, purchase, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		slog.Info("purchase already processed, skipping", "purchase_id", utils.MaskHalfInt64(purchase.ID), "status", purchase.Status)
+		return nil
+	}
+
+	return s.fulfilClaimed(ctx, purchase, customer)
+}
+
+// alertUnclaimablePayment asks the admin to return the money of an expired promo purchase paid after its code was used up.
+// The purchase is left expired, the payment is acknowledged so the provider doesn't deliver it again.
+func (s PaymentService) alertUnclaimablePayment(ctx context.Context, purchase *database.Purchase, reason error) {
+	slog.Error("expired purchase paid after its promo code was used up", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "error", reason)
+	_, err := s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: config.GetAdminTelegramId(),
+		Text:   fmt.Sprintf("Purchase %d (%s) was paid after it expired, but its promo code was used up meanwhile (%v). The payment was not fulfilled, refund it at the provider.", purchase.ID, purchase.InvoiceType, reason),
+	})
+	if err != nil {
+		slog.Error("Error sending unclaimable payment alert", "error", err)
+	}
+}
+
+// fulfilClaimed records the provisioning job of a claimed purchase and makes its first attempt.
+func (s PaymentService) fulfilClaimed(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	job, err := s.provisioningJobRepository.Start(ctx, purchase.ID, time.Now().Add(provisioningLease))
+	if err != nil {
+		if releaseErr := s.purchaseRepository.ReleaseClaim(ctx, purchase.ID); releaseErr != nil {
+			slog.Error("Error releasing purchase claim", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", releaseErr)
+		}
+		return err
+	}
+
 	if messageId, b := s.cache.Get(purchase.ID); b {
 		_, err = s.telegramBot.DeleteMessage(ctx, &bot.DeleteMessageParams{
 			ChatID:    customer.TelegramID,
 			MessageID: messageId,
 		})
 		if err != nil {
-			slog.Error("Error deleting message", err)
+			slog.Error("Error deleting message", "error", err)
 		}
 	}
 
-	user, err := s.remnawaveClient.CreateOrUpdateUser(ctx, customer.ID, customer.TelegramID, config.TrafficLimit(), purchase.Month*30)
+	s.runProvisioningJob(ctx, job, purchase, customer)
+	return nil
+}
+
+// fulfilPurchase delivers what a claimed purchase paid for and marks it as paid. Top-ups and gifts are
+// idempotent per purchase, the panel changes are applied once per job.
+func (s PaymentService) fulfilPurchase(ctx context.Context, job *database.ProvisioningJob, purchase *database.Purchase, customer *database.Customer) error {
+	switch purchase.Kind {
+	case database.PurchaseKindBalance:
+		return s.processTopUp(ctx, purchase, customer)
+	case database.PurchaseKindGift:
+		return s.processGift(ctx, purchase, customer)
+	case database.PurchaseKindTraffic:
+		return s.processTraffic(ctx, job, purchase, customer)
+	case database.PurchaseKindDevices:
+		return s.processDeviceUpgrade(ctx, job, purchase, customer)
+	}
+
+	plan, err := s.purchasePlan(ctx, purchase)
+	if err != nil {
+		return err
+	}
+
+	panel, err := s.purchasePanel(ctx, purchase, customer)
+	if err != nil {
+		return err
+	}
+
+	client := s.panels.Get(&panel)
+	user, err := s.applyOnce(ctx, job, client, customer.TelegramID, func() (*remapi.UserDto, error) {
+		return client.ApplyPlan(ctx, customer.ID, customer.TelegramID, plan)
+	})
 	if err != nil {
 		return err
 	}
@@ -93,6 +180,7 @@ func (s PaymentService) ProcessPurchaseById(ctx context.Context, purchaseId int6
 	customerFilesToUpdate := map[string]interface{}{
 		"subscription_link": user.SubscriptionUrl,
 		"expire_at":         user.ExpireAt,
+		"panel":             panel,
 	}
 
 	err = s.customerRepository.UpdateFields(ctx, customer.ID, customerFilesToUpdate)
@@ -108,9 +196,18 @@ func (s PaymentService) ProcessPurchaseById(ctx context.Context, purchaseId int6
 		},
 	})
 	if err != nil {
-		return err
+		slog.Error("Error sending activation message", "error", err)
 	}
 
+	if err := s.grantReferralBonus(purchase, customer); err != nil {
+		slog.Error("Error granting referral bonus", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", err)
+	}
+	slog.Info("purchase processed", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// grantReferralBonus rewards the referrer of the customer on their first purchase.
+func (s PaymentService) grantReferralBonus(purchase *database.Purchase, customer *database.Customer) error {
 	ctxReferee := context.Background()
 	referee, err := s.referralRepository.FindByReferee(ctxReferee, customer.TelegramID)
 	if referee == nil {
@@ -126,7 +223,7 @@ func (s PaymentService) ProcessPurchaseById(ctx context.Context, purchaseId int6
 	if err != nil {
 		return err
 	}
-	refereeUser, err := s.remnawaveClient.CreateOrUpdateUser(ctxReferee, refereeCustomer.ID, refereeCustomer.TelegramID, config.TrafficLimit(), config.GetReferralDays())
+	refereeUser, err := s.panel(refereeCustomer).CreateOrUpdateUser(ctxReferee, refereeCustomer.ID, refereeCustomer.TelegramID, config.TrafficLimit(), config.GetReferralDays())
 	if err != nil {
 		return err
 	}
@@ -138,6 +235,18 @@ func (s PaymentService) ProcessPurchaseById(ctx context.Context, purchaseId int6
 	if err != nil {
 		return err
 	}
+	if config.ReferralBalanceBonus() > 0 {
+		_, err = s.balanceRepository.Credit(ctxReferee, &database.BalanceTransaction{
+			CustomerID: refereeCustomer.ID,
+			Amount:     float64(config.ReferralBalanceBonus()),
+			Currency:   config.Currencies()[0],
+			Reason:     database.BalanceReasonReferral,
+			PurchaseID: &purchase.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
 	err = s.referralRepository.MarkBonusGranted(ctxReferee, referee.ID)
 	if err != nil {
 		return err
@@ -151,11 +260,39 @@ func (s PaymentService) ProcessPurchaseById(ctx context.Context, purchaseId int6
 			InlineKeyboard: s.createConnectKeyboard(refereeCustomer),
 		},
 	})
-	slog.Info("purchase processed", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
-
+	if err != nil {
+		slog.Error("Error sending referral bonus message", "error", err)
+	}
 	return nil
 }
 
+// CustomerPanel is the name of the panel the customer lives on, or is provisioned on next.
+func (s PaymentService) CustomerPanel(customer *database.Customer) string {
+	return s.panels.Resolve(customer.Panel)
+}
+
+func (s PaymentService) panel(customer *database.Customer) remnawave.Panel {
+	return s.panels.Get(customer.Panel)
+}
+
+// purchasePanel picks the panel a subscription purchase is applied on. Customers without an active subscription
+// move to the panel the tariff of the purchase is bound to, everyone else stays on their own panel.
+func (s PaymentService) purchasePanel(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (string, error) {
+	current := s.CustomerPanel(customer)
+	if purchase.TariffID == nil || customer.HasActiveSubscription() {
+		return current, nil
+	}
+
+	tariff, err := s.tariffRepository.FindById(ctx, *purchase.TariffID)
+	if err != nil {
+		return "", err
+	}
+	if tariff == nil || tariff.Panel == nil {
+		return current, nil
+	}
+	return s.panels.Resolve(tariff.Panel), nil
+}
+
 func (s PaymentService) createConnectKeyboard(customer *database.Customer) [][]models.InlineKeyboardButton {
 	var inlineCustomerKeyboard [][]models.InlineKeyboardButton
 
@@ -177,138 +314,411 @@ func (s PaymentService) createConnectKeyboard(customer *database.Customer) [][]m
 	return inlineCustomerKeyboard
 }
 
-func (s PaymentService) CreatePurchase(ctx context.Context, amount int, months int, customer *database.Customer, invoiceType database.InvoiceType) (url string, purchaseId int64, err error) {
-	switch invoiceType {
-	case database.InvoiceTypeCrypto:
-		return s.createCryptoInvoice(ctx, amount, months, customer)
-	case database.InvoiceTypeYookasa:
-		return s.createYookasaInvoice(ctx, amount, months, customer)
-	case database.InvoiceTypeTelegram:
-		return s.createTelegramInvoice(ctx, amount, months, customer)
-	case database.InvoiceTypeTribute:
-		return s.createTributeInvoice(ctx, amount, months, customer)
-	default:
+func (s PaymentService) Providers() []Provider {
+	return s.providers.All()
+}
+
+func (s PaymentService) Provider(invoiceType database.InvoiceType) (Provider, bool) {
+	return s.providers.Get(invoiceType)
+}
+
+func (s PaymentService) CreatePurchase(ctx context.Context, amount int, currency string, months int, customer *database.Customer, invoiceType database.InvoiceType) (url string, purchaseId int64, err error) {
+	provider, ok := s.providers.Get(invoiceType)
+	if !ok {
 		return "", 0, fmt.Errorf("unknown invoice type: %s", invoiceType)
 	}
+
+	purchase := &database.Purchase{
+		Amount:   float64(amount),
+		Currency: currency,
+		Month:    months,
+	}
+	return s.createPurchase(ctx, provider, purchase, customer)
 }
 
-func (s PaymentService) createCryptoInvoice(ctx context.Context, amount int, months int, customer *database.Customer) (url string, purchaseId int64, err error) {
-	purchaseId, err = s.purchaseRepository.Create(ctx, &database.Purchase{
-		InvoiceType: database.InvoiceTypeCrypto,
-		Status:      database.PurchaseStatusNew,
-		Amount:      float64(amount),
-		Currency:    "RUB",
-		CustomerID:  customer.ID,
-		Month:       months,
-	})
-	if err != nil {
-		slog.Error("Error creating purchase", err)
-		return "", 0, err
+// CreateTariffPurchase issues an invoice for a tariff in the customer's currency, or the provider's
+// default one if it can't bill in it. The promo code, if any, must already be validated for the customer.
+// Gift purchases issue a gift code once paid instead of extending the customer. autoRenew is the customer's consent
+// to save the payment method and renew the tariff with it, see CanAutoRenew.
+func (s PaymentService) CreateTariffPurchase(ctx context.Context, tariff *database.Tariff, customer *database.Customer, invoiceType database.InvoiceType, promoCode *database.PromoCode, gift bool, autoRenew bool) (url string, purchaseId int64, err error) {
+	provider, ok := s.providers.Get(invoiceType)
+	if !ok {
+		return "", 0, fmt.Errorf("unknown invoice type: %s", invoiceType)
 	}
 
-	invoice, err := s.cryptoPayClient.CreateInvoice(&cryptopay.InvoiceRequest{
-		CurrencyType:   "fiat",
-		Fiat:           "RUB",
-		Amount:         fmt.Sprintf("%d", amount),
-		AcceptedAssets: "USDT",
-		Payload:        fmt.Sprintf("purchaseId=%d&username=%s", purchaseId, ctx.Value("username")),
-		Description:    fmt.Sprintf("Subscription on %d month", months),
-		PaidBtnName:    "callback",
-		PaidBtnUrl:     config.BotURL(),
-	})
-	if err != nil {
-		slog.Error("Error creating invoice", err)
-		return "", 0, err
+	if autoRenew && (gift || !CanAutoRenew(provider)) {
+		return "", 0, fmt.Errorf("%s purchases can't renew automatically", invoiceType)
 	}
 
-	updates := map[string]interface{}{
-		"crypto_invoice_url": invoice.BotInvoiceUrl,
-		"crypto_invoice_id":  invoice.InvoiceID,
-		"status":             database.PurchaseStatusPending,
+	if !gift && !tariff.IsAvailableOn(s.CustomerPanel(customer)) {
+		return "", 0, fmt.Errorf("tariff %d is not sold on panel %s", tariff.ID, s.CustomerPanel(customer))
 	}
 
-	err = s.purchaseRepository.UpdateFields(ctx, purchaseId, updates)
+	currency := SettlementCurrency(provider, PreferredCurrency(customer))
+	price, ok := tariff.Price(currency)
+	if !ok {
+		return "", 0, fmt.Errorf("tariff %d has no %s price", tariff.ID, currency)
+	}
+
+	trafficLimit := tariff.TrafficLimitBytes()
+	purchase := &database.Purchase{
+		Amount:       float64(price),
+		Currency:     currency,
+		Month:        tariff.Months(),
+		TariffID:     &tariff.ID,
+		DurationDays: &tariff.DurationDays,
+		TrafficLimit: &trafficLimit,
+		AutoRenew:    autoRenew,
+	}
+	if gift {
+		purchase.Kind = database.PurchaseKindGift
+	}
+
+	if promoCode != nil {
+		rubPrice, _ := tariff.Price(CurrencyRUB)
+		discounted := promo.Apply(promoCode, price, rubPrice)
+		discount := float64(price - discounted)
+		purchase.Amount = float64(discounted)
+		purchase.PromoCodeID = &promoCode.ID
+		purchase.Discount = &discount
+
+		if err := s.releasePromoReservations(ctx, customer.ID, promoCode.ID); err != nil {
+			return "", 0, err
+		}
+	}
+
+	return s.createPurchase(ctx, provider, purchase, customer)
+}
+
+// releasePromoReservations expires the unpaid purchases a customer made earlier with the promo code and revokes
+// their invoices, so switching payment methods doesn't leave several payable invoices with one use of the code.
+func (s PaymentService) releasePromoReservations(ctx context.Context, customerID int64, promoCodeID int64) error {
+	purchases, err := s.purchaseRepository.FindUnpaidWithPromo(ctx, customerID, promoCodeID)
 	if err != nil {
-		slog.Error("Error updating purchase", err)
-		return "", 0, err
+		return err
 	}
 
-	return invoice.BotInvoiceUrl, purchaseId, nil
+	for _, purchase := range *purchases {
+		expired, err := s.purchaseRepository.MarkAsExpired(ctx, purchase.ID)
+		if err != nil {
+			return err
+		}
+		if !expired {
+			continue
+		}
+
+		if provider, ok := s.providers.Get(purchase.InvoiceType); ok {
+			if err := provider.Cancel(ctx, &purchase); err != nil {
+				slog.Error("Error canceling replaced invoice", "type", purchase.InvoiceType, "purchaseId", purchase.ID, "error", err)
+			}
+		}
+		s.disablePaymentMessage(ctx, &purchase)
+		slog.Info("purchase replaced", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType)
+	}
+	return nil
 }
 
-func (s PaymentService) createYookasaInvoice(ctx context.Context, amount int, months int, customer *database.Customer) (url string, purchaseId int64, err error) {
-	purchaseId, err = s.purchaseRepository.Create(ctx, &database.Purchase{
-		InvoiceType: database.InvoiceTypeYookasa,
-		Status:      database.PurchaseStatusNew,
-		Amount:      float64(amount),
-		Currency:    "RUB",
-		CustomerID:  customer.ID,
-		Month:       months,
-	})
+func (s PaymentService) createPurchase(ctx context.Context, provider Provider, purchase *database.Purchase, customer *database.Customer) (url string, purchaseId int64, err error) {
+	invoiceType := provider.Type()
+	expireAt := time.Now().Add(config.InvoiceTTL())
+	purchase.InvoiceType = invoiceType
+	purchase.Status = database.PurchaseStatusNew
+	purchase.CustomerID = customer.ID
+	purchase.ExpireAt = &expireAt
+
+	purchaseId, err = s.purchaseRepository.CreateWithPromo(ctx, purchase)
 	if err != nil {
-		slog.Error("Error creating purchase", err)
+		slog.Error("Error creating purchase", "error", err)
 		return "", 0, err
 	}
+	purchase.ID = purchaseId
 
-	invoice, err := s.yookasaClient.CreateInvoice(ctx, amount, months, customer.ID, purchaseId)
+	invoice, err := provider.CreateInvoice(ctx, purchase, customer)
 	if err != nil {
-		slog.Error("Error creating invoice", err)
+		slog.Error("Error creating invoice", "type", invoiceType, "error", err)
 		return "", 0, err
 	}
 
 	updates := map[string]interface{}{
-		"yookasa_url": invoice.Confirmation.ConfirmationURL,
-		"yookasa_id":  invoice.ID,
-		"status":      database.PurchaseStatusPending,
+		"status": database.PurchaseStatusPending,
+	}
+	for field, value := range invoice.Fields {
+		updates[field] = value
 	}
 
 	err = s.purchaseRepository.UpdateFields(ctx, purchaseId, updates)
 	if err != nil {
-		slog.Error("Error updating purchase", err)
+		slog.Error("Error updating purchase", "error", err)
+	}
+
+	// the money was already taken, the purchase is fulfilled even if its invoice fields weren't saved
+	if invoice.Paid {
+		return "", purchaseId, s.ProcessPurchaseById(ctx, purchaseId)
+	}
+	if err != nil {
 		return "", 0, err
 	}
 
-	return invoice.Confirmation.ConfirmationURL, purchaseId, nil
+	return invoice.URL, purchaseId, nil
+}
+
+// CreateTopUpPurchase issues an invoice crediting the amount to the customer balance once paid.
+// Balances are kept in the customer currencies, providers billing in other ones can't top up.
+func (s PaymentService) CreateTopUpPurchase(ctx context.Context, amount int, customer *database.Customer, invoiceType database.InvoiceType) (url string, purchaseId int64, err error) {
+	provider, ok := s.providers.Get(invoiceType)
+	if !ok || invoiceType == database.InvoiceTypeBalance {
+		return "", 0, fmt.Errorf("invoice type %s can't top up the balance", invoiceType)
+	}
+
+	currency := SettlementCurrency(provider, PreferredCurrency(customer))
+	if !config.IsCurrencyEnabled(currency) {
+		return "", 0, fmt.Errorf("balance can't be topped up in %s", currency)
+	}
+
+	purchase := &database.Purchase{
+		Amount:   float64(amount),
+		Currency: currency,
+		Kind:     database.PurchaseKindBalance,
+	}
+	return s.createPurchase(ctx, provider, purchase, customer)
+}
+
+// CanAutoRenew reports whether a provider can save the payment method of a purchase to renew it, if auto-payments are enabled.
+func CanAutoRenew(provider Provider) bool {
+	_, ok := provider.(RecurringProvider)
+	return ok && config.IsAutoPaymentEnabled()
+}
+
+// CanTopUp reports whether a provider bills a customer in a currency their balance can be kept in.
+func (s PaymentService) CanTopUp(provider Provider, customer *database.Customer) bool {
+	if provider.Type() == database.InvoiceTypeBalance {
+		return false
+	}
+	if _, ok := provider.(LinkProvider); ok {
+		return false
+	}
+	if _, ok := provider.(ReviewProvider); ok {
+		return false
+	}
+	return config.IsCurrencyEnabled(SettlementCurrency(provider, PreferredCurrency(customer)))
+}
+
+func (s PaymentService) Balances(ctx context.Context, customerId int64) (map[string]float64, error) {
+	return s.balanceRepository.Balances(ctx, customerId)
 }
 
-func (s PaymentService) createTelegramInvoice(ctx context.Context, amount int, months int, customer *database.Customer) (url string, purchaseId int64, err error) {
-	purchaseId, err = s.purchaseRepository.Create(ctx, &database.Purchase{
-		InvoiceType: database.InvoiceTypeTelegram,
-		Status:      database.PurchaseStatusNew,
-		Amount:      float64(amount),
-		Currency:    "STARS",
-		CustomerID:  customer.ID,
-		Month:       months,
+// processTopUp credits a claimed top-up purchase. The credit is idempotent per purchase,
+// so a purchase whose status update failed can be processed again.
+func (s PaymentService) processTopUp(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	_, err := s.balanceRepository.Credit(ctx, &database.BalanceTransaction{
+		CustomerID: customer.ID,
+		Amount:     purchase.Amount,
+		Currency:   purchase.Currency,
+		Reason:     database.BalanceReasonTopUp,
+		PurchaseID: &purchase.ID,
 	})
 	if err != nil {
-		slog.Error("Error creating purchase", err)
-		return "", 0, nil
+		return err
+	}
+
+	err = s.purchaseRepository.MarkAsPaid(ctx, purchase.ID)
+	if err != nil {
+		return err
 	}
 
-	invoiceUrl, err := s.telegramBot.CreateInvoiceLink(ctx, &bot.CreateInvoiceLinkParams{
-		Title:    s.translation.GetText(customer.Language, "invoice_title"),
-		Currency: "XTR",
-		Prices: []models.LabeledPrice{
-			{
-				Label:  s.translation.GetText(customer.Language, "invoice_label"),
-				Amount: amount,
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(customer.Language, "balance_topped_up"), FormatPrice(int(purchase.Amount), purchase.Currency)),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: s.translation.GetText(customer.Language, "buy_button"), CallbackData: "buy"}},
+				{{Text: s.translation.GetText(customer.Language, "back_button"), CallbackData: "start"}},
 			},
 		},
-		Description: s.translation.GetText(customer.Language, "invoice_description"),
-		Payload:     fmt.Sprintf("%d&%s", purchaseId, ctx.Value("username")),
 	})
+	if err != nil {
+		slog.Error("Error sending top up notification", "error", err)
+	}
 
-	updates := map[string]interface{}{
-		"status": database.PurchaseStatusPending,
+	slog.Info("balance topped up", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// purchasePlan is what a paid purchase grants on the panel. Purchases of a tariff take its
+// traffic strategy, device limit, inbounds and squads, the duration and traffic are fixed at checkout.
+func (s PaymentService) purchasePlan(ctx context.Context, purchase *database.Purchase) (remnawave.Plan, error) {
+	plan := remnawave.Plan{
+		Days:         purchase.Days(),
+		TrafficLimit: config.TrafficLimit(),
+	}
+	if purchase.TrafficLimit != nil {
+		plan.TrafficLimit = *purchase.TrafficLimit
 	}
 
-	err = s.purchaseRepository.UpdateFields(ctx, purchaseId, updates)
+	if purchase.TariffID == nil {
+		return plan, nil
+	}
+
+	tariff, err := s.tariffRepository.FindById(ctx, *purchase.TariffID)
 	if err != nil {
-		slog.Error("Error updating purchase", err)
-		return "", 0, err
+		return plan, err
+	}
+	if tariff == nil {
+		slog.Warn("tariff of purchase not found, applying defaults", "purchase_id", utils.MaskHalfInt64(purchase.ID), "tariff_id", *purchase.TariffID)
+		return plan, nil
+	}
+
+	plan.TrafficStrategy = tariff.TrafficStrategy
+	plan.DeviceLimit = tariff.DeviceLimit
+	if tariff.Inbounds != nil {
+		plan.Inbounds = make([]uuid.UUID, 0, len(tariff.Inbounds))
+		for _, inbound := range tariff.Inbounds {
+			id, err := uuid.Parse(inbound)
+			if err != nil {
+				return plan, fmt.Errorf("tariff %d has invalid inbound %q: %w", tariff.ID, inbound, err)
+			}
+			plan.Inbounds = append(plan.Inbounds, id)
+		}
+	}
+	plan.Squads = tariff.Squads
+	return plan, nil
+}
+
+func (s PaymentService) CheckPendingPurchases(ctx context.Context, invoiceType database.InvoiceType) {
+	provider, ok := s.providers.Get(invoiceType)
+	if !ok {
+		return
+	}
+
+	pendingPurchases, err := s.purchaseRepository.FindByInvoiceTypeAndStatus(ctx, invoiceType, database.PurchaseStatusPending)
+	if err != nil {
+		slog.Error("Error finding pending purchases", "type", invoiceType, "error", err)
+		return
+	}
+
+	// providers checking many invoices at once are asked once per tick instead of once per purchase
+	var states map[int64]*InvoiceState
+	if batch, ok := provider.(BatchStatusProvider); ok && len(*pendingPurchases) > 0 {
+		states, err = batch.CheckStatuses(ctx, *pendingPurchases)
+		if err != nil {
+			slog.Error("Error checking invoices", "type", invoiceType, "error", err)
+			return
+		}
+	}
+
+	for _, purchase := range *pendingPurchases {
+		var state *InvoiceState
+		if states != nil {
+			if state = states[purchase.ID]; state == nil {
+				continue
+			}
+		} else {
+			state, err = provider.CheckStatus(ctx, &purchase)
+			if err != nil {
+				slog.Error("Error checking invoice", "type", invoiceType, "purchaseId", purchase.ID, "error", err)
+				continue
+			}
+		}
+
+		switch state.Status {
+		case InvoiceStatusCanceled:
+			if err := s.CancelPayment(purchase.ID); err != nil {
+				slog.Error("Error canceling purchase", "type", invoiceType, "purchaseId", purchase.ID, "error", err)
+			}
+		case InvoiceStatusPaid:
+			if err := s.SavePaymentDetails(ctx, purchase.ID, state.Fields); err != nil {
+				slog.Error("Error saving payment details", "type", invoiceType, "purchaseId", purchase.ID, "error", err)
+			}
+			if state.PaymentMethodID != nil {
+				if err := s.SavePaymentMethod(ctx, purchase.ID, *state.PaymentMethodID); err != nil {
+					slog.Error("Error saving payment method", "type", invoiceType, "purchaseId", purchase.ID, "error", err)
+				}
+			}
+			ctxWithUsername := context.WithValue(ctx, "username", state.Username)
+			if err := s.ProcessPurchaseById(ctxWithUsername, purchase.ID); err != nil {
+				slog.Error("Error processing invoice", "type", invoiceType, "purchaseId", purchase.ID, "error", err)
+			} else {
+				slog.Info("Invoice processed", "type", invoiceType, "purchaseId", purchase.ID)
+			}
+		}
+	}
+}
+
+// ExpirePurchases moves unpaid purchases past their invoice lifetime to expired, revokes the provider invoice
+// and disables the pay button of the payment message. Invoices paid in the meantime are processed instead.
+func (s PaymentService) ExpirePurchases(ctx context.Context) {
+	purchases, err := s.purchaseRepository.FindExpired(ctx, time.Now())
+	if err != nil {
+		slog.Error("Error finding expired purchases", "error", err)
+		return
+	}
+
+	for _, purchase := range *purchases {
+		provider, ok := s.providers.Get(purchase.InvoiceType)
+		if ok && purchase.Status == database.PurchaseStatusPending {
+			state, err := provider.CheckStatus(ctx, &purchase)
+			if err != nil && !errors.Is(err, ErrStatusCheckUnsupported) {
+				slog.Error("Error checking invoice", "type", purchase.InvoiceType, "purchaseId", purchase.ID, "error", err)
+				continue
+			}
+			if state != nil && state.Status == InvoiceStatusPaid {
+				if err := s.SavePaymentDetails(ctx, purchase.ID, state.Fields); err != nil {
+					slog.Error("Error saving payment details", "type", purchase.InvoiceType, "purchaseId", purchase.ID, "error", err)
+				}
+				ctxWithUsername := context.WithValue(ctx, "username", state.Username)
+				if err := s.ProcessPurchaseById(ctxWithUsername, purchase.ID); err != nil {
+					slog.Error("Error processing invoice", "type", purchase.InvoiceType, "purchaseId", purchase.ID, "error", err)
+				}
+				continue
+			}
+		}
+
+		expired, err := s.purchaseRepository.MarkAsExpired(ctx, purchase.ID)
+		if err != nil {
+			slog.Error("Error expiring purchase", "purchaseId", purchase.ID, "error", err)
+			continue
+		}
+		if !expired {
+			continue
+		}
+
+		if ok {
+			if err := provider.Cancel(ctx, &purchase); err != nil {
+				slog.Error("Error canceling expired invoice", "type", purchase.InvoiceType, "purchaseId", purchase.ID, "error", err)
+			}
+		}
+
+		s.disablePaymentMessage(ctx, &purchase)
+		slog.Info("purchase expired", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType)
+	}
+}
+
+func (s PaymentService) disablePaymentMessage(ctx context.Context, purchase *database.Purchase) {
+	messageId, found := s.cache.Get(purchase.ID)
+	if !found {
+		return
+	}
+
+	customer, err := s.customerRepository.FindById(ctx, purchase.CustomerID)
+	if err != nil || customer == nil {
+		slog.Error("Error finding customer", "customer_id", utils.MaskHalfInt64(purchase.CustomerID), "error", err)
+		return
 	}
 
-	return invoiceUrl, purchaseId, nil
+	_, err = s.telegramBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    customer.TelegramID,
+		MessageID: messageId,
+		Text:      s.translation.GetText(customer.Language, "invoice_expired"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: s.translation.GetText(customer.Language, "buy_button"), CallbackData: "buy"}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error editing expired payment message", "error", err)
+	}
 }
 
 func (s PaymentService) ActivateTrial(ctx context.Context, telegramId int64) (string, error) {
@@ -317,21 +727,28 @@ func (s PaymentService) ActivateTrial(ctx context.Context, telegramId int64) (st
 	}
 	customer, err := s.customerRepository.FindByTelegramId(ctx, telegramId)
 	if err != nil {
-		slog.Error("Error finding customer", err)
+		slog.Error("Error finding customer", "error", err)
 		return "", err
 	}
 	if customer == nil {
 		return "", fmt.Errorf("customer %d not found", telegramId)
 	}
-	user, err := s.remnawaveClient.CreateOrUpdateUser(ctx, customer.ID, telegramId, config.TrialTrafficLimit(), config.TrialDays())
+	plan := remnawave.Plan{Days: config.TrialDays(), TrafficLimit: config.TrialTrafficLimit()}
+	if deviceLimit := config.TrialDeviceLimit(); deviceLimit > 0 {
+		plan.DeviceLimit = &deviceLimit
+	}
+	plan.Squads = config.TrialSquads()
+	panel := s.CustomerPanel(customer)
+	user, err := s.panels.Get(&panel).ApplyPlan(ctx, customer.ID, telegramId, plan)
 	if err != nil {
-		slog.Error("Error creating user", err)
+		slog.Error("Error creating user", "error", err)
 		return "", err
 	}
 
 	customerFilesToUpdate := map[string]interface{}{
 		"subscription_link": user.GetSubscriptionUrl(),
 		"expire_at":         user.GetExpireAt(),
+		"panel":             panel,
 	}
 
 	err = s.customerRepository.UpdateFields(ctx, customer.ID, customerFilesToUpdate)
@@ -343,6 +760,8 @@ func (s PaymentService) ActivateTrial(ctx context.Context, telegramId int64) (st
 
 }
 
+// CancelPayment cancels an unpaid purchase the provider reported canceled. The status is changed conditionally,
+// a cancellation racing with the purchase being claimed, paid or refunded is logged and ignored.
 func (s PaymentService) CancelPayment(purchaseId int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -351,34 +770,573 @@ func (s PaymentService) CancelPayment(purchaseId int64) error {
 		return err
 	}
 	if purchase == nil {
-		return fmt.Errorf("purchase with crypto invoice id %d not found", utils.MaskHalfInt64(purchaseId))
+		return fmt.Errorf("purchase with crypto invoice id %s not found", utils.MaskHalfInt64(purchaseId))
+	}
+
+	canceled, err := s.purchaseRepository.MarkAsCanceled(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+	if !canceled {
+		slog.Warn("purchase is no longer unpaid, ignoring cancellation", "purchase_id", utils.MaskHalfInt64(purchaseId))
+		return nil
+	}
+
+	if purchase.ParentPurchaseID != nil {
+		s.notifyAutoRenewFailed(ctx, purchase.CustomerID)
+	}
+
+	return nil
+}
+
+// RefundPurchase returns the money of a paid purchase through its provider and rolls back the subscription.
+func (s PaymentService) RefundPurchase(ctx context.Context, purchaseId int64) error {
+	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+	if purchase == nil {
+		return fmt.Errorf("purchase %d not found", purchaseId)
+	}
+	if purchase.Status != database.PurchaseStatusPaid {
+		return fmt.Errorf("purchase %d is %s, only paid purchases can be refunded", purchaseId, purchase.Status)
 	}
 
-	purchaseFieldsToUpdate := map[string]interface{}{
-		"status": database.PurchaseStatusCancel,
+	provider, ok := s.providers.Get(purchase.InvoiceType)
+	if !ok {
+		return fmt.Errorf("unknown invoice type: %s", purchase.InvoiceType)
+	}
+	refunder, ok := provider.(RefundProvider)
+	if !ok {
+		return fmt.Errorf("refunds are not supported for %s payments", purchase.InvoiceType)
 	}
 
-	err = s.purchaseRepository.UpdateFields(ctx, purchaseId, purchaseFieldsToUpdate)
+	customer, err := s.customerRepository.FindById(ctx, purchase.CustomerID)
+	if err != nil {
+		return err
+	}
+	if customer == nil {
+		return fmt.Errorf("customer %s not found", utils.MaskHalfInt64(purchase.CustomerID))
+	}
+
+	if purchase.Kind == database.PurchaseKindBalance {
+		balances, err := s.balanceRepository.Balances(ctx, customer.ID)
+		if err != nil {
+			return err
+		}
+		if balances[purchase.Currency] < purchase.Amount {
+			return fmt.Errorf("top up %d is already spent: %w", purchaseId, database.ErrInsufficientBalance)
+		}
+	}
+
+	err = refunder.Refund(ctx, purchase, customer)
+	if err != nil {
+		return err
+	}
+
+	return s.ApplyRefund(ctx, purchaseId)
+}
+
+// RefundToBalance credits the price of a paid subscription purchase to the customer balance instead of
+// returning it through the provider, then rolls back the subscription.
+func (s PaymentService) RefundToBalance(ctx context.Context, purchaseId int64) error {
+	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+	if purchase == nil {
+		return fmt.Errorf("purchase %d not found", purchaseId)
+	}
+	if purchase.Status != database.PurchaseStatusPaid {
+		return fmt.Errorf("purchase %d is %s, only paid purchases can be refunded", purchaseId, purchase.Status)
+	}
+	if purchase.Kind == database.PurchaseKindBalance {
+		return fmt.Errorf("purchase %d is a balance top up", purchaseId)
+	}
+	if !config.IsCurrencyEnabled(purchase.Currency) {
+		return fmt.Errorf("balance can't be kept in %s", purchase.Currency)
+	}
+
+	_, err = s.balanceRepository.Credit(ctx, &database.BalanceTransaction{
+		CustomerID: purchase.CustomerID,
+		Amount:     purchase.Amount,
+		Currency:   purchase.Currency,
+		Reason:     database.BalanceReasonRefund,
+		PurchaseID: &purchase.ID,
+	})
 	if err != nil {
 		return err
 	}
 
+	return s.ApplyRefund(ctx, purchaseId)
+}
+
+// ApplyRefund marks a refunded purchase and takes the purchased period off the subscription.
+// Repeated calls for the same purchase are no-ops.
+func (s PaymentService) ApplyRefund(ctx context.Context, purchaseId int64) error {
+	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+	if purchase == nil {
+		return fmt.Errorf("purchase %d not found", purchaseId)
+	}
+
+	customer, err := s.customerRepository.FindById(ctx, purchase.CustomerID)
+	if err != nil {
+		return err
+	}
+	if customer == nil {
+		return fmt.Errorf("customer %s not found", utils.MaskHalfInt64(purchase.CustomerID))
+	}
+
+	refunded, err := s.purchaseRepository.MarkAsRefunded(ctx, purchase.ID)
+	if err != nil {
+		return err
+	}
+	if !refunded {
+		slog.Info("purchase already refunded or not paid, skipping", "purchase_id", utils.MaskHalfInt64(purchase.ID), "status", purchase.Status)
+		return nil
+	}
+
+	switch purchase.Kind {
+	case database.PurchaseKindBalance:
+		return s.applyTopUpRefund(ctx, purchase, customer)
+	case database.PurchaseKindGift:
+		return s.applyGiftRefund(ctx, purchase, customer)
+	case database.PurchaseKindTraffic:
+		return s.applyTrafficRefund(ctx, purchase, customer)
+	case database.PurchaseKindDevices:
+		return s.applyDeviceUpgradeRefund(ctx, purchase, customer)
+	}
+
+	user, err := s.panel(customer).ShortenSubscription(ctx, customer.TelegramID, purchase.Days())
+	if err != nil {
+		slog.Error("purchase refunded but subscription was not shortened", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", err)
+		return err
+	}
+
+	err = s.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+		"expire_at": user.ExpireAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(customer.Language, "purchase_refunded"), purchase.Month, user.ExpireAt.Format("02.01.2006 15:04")),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: s.createConnectKeyboard(customer),
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending refund notification", "error", err)
+	}
+
+	slog.Info("purchase refunded", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
 	return nil
 }
 
-func (s PaymentService) createTributeInvoice(ctx context.Context, amount int, months int, customer *database.Customer) (url string, purchaseId int64, err error) {
-	purchaseId, err = s.purchaseRepository.Create(ctx, &database.Purchase{
-		InvoiceType: database.InvoiceTypeTribute,
-		Status:      database.PurchaseStatusPending,
-		Amount:      float64(amount),
-		Currency:    "RUB",
-		CustomerID:  customer.ID,
-		Month:       months,
+// applyTopUpRefund takes a refunded top up off the balance.
+func (s PaymentService) applyTopUpRefund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	err := s.balanceRepository.Debit(ctx, &database.BalanceTransaction{
+		CustomerID: customer.ID,
+		Amount:     purchase.Amount,
+		Currency:   purchase.Currency,
+		Reason:     database.BalanceReasonRefund,
+		PurchaseID: &purchase.ID,
 	})
 	if err != nil {
-		slog.Error("Error creating purchase", err)
-		return "", 0, err
+		slog.Error("top up refunded but balance was not debited", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", err)
+		return err
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(customer.Language, "balance_top_up_refunded"), FormatPrice(int(purchase.Amount), purchase.Currency)),
+	})
+	if err != nil {
+		slog.Error("Error sending refund notification", "error", err)
+	}
+
+	slog.Info("top up refunded", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// SavePaymentMethod remembers the payment method of a paid purchase and enables auto-renewal for its customer,
+// if the customer chose auto-renewal when buying it. Methods saved without that consent are not used.
+func (s PaymentService) SavePaymentMethod(ctx context.Context, purchaseId int64, paymentMethodID uuid.UUID) error {
+	if !config.IsAutoPaymentEnabled() {
+		return nil
+	}
+
+	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+	if purchase == nil {
+		return fmt.Errorf("purchase %s not found", utils.MaskHalfInt64(purchaseId))
+	}
+	if purchase.Kind != database.PurchaseKindSubscription || !purchase.AutoRenew {
+		return nil
+	}
+
+	return s.customerRepository.UpdateFields(ctx, purchase.CustomerID, map[string]interface{}{
+		"payment_method_id": paymentMethodID,
+		"auto_renew":        true,
+	})
+}
+
+// SavePaymentDetails records how a paid invoice was settled, e.g. the asset and rate of a crypto payment.
+func (s PaymentService) SavePaymentDetails(ctx context.Context, purchaseId int64, fields map[string]interface{}) error {
+	return s.purchaseRepository.UpdateFields(ctx, purchaseId, fields)
+}
+
+func (s PaymentService) DisableAutoRenewal(ctx context.Context, customerId int64) error {
+	return s.customerRepository.UpdateFields(ctx, customerId, map[string]interface{}{
+		"payment_method_id": nil,
+		"auto_renew":        false,
+	})
+}
+
+// ProcessStarsPayment records a Stars payment and processes its purchase. Renewals of a Stars subscription
+// arrive with the payload of the first invoice and are recorded as new purchases of the same tariff.
+// The charge is recorded on the purchase only by the payment that claims it, any other payment is refunded.
+func (s PaymentService) ProcessStarsPayment(ctx context.Context, purchaseId int64, payment StarsPayment) error {
+	if payment.IsRecurring && !payment.IsFirstRecurring {
+		return s.processStarsRenewal(ctx, purchaseId, payment)
+	}
+
+	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+	if purchase == nil {
+		return fmt.Errorf("purchase %d not found", purchaseId)
+	}
+
+	customer, err := s.customerRepository.FindById(ctx, purchase.CustomerID)
+	if err != nil {
+		return err
+	}
+	if customer == nil {
+		return fmt.Errorf("customer %s not found", utils.MaskHalfInt64(purchase.CustomerID))
+	}
+
+	claimed, err := s.purchaseRepository.ClaimCharge(ctx, purchase.ID, payment.ChargeID)
+	if err != nil && !errors.Is(err, database.ErrPromoCodeExhausted) && !errors.Is(err, database.ErrPromoCodeAlreadyUsed) {
+		return err
+	}
+	if !claimed {
+		return s.rejectStarsPayment(ctx, purchase, customer, payment)
+	}
+
+	if payment.IsFirstRecurring {
+		err = s.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+			"stars_subscription_charge_id": payment.ChargeID,
+		})
+		if err != nil {
+			slog.Error("Error saving stars subscription", "customer_id", utils.MaskHalfInt64(customer.ID), "error", err)
+		}
+	}
+
+	return s.fulfilClaimed(ctx, purchase, customer)
+}
+
+// rejectStarsPayment refunds a Stars payment for a purchase that can't be paid anymore, e.g. one already paid,
+// canceled or refunded. The payment is recorded as a purchase of its own, the paid purchase keeps its charge.
+func (s PaymentService) rejectStarsPayment(ctx context.Context, purchase *database.Purchase, customer *database.Customer, payment StarsPayment) error {
+	current, err := s.purchaseRepository.FindById(ctx, purchase.ID)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.TelegramChargeID != nil && *current.TelegramChargeID == payment.ChargeID {
+		slog.Info("stars payment already processed, skipping", "purchase_id", utils.MaskHalfInt64(purchase.ID))
+		return nil
+	}
+
+	rejected, err := s.purchaseRepository.FindByExternalID(ctx, database.InvoiceTypeTelegram, payment.ChargeID)
+	if err != nil {
+		return err
+	}
+	if rejected == nil {
+		rejected = &database.Purchase{
+			InvoiceType:      database.InvoiceTypeTelegram,
+			Status:           database.PurchaseStatusCancel,
+			Amount:           float64(payment.Amount),
+			Currency:         CurrencyStars,
+			CustomerID:       customer.ID,
+			Month:            purchase.Month,
+			TrafficLimit:     purchase.TrafficLimit,
+			ParentPurchaseID: &purchase.ID,
+			ExternalID:       &payment.ChargeID,
+			TelegramChargeID: &payment.ChargeID,
+			TariffID:         purchase.TariffID,
+			DurationDays:     purchase.DurationDays,
+			Kind:             purchase.Kind,
+		}
+		rejected.ID, err = s.purchaseRepository.Create(ctx, rejected)
+		if err != nil {
+			return err
+		}
+	}
+	if rejected.Status != database.PurchaseStatusCancel {
+		return nil
 	}
 
-	return "", purchaseId, nil
+	telegram, err := s.telegramProvider()
+	if err != nil {
+		return err
+	}
+	if err := telegram.Refund(ctx, rejected, customer); err != nil {
+		slog.Error("stars payment for unpayable purchase was not refunded", "purchase_id", utils.MaskHalfInt64(rejected.ID), "error", err)
+		return err
+	}
+	if payment.IsRecurring {
+		if err := telegram.cancelCharge(ctx, customer.TelegramID, payment.ChargeID); err != nil {
+			slog.Error("Error canceling stars subscription", "purchase_id", utils.MaskHalfInt64(rejected.ID), "error", err)
+		}
+	}
+
+	if _, err := s.purchaseRepository.MarkRejectedAsRefunded(ctx, rejected.ID); err != nil {
+		return err
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      s.translation.GetText(customer.Language, "stars_payment_refunded"),
+	})
+	if err != nil {
+		slog.Error("Error sending refund notification", "error", err)
+	}
+
+	slog.Warn("stars payment for unpayable purchase refunded", "purchase_id", utils.MaskHalfInt64(purchase.ID), "status", purchase.Status, "rejected_id", utils.MaskHalfInt64(rejected.ID))
+	return nil
+}
+
+// processStarsRenewal records a renewal charge as a purchase of its own, the original purchase is never written to.
+// Renewals of a subscription whose original purchase isn't paid, e.g. it was refunded, are refunded as well.
+func (s PaymentService) processStarsRenewal(ctx context.Context, originalId int64, payment StarsPayment) error {
+	existing, err := s.purchaseRepository.FindByExternalID(ctx, database.InvoiceTypeTelegram, payment.ChargeID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return s.ProcessPurchaseById(ctx, existing.ID)
+	}
+
+	original, err := s.purchaseRepository.FindById(ctx, originalId)
+	if err != nil {
+		return err
+	}
+	if original == nil {
+		return fmt.Errorf("purchase %d not found", originalId)
+	}
+
+	if original.Status != database.PurchaseStatusPaid {
+		customer, err := s.customerRepository.FindById(ctx, original.CustomerID)
+		if err != nil {
+			return err
+		}
+		if customer == nil {
+			return fmt.Errorf("customer %s not found", utils.MaskHalfInt64(original.CustomerID))
+		}
+		return s.rejectStarsPayment(ctx, original, customer, payment)
+	}
+
+	purchase := &database.Purchase{
+		InvoiceType:      database.InvoiceTypeTelegram,
+		Status:           database.PurchaseStatusPending,
+		Amount:           float64(payment.Amount),
+		Currency:         CurrencyStars,
+		CustomerID:       original.CustomerID,
+		Month:            original.Month,
+		TrafficLimit:     original.TrafficLimit,
+		ParentPurchaseID: &original.ID,
+		ExternalID:       &payment.ChargeID,
+		TelegramChargeID: &payment.ChargeID,
+		TariffID:         original.TariffID,
+		DurationDays:     original.DurationDays,
+		Kind:             database.PurchaseKindSubscription,
+	}
+	purchase.ID, err = s.purchaseRepository.Create(ctx, purchase)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("stars subscription renewed", "purchase_id", utils.MaskHalfInt64(purchase.ID), "customer_id", utils.MaskHalfInt64(original.CustomerID))
+	return s.ProcessPurchaseById(ctx, purchase.ID)
+}
+
+// CancelStarsSubscription cancels the customer's Stars subscription in Telegram and forgets it.
+func (s PaymentService) CancelStarsSubscription(ctx context.Context, customer *database.Customer) error {
+	telegram, err := s.telegramProvider()
+	if err != nil {
+		return err
+	}
+
+	if err := telegram.CancelSubscription(ctx, customer); err != nil {
+		return err
+	}
+
+	return s.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+		"stars_subscription_charge_id": nil,
+	})
+}
+
+func (s PaymentService) telegramProvider() (*TelegramProvider, error) {
+	provider, ok := s.providers.Get(database.InvoiceTypeTelegram)
+	if !ok {
+		return nil, errors.New("telegram stars payments are disabled")
+	}
+	telegram, ok := provider.(*TelegramProvider)
+	if !ok {
+		return nil, fmt.Errorf("unexpected telegram provider %T", provider)
+	}
+	return telegram, nil
+}
+
+// RenewSubscriptions charges the saved payment method of customers whose subscription expires within a day.
+// The tariff of the last paid purchase is renewed at the current price.
+func (s PaymentService) RenewSubscriptions(ctx context.Context) error {
+	now := time.Now()
+	customers, err := s.customerRepository.FindAutoRenewals(ctx, now, now.Add(24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	for _, customer := range *customers {
+		if err := s.renewSubscription(ctx, &customer); err != nil {
+			slog.Error("Error renewing subscription", "customer_id", utils.MaskHalfInt64(customer.ID), "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (s PaymentService) renewSubscription(ctx context.Context, customer *database.Customer) error {
+	for _, provider := range s.providers.All() {
+		recurring, ok := provider.(RecurringProvider)
+		if !ok {
+			continue
+		}
+
+		original, err := s.purchaseRepository.FindLatestPaidOriginal(ctx, customer.ID, provider.Type())
+		if err != nil {
+			return err
+		}
+		if original == nil {
+			continue
+		}
+
+		// a charge still pending at the provider is settled by CheckPendingPurchases or expired by ExpirePurchases
+		pending, err := s.purchaseRepository.HasPendingRenewal(ctx, customer.ID, provider.Type())
+		if err != nil {
+			return err
+		}
+		if pending {
+			slog.Info("subscription renewal already in flight, skipping", "customer_id", utils.MaskHalfInt64(customer.ID))
+			return nil
+		}
+
+		price, err := s.renewalPrice(ctx, original)
+		if err != nil {
+			return err
+		}
+
+		expireAt := time.Now().Add(config.InvoiceTTL())
+		purchase := &database.Purchase{
+			InvoiceType:      provider.Type(),
+			Status:           database.PurchaseStatusPending,
+			Amount:           float64(price),
+			Currency:         original.Currency,
+			CustomerID:       customer.ID,
+			Month:            original.Month,
+			TrafficLimit:     original.TrafficLimit,
+			ParentPurchaseID: &original.ID,
+			TariffID:         original.TariffID,
+			DurationDays:     original.DurationDays,
+			ExpireAt:         &expireAt,
+		}
+		purchase.ID, err = s.purchaseRepository.Create(ctx, purchase)
+		if err != nil {
+			return err
+		}
+
+		invoice, status, err := recurring.ChargeSavedMethod(ctx, purchase, customer)
+		if err != nil {
+			if cancelErr := s.CancelPayment(purchase.ID); cancelErr != nil {
+				slog.Error("Error canceling renewal", "purchaseId", purchase.ID, "error", cancelErr)
+			}
+			return err
+		}
+
+		if len(invoice.Fields) > 0 {
+			if err := s.purchaseRepository.UpdateFields(ctx, purchase.ID, invoice.Fields); err != nil {
+				return err
+			}
+		}
+
+		switch status {
+		case InvoiceStatusPaid:
+			err = s.ProcessPurchaseById(ctx, purchase.ID)
+		case InvoiceStatusCanceled:
+			err = s.CancelPayment(purchase.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		slog.Info("subscription renewal charged", "purchase_id", utils.MaskHalfInt64(purchase.ID), "status", status, "customer_id", utils.MaskHalfInt64(customer.ID))
+		return nil
+	}
+
+	return nil
+}
+
+// renewalPrice is the current price of the renewed tariff in the currency it was paid in,
+// purchases made before tariffs use PRICE_<n>.
+func (s PaymentService) renewalPrice(ctx context.Context, original *database.Purchase) (int, error) {
+	if original.TariffID == nil {
+		return config.Price(original.Month), nil
+	}
+
+	tariff, err := s.tariffRepository.FindById(ctx, *original.TariffID)
+	if err != nil {
+		return 0, err
+	}
+	if tariff == nil {
+		return 0, fmt.Errorf("tariff %d not found", *original.TariffID)
+	}
+
+	price, ok := tariff.Price(original.Currency)
+	if !ok {
+		return 0, fmt.Errorf("tariff %d has no %s price", tariff.ID, original.Currency)
+	}
+	return price, nil
+}
+
+func (s PaymentService) notifyAutoRenewFailed(ctx context.Context, customerId int64) {
+	customer, err := s.customerRepository.FindById(ctx, customerId)
+	if err != nil || customer == nil {
+		slog.Error("Error finding customer for renewal notification", "customer_id", utils.MaskHalfInt64(customerId), "error", err)
+		return
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: customer.TelegramID,
+		Text:   s.translation.GetText(customer.Language, "auto_renew_failed"),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{{Text: s.translation.GetText(customer.Language, "buy_button"), CallbackData: "buy"}},
+			},
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending renewal notification", "error", err)
+	}
 }
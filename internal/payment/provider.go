@@ -0,0 +1,187 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/translation"
+	"sync"
+	"time"
+)
+
+const (
+	CurrencyRUB   = "RUB"
+	CurrencyUSD   = "USD"
+	CurrencyEUR   = "EUR"
+	CurrencyStars = "STARS"
+)
+
+type InvoiceStatus string
+
+const (
+	InvoiceStatusPending  InvoiceStatus = "pending"
+	InvoiceStatusPaid     InvoiceStatus = "paid"
+	InvoiceStatusCanceled InvoiceStatus = "canceled"
+)
+
+var ErrStatusCheckUnsupported = errors.New("provider does not support invoice status checks")
+
+// Invoice is the result of issuing an invoice at a provider.
+// Fields holds provider specific purchase columns to persist alongside the pending status.
+// Paid is set by providers that settle the invoice while issuing it, such invoices have no URL.
+type Invoice struct {
+	URL    string
+	Fields map[string]interface{}
+	Paid   bool
+}
+
+// InvoiceState is the provider side view of an invoice.
+// PaymentMethodID is set when the payer agreed to save the payment method for auto-renewal.
+// Fields holds provider specific purchase columns describing how a paid invoice was settled.
+type InvoiceState struct {
+	Status          InvoiceStatus
+	Username        string
+	PaymentMethodID *uuid.UUID
+	Fields          map[string]interface{}
+}
+
+// Provider is a payment gateway that can bill a purchase.
+// Currencies lists what the provider can bill in, the first one is used for customers paying in another currency.
+type Provider interface {
+	Type() database.InvoiceType
+	ButtonKey() string
+	Currencies() []string
+	CreateInvoice(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*Invoice, error)
+	CheckStatus(ctx context.Context, purchase *database.Purchase) (*InvoiceState, error)
+	Cancel(ctx context.Context, purchase *database.Purchase) error
+}
+
+// Processor is the part of PaymentService exposed to provider webhooks.
+type Processor interface {
+	CreatePurchase(ctx context.Context, amount int, currency string, months int, customer *database.Customer, invoiceType database.InvoiceType) (url string, purchaseId int64, err error)
+	ProcessPurchaseById(ctx context.Context, purchaseId int64) error
+	CancelPayment(purchaseId int64) error
+	SavePaymentMethod(ctx context.Context, purchaseId int64, paymentMethodID uuid.UUID) error
+	SavePaymentDetails(ctx context.Context, purchaseId int64, fields map[string]interface{}) error
+	ApplyRefund(ctx context.Context, purchaseId int64) error
+}
+
+// WebhookProvider is implemented by providers that receive payment notifications over HTTP.
+type WebhookProvider interface {
+	WebhookPath() string
+	WebhookHandler(processor Processor) http.Handler
+}
+
+// PollingProvider is implemented by providers whose pending invoices are checked on a cron schedule.
+type PollingProvider interface {
+	PollSchedule() string
+}
+
+// BatchStatusProvider is implemented by providers able to check many invoices in one request.
+// Purchases missing from the returned states are still pending.
+type BatchStatusProvider interface {
+	CheckStatuses(ctx context.Context, purchases []database.Purchase) (map[int64]*InvoiceState, error)
+}
+
+// RecurringProvider is implemented by providers able to charge a customer's saved payment method
+// without user interaction. The returned status is final unless it is InvoiceStatusPending.
+type RecurringProvider interface {
+	ChargeSavedMethod(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*Invoice, InvoiceStatus, error)
+}
+
+// RefundProvider is implemented by providers able to return the money of a paid purchase.
+type RefundProvider interface {
+	Refund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error
+}
+
+// QuoteProvider is implemented by providers that can show what a price comes to in the units the payer pays in.
+// An empty quote is not shown.
+type QuoteProvider interface {
+	Quote(ctx context.Context, amount int, currency string) string
+}
+
+// LinkProvider is implemented by providers paid through a static external link,
+// the purchase is created later by the provider webhook.
+type LinkProvider interface {
+	PaymentURL() string
+}
+
+// ReviewProvider is implemented by providers confirmed by an admin instead of the provider,
+// the payer is shown the payment details in place of a link and sends a receipt to the bot.
+type ReviewProvider interface {
+	PaymentDetails() string
+}
+
+// ProviderPayment is a payment as the provider recorded it.
+// PurchaseID is zero when the payment doesn't carry the purchase it was issued for.
+type ProviderPayment struct {
+	ID         string
+	PurchaseID int64
+	Paid       bool
+	Amount     string
+	Currency   string
+	CreatedAt  time.Time
+}
+
+// ReconcileProvider is implemented by providers able to list the payments created in [from, to),
+// the reconciliation report compares them with the purchase table.
+type ReconcileProvider interface {
+	ListPayments(ctx context.Context, from, to time.Time) ([]ProviderPayment, error)
+}
+
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[database.InvoiceType]Provider
+	order     []database.InvoiceType
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		providers: make(map[database.InvoiceType]Provider),
+	}
+}
+
+func (r *Registry) Register(provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.providers[provider.Type()]; !exists {
+		r.order = append(r.order, provider.Type())
+	}
+	r.providers[provider.Type()] = provider
+}
+
+func (r *Registry) Get(invoiceType database.InvoiceType) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	provider, ok := r.providers[invoiceType]
+	return provider, ok
+}
+
+func (r *Registry) All() []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	providers := make([]Provider, 0, len(r.order))
+	for _, invoiceType := range r.order {
+		providers = append(providers, r.providers[invoiceType])
+	}
+	return providers
+}
+
+// Description names what a purchase buys, for receipts and invoices shown by the provider.
+func Description(tm *translation.Manager, lang string, purchase *database.Purchase) string {
+	switch purchase.Kind {
+	case database.PurchaseKindBalance:
+		return tm.GetText(lang, "receipt_balance_top_up")
+	case database.PurchaseKindGift:
+		return fmt.Sprintf(tm.GetText(lang, "receipt_gift"), purchase.Days())
+	case database.PurchaseKindTraffic:
+		return tm.GetText(lang, "receipt_traffic")
+	case database.PurchaseKindDevices:
+		return fmt.Sprintf(tm.GetText(lang, "receipt_devices"), *purchase.DeviceLimit)
+	default:
+		return fmt.Sprintf(tm.GetText(lang, "receipt_subscription"), purchase.Days())
+	}
+}
@@ -0,0 +1,172 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	remapi "github.com/Jolymmiles/remnawave-api-go/api"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/remnawave"
+	"remnawave-tg-shop-bot/utils"
+	"time"
+)
+
+const (
+	// provisioningLease is how long a running job is left to its worker before another one takes it over.
+	provisioningLease      = 10 * time.Minute
+	provisioningBatch      = 20
+	provisioningMaxBackoff = time.Hour
+)
+
+var ErrJobNotPending = errors.New("provisioning job is not waiting for a retry")
+
+// ProcessProvisioningJobs retries the fulfilment of paid purchases whose previous attempt failed.
+func (s PaymentService) ProcessProvisioningJobs(ctx context.Context) {
+	now := time.Now()
+	jobs, err := s.provisioningJobRepository.ClaimDue(ctx, now, now.Add(provisioningLease), provisioningBatch)
+	if err != nil {
+		slog.Error("Error claiming provisioning jobs", "error", err)
+		return
+	}
+
+	for i := range jobs {
+		if err := s.processProvisioningJob(ctx, &jobs[i]); err != nil {
+			slog.Error("Error processing provisioning job", "job_id", jobs[i].ID, "purchase_id", utils.MaskHalfInt64(jobs[i].PurchaseID), "error", err)
+		}
+	}
+}
+
+// RetryProvisioningJob runs a job waiting for its next attempt right away.
+func (s PaymentService) RetryProvisioningJob(ctx context.Context, jobId int64) error {
+	job, err := s.provisioningJobRepository.ClaimPending(ctx, jobId, time.Now().Add(provisioningLease))
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return ErrJobNotPending
+	}
+	return s.processProvisioningJob(ctx, job)
+}
+
+func (s PaymentService) processProvisioningJob(ctx context.Context, job *database.ProvisioningJob) error {
+	purchase, err := s.purchaseRepository.FindById(ctx, job.PurchaseID)
+	if err != nil {
+		return err
+	}
+	// The purchase was fulfilled, refunded or reopened for reprocessing meanwhile.
+	if purchase == nil || purchase.Status != database.PurchaseStatusProcessing {
+		return s.provisioningJobRepository.MarkDone(ctx, job.ID)
+	}
+
+	customer, err := s.customerRepository.FindById(ctx, purchase.CustomerID)
+	if err != nil {
+		return err
+	}
+	if customer == nil {
+		return fmt.Errorf("customer %s not found", utils.MaskHalfInt64(purchase.CustomerID))
+	}
+
+	return s.runProvisioningJob(ctx, job, purchase, customer)
+}
+
+// runProvisioningJob fulfils the claimed purchase of a job. A failed attempt is scheduled again with a backoff,
+// the customer is told the payment was received on the first failure and the admin is alerted once it keeps failing.
+func (s PaymentService) runProvisioningJob(ctx context.Context, job *database.ProvisioningJob, purchase *database.Purchase, customer *database.Customer) error {
+	err := s.fulfilPurchase(ctx, job, purchase, customer)
+	if err == nil {
+		if doneErr := s.provisioningJobRepository.MarkDone(ctx, job.ID); doneErr != nil {
+			slog.Error("Error completing provisioning job", "job_id", job.ID, "error", doneErr)
+		}
+		return nil
+	}
+
+	// A step after the purchase was marked as paid failed, fulfilling it again would deliver it twice.
+	current, findErr := s.purchaseRepository.FindById(ctx, purchase.ID)
+	if findErr == nil && current != nil && current.Status != database.PurchaseStatusProcessing {
+		slog.Error("purchase fulfilled with errors", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", err)
+		if doneErr := s.provisioningJobRepository.MarkDone(ctx, job.ID); doneErr != nil {
+			slog.Error("Error completing provisioning job", "job_id", job.ID, "error", doneErr)
+		}
+		return nil
+	}
+
+	attempts := job.Attempts + 1
+	if failErr := s.provisioningJobRepository.MarkFailed(ctx, job.ID, attempts, time.Now().Add(provisioningBackoff(attempts)), err.Error()); failErr != nil {
+		slog.Error("Error recording provisioning failure", "job_id", job.ID, "error", failErr)
+	}
+	slog.Error("purchase paid but not provisioned", "purchase_id", utils.MaskHalfInt64(purchase.ID), "attempts", attempts, "error", err)
+
+	if attempts == 1 {
+		_, sendErr := s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    customer.TelegramID,
+			ParseMode: models.ParseModeHTML,
+			Text:      s.translation.GetText(customer.Language, "provisioning_pending"),
+		})
+		if sendErr != nil {
+			slog.Error("Error sending provisioning message", "error", sendErr)
+		}
+	}
+
+	if attempts == config.ProvisioningAlertAttempts() {
+		_, sendErr := s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: config.GetAdminTelegramId(),
+			Text:   fmt.Sprintf("Purchase %d is paid but was not provisioned after %d attempts: %v", purchase.ID, attempts, err),
+			ReplyMarkup: models.InlineKeyboardMarkup{
+				InlineKeyboard: [][]models.InlineKeyboardButton{
+					{{Text: "Retry now", CallbackData: fmt.Sprintf("provisioning_retry?job=%d", job.ID)}},
+				},
+			},
+		})
+		if sendErr != nil {
+			slog.Error("Error sending provisioning alert", "error", sendErr)
+		}
+	}
+
+	return err
+}
+
+// applyOnce applies the purchase of a job on the panel unless an earlier attempt already did, in which case
+// the panel user is returned as is. Traffic and subscription days are added to the current state, applying
+// them again after a failure further down the fulfilment would deliver them twice.
+func (s PaymentService) applyOnce(ctx context.Context, job *database.ProvisioningJob, panel remnawave.Panel, telegramId int64, apply func() (*remapi.UserDto, error)) (*remapi.UserDto, error) {
+	if job.PanelAppliedAt != nil {
+		user, err := panel.FindUser(ctx, telegramId)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("panel user of purchase %s not found", utils.MaskHalfInt64(job.PurchaseID))
+		}
+		return user, nil
+	}
+
+	user, err := apply()
+	if err != nil {
+		return nil, err
+	}
+
+	// Marking the purchase as paid follows right away, a retry is only possible when both writes fail.
+	if err := s.provisioningJobRepository.MarkPanelApplied(ctx, job.ID); err != nil {
+		slog.Error("Error recording applied provisioning job", "job_id", job.ID, "error", err)
+	} else {
+		now := time.Now()
+		job.PanelAppliedAt = &now
+	}
+	return user, nil
+}
+
+// provisioningBackoff doubles the delay after every failed attempt, starting at a minute.
+func provisioningBackoff(attempts int) time.Duration {
+	if attempts > 7 {
+		return provisioningMaxBackoff
+	}
+	backoff := time.Minute << (attempts - 1)
+	if backoff > provisioningMaxBackoff {
+		return provisioningMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,169 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/utils"
+	"time"
+)
+
+// reconcileSlack is how much later than the report period a provider payment may be created
+// and still count for a purchase of the period.
+const reconcileSlack = time.Hour
+
+// MismatchKind is how the purchase table and a provider disagree.
+type MismatchKind string
+
+const (
+	// MismatchUnprocessed is a payment the provider recorded as paid whose purchase was never fulfilled.
+	MismatchUnprocessed MismatchKind = "unprocessed"
+	// MismatchMissingPayment is a purchase marked paid without a paid payment at the provider.
+	MismatchMissingPayment MismatchKind = "missing_payment"
+	// MismatchUnknownPayment is a paid payment that doesn't belong to any purchase.
+	MismatchUnknownPayment MismatchKind = "unknown_payment"
+)
+
+// Mismatch is a single finding of Reconcile. PurchaseID is zero for unknown payments,
+// PaymentID is empty for missing ones.
+type Mismatch struct {
+	Kind        MismatchKind
+	InvoiceType database.InvoiceType
+	PurchaseID  int64
+	Status      database.PurchaseStatus
+	PaymentID   string
+	Amount      string
+	Currency    string
+}
+
+var ErrNotPaid = errors.New("purchase is not paid at the provider")
+
+// Reconcile compares the purchases created in [from, to) with the payments their providers recorded.
+// Only providers implementing ReconcileProvider are checked.
+func (s PaymentService) Reconcile(ctx context.Context, from, to time.Time) ([]Mismatch, error) {
+	var mismatches []Mismatch
+	for _, provider := range s.providers.All() {
+		reconciler, ok := provider.(ReconcileProvider)
+		if !ok {
+			continue
+		}
+
+		found, err := s.reconcileProvider(ctx, provider.Type(), reconciler, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconcile %s payments: %w", provider.Type(), err)
+		}
+		mismatches = append(mismatches, found...)
+	}
+	return mismatches, nil
+}
+
+func (s PaymentService) reconcileProvider(ctx context.Context, invoiceType database.InvoiceType, reconciler ReconcileProvider, from, to time.Time) ([]Mismatch, error) {
+	payments, err := reconciler.ListPayments(ctx, from, to.Add(reconcileSlack))
+	if err != nil {
+		return nil, err
+	}
+
+	purchases, err := s.purchaseRepository.FindCreatedBetween(ctx, invoiceType, from, to)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]*database.Purchase, len(*purchases))
+	for i := range *purchases {
+		byID[(*purchases)[i].ID] = &(*purchases)[i]
+	}
+
+	var mismatches []Mismatch
+	paid := make(map[int64]bool)
+	for _, providerPayment := range payments {
+		if !providerPayment.Paid {
+			continue
+		}
+		paid[providerPayment.PurchaseID] = true
+		if !providerPayment.CreatedAt.Before(to) {
+			continue
+		}
+
+		purchase := byID[providerPayment.PurchaseID]
+		if purchase == nil && providerPayment.PurchaseID != 0 {
+			purchase, err = s.purchaseRepository.FindById(ctx, providerPayment.PurchaseID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		mismatch := Mismatch{
+			InvoiceType: invoiceType,
+			PaymentID:   providerPayment.ID,
+			Amount:      providerPayment.Amount,
+			Currency:    providerPayment.Currency,
+		}
+		switch {
+		case purchase == nil || purchase.InvoiceType != invoiceType:
+			mismatch.Kind = MismatchUnknownPayment
+		case purchase.Status != database.PurchaseStatusPaid && purchase.Status != database.PurchaseStatusRefunded:
+			mismatch.Kind = MismatchUnprocessed
+			mismatch.PurchaseID = purchase.ID
+			mismatch.Status = purchase.Status
+		default:
+			continue
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	for _, purchase := range *purchases {
+		if purchase.Status != database.PurchaseStatusPaid || paid[purchase.ID] {
+			continue
+		}
+		mismatches = append(mismatches, Mismatch{
+			Kind:        MismatchMissingPayment,
+			InvoiceType: invoiceType,
+			PurchaseID:  purchase.ID,
+			Status:      purchase.Status,
+			Amount:      fmt.Sprintf("%.2f", purchase.Amount),
+			Currency:    purchase.Currency,
+		})
+	}
+
+	return mismatches, nil
+}
+
+// ReprocessPurchase fulfils a purchase its provider reports as paid, e.g. an unprocessed payment found by Reconcile.
+func (s PaymentService) ReprocessPurchase(ctx context.Context, purchaseId int64) error {
+	purchase, err := s.purchaseRepository.FindById(ctx, purchaseId)
+	if err != nil {
+		return err
+	}
+	if purchase == nil {
+		return fmt.Errorf("purchase %s not found", utils.MaskHalfInt64(purchaseId))
+	}
+
+	provider, ok := s.providers.Get(purchase.InvoiceType)
+	if !ok {
+		return fmt.Errorf("invoice type %s is disabled", purchase.InvoiceType)
+	}
+
+	state, err := provider.CheckStatus(ctx, purchase)
+	if err != nil {
+		return err
+	}
+	if state.Status != InvoiceStatusPaid {
+		return ErrNotPaid
+	}
+
+	if _, err := s.purchaseRepository.Reopen(ctx, purchase.ID); err != nil {
+		return err
+	}
+	if err := s.SavePaymentDetails(ctx, purchase.ID, state.Fields); err != nil {
+		slog.Error("Error saving payment details", "purchaseId", purchase.ID, "error", err)
+	}
+	if state.PaymentMethodID != nil {
+		if err := s.SavePaymentMethod(ctx, purchase.ID, *state.PaymentMethodID); err != nil {
+			slog.Error("Error saving payment method", "purchaseId", purchase.ID, "error", err)
+		}
+	}
+
+	slog.Info("reprocessing purchase", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "status", purchase.Status)
+	return s.ProcessPurchaseById(context.WithValue(ctx, "username", state.Username), purchase.ID)
+}
@@ -0,0 +1,118 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/translation"
+)
+
+// StarsSubscriptionDays is the only period Telegram bills Stars subscriptions for.
+const StarsSubscriptionDays = 30
+
+// StarsPayment is a successful Stars payment. Renewals of a subscription carry the payload of its first invoice.
+type StarsPayment struct {
+	ChargeID         string
+	Amount           int
+	IsRecurring      bool
+	IsFirstRecurring bool
+}
+
+type TelegramProvider struct {
+	telegramBot *bot.Bot
+	translation *translation.Manager
+}
+
+func NewTelegramProvider(telegramBot *bot.Bot, translation *translation.Manager) *TelegramProvider {
+	return &TelegramProvider{
+		telegramBot: telegramBot,
+		translation: translation,
+	}
+}
+
+func (p *TelegramProvider) Type() database.InvoiceType {
+	return database.InvoiceTypeTelegram
+}
+
+func (p *TelegramProvider) ButtonKey() string {
+	return "stars_button"
+}
+
+func (p *TelegramProvider) Currencies() []string {
+	return []string{CurrencyStars}
+}
+
+// CreateInvoice issues a Stars subscription for 30 day tariffs when subscriptions are enabled, a one-off invoice otherwise.
+func (p *TelegramProvider) CreateInvoice(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*Invoice, error) {
+	params := &bot.CreateInvoiceLinkParams{
+		Title:    p.translation.GetText(customer.Language, "invoice_title"),
+		Currency: "XTR",
+		Prices: []models.LabeledPrice{
+			{
+				Label:  p.translation.GetText(customer.Language, "invoice_label"),
+				Amount: int(purchase.Amount),
+			},
+		},
+		Description: p.translation.GetText(customer.Language, "invoice_description"),
+		Payload:     fmt.Sprintf("%d&%s", purchase.ID, ctx.Value("username")),
+	}
+	if config.IsStarsSubscriptionEnabled() && purchase.Kind == database.PurchaseKindSubscription && purchase.Days() == StarsSubscriptionDays {
+		params.SubscriptionPeriod = StarsSubscriptionDays * 24 * 60 * 60
+	}
+
+	invoiceUrl, err := p.telegramBot.CreateInvoiceLink(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice link: %w", err)
+	}
+
+	return &Invoice{URL: invoiceUrl}, nil
+}
+
+// CheckStatus is not supported, Telegram confirms Stars payments with a SuccessfulPayment update.
+func (p *TelegramProvider) CheckStatus(ctx context.Context, purchase *database.Purchase) (*InvoiceState, error) {
+	return nil, ErrStatusCheckUnsupported
+}
+
+func (p *TelegramProvider) Cancel(ctx context.Context, purchase *database.Purchase) error {
+	return nil
+}
+
+func (p *TelegramProvider) Refund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	if purchase.TelegramChargeID == nil {
+		return fmt.Errorf("purchase %d has no telegram payment charge id", purchase.ID)
+	}
+
+	_, err := p.telegramBot.RefundStarPayment(ctx, &bot.RefundStarPaymentParams{
+		UserID:                  customer.TelegramID,
+		TelegramPaymentChargeID: *purchase.TelegramChargeID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refund star payment: %w", err)
+	}
+	return nil
+}
+
+// CancelSubscription stops further charges of the customer's Stars subscription, the paid period stays active.
+func (p *TelegramProvider) CancelSubscription(ctx context.Context, customer *database.Customer) error {
+	if customer.StarsSubID == nil {
+		return fmt.Errorf("customer %d has no stars subscription", customer.ID)
+	}
+
+	return p.cancelCharge(ctx, customer.TelegramID, *customer.StarsSubID)
+}
+
+// cancelCharge stops further charges of the Stars subscription started or renewed by the given charge.
+func (p *TelegramProvider) cancelCharge(ctx context.Context, telegramId int64, chargeId string) error {
+	_, err := p.telegramBot.EditUserStarSubscription(ctx, &bot.EditUserStarSubscriptionParams{
+		UserID:                  telegramId,
+		TelegramPaymentChargeID: chargeId,
+		IsCanceled:              true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cancel star subscription: %w", err)
+	}
+	return nil
+}
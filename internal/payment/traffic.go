@@ -0,0 +1,119 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	remapi "github.com/Jolymmiles/remnawave-api-go/api"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"log/slog"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/utils"
+)
+
+// CreateTrafficPurchase issues an invoice for the extra traffic package at the given index of TRAFFIC_PACKAGES.
+func (s PaymentService) CreateTrafficPurchase(ctx context.Context, index int, customer *database.Customer, invoiceType database.InvoiceType) (url string, purchaseId int64, err error) {
+	packages := config.TrafficPackages()
+	if index < 0 || index >= len(packages) {
+		return "", 0, fmt.Errorf("unknown traffic package %d", index)
+	}
+	pkg := packages[index]
+
+	provider, ok := s.providers.Get(invoiceType)
+	if !ok {
+		return "", 0, fmt.Errorf("unknown invoice type: %s", invoiceType)
+	}
+
+	currency := SettlementCurrency(provider, PreferredCurrency(customer))
+	price, ok := pkg.Price(currency)
+	if !ok {
+		return "", 0, fmt.Errorf("traffic package %d has no %s price", index, currency)
+	}
+
+	trafficLimit := pkg.Bytes()
+	purchase := &database.Purchase{
+		Amount:       float64(price),
+		Currency:     currency,
+		TrafficLimit: &trafficLimit,
+		Kind:         database.PurchaseKindTraffic,
+	}
+	return s.createPurchase(ctx, provider, purchase, customer)
+}
+
+// TrafficLimit is the traffic limit of a panel user in bytes, 0 when the traffic is unlimited.
+func TrafficLimit(user *remapi.UserDto) int {
+	limit, ok := user.TrafficLimitBytes.Get()
+	if !ok {
+		return 0
+	}
+	return limit
+}
+
+// PanelUser returns the panel user of a customer, e.g. to show the used traffic. It is nil for customers without one.
+func (s PaymentService) PanelUser(ctx context.Context, customer *database.Customer) (*remapi.UserDto, error) {
+	return s.panel(customer).FindUser(ctx, customer.TelegramID)
+}
+
+// processTraffic raises the traffic limit, or resets the used traffic, of a claimed traffic purchase.
+// The subscription expiration is not touched.
+func (s PaymentService) processTraffic(ctx context.Context, job *database.ProvisioningJob, purchase *database.Purchase, customer *database.Customer) error {
+	panel := s.panel(customer)
+	user, err := s.applyOnce(ctx, job, panel, customer.TelegramID, func() (*remapi.UserDto, error) {
+		if purchase.TrafficLimit == nil || *purchase.TrafficLimit == 0 {
+			return panel.ResetTraffic(ctx, customer.TelegramID)
+		}
+		return panel.AddTraffic(ctx, customer.TelegramID, *purchase.TrafficLimit)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.purchaseRepository.MarkAsPaid(ctx, purchase.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      fmt.Sprintf(s.translation.GetText(customer.Language, "traffic_added"), FormatTraffic(user.UsedTrafficBytes), FormatTraffic(float64(TrafficLimit(user)))),
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: s.createConnectKeyboard(customer),
+		},
+	})
+	if err != nil {
+		slog.Error("Error sending traffic notification", "error", err)
+	}
+
+	slog.Info("traffic purchased", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// applyTrafficRefund takes the refunded traffic off the limit, a traffic reset can't be taken back.
+func (s PaymentService) applyTrafficRefund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	if purchase.TrafficLimit != nil && *purchase.TrafficLimit > 0 {
+		_, err := s.panel(customer).AddTraffic(ctx, customer.TelegramID, -*purchase.TrafficLimit)
+		if err != nil {
+			slog.Error("traffic refunded but limit was not lowered", "purchase_id", utils.MaskHalfInt64(purchase.ID), "error", err)
+			return err
+		}
+	}
+
+	_, err := s.telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    customer.TelegramID,
+		ParseMode: models.ParseModeHTML,
+		Text:      s.translation.GetText(customer.Language, "traffic_refunded"),
+	})
+	if err != nil {
+		slog.Error("Error sending refund notification", "error", err)
+	}
+
+	slog.Info("traffic refunded", "purchase_id", utils.MaskHalfInt64(purchase.ID), "type", purchase.InvoiceType, "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// FormatTraffic shows bytes in gigabytes, e.g. 12.5 GB.
+func FormatTraffic(bytes float64) string {
+	return fmt.Sprintf("%.1f GB", bytes/(1<<30))
+}
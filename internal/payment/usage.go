@@ -0,0 +1,144 @@
+package payment
+
+import (
+	"context"
+	remapi "github.com/Jolymmiles/remnawave-api-go/api"
+	"log/slog"
+	"remnawave-tg-shop-bot/internal/cache"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/utils"
+	"time"
+)
+
+// usageTTL is how long the panel usage of a customer is reused, so reopening the Connect screen doesn't hit the panel.
+const usageTTL = time.Minute
+
+// onlineWindow is how recently a node must have seen a user for the user to count as online.
+const onlineWindow = 5 * time.Minute
+
+// Usage is the live state of the panel user of a customer as shown on the Connect screen.
+type Usage struct {
+	ExpireAt     time.Time
+	UsedTraffic  float64
+	TrafficLimit int
+	NextReset    *time.Time
+	OnlineAt     *time.Time
+	Devices      *int
+	DeviceLimit  int
+	FetchedAt    time.Time
+}
+
+// newUsageCache keeps the usage per customer id, nil for customers without a panel user.
+func newUsageCache() *cache.TypedCache[*Usage] {
+	return cache.NewTypedCache[*Usage](usageTTL)
+}
+
+// Online tells whether a node saw the user within onlineWindow of the usage being fetched.
+func (u Usage) Online() bool {
+	return u.OnlineAt != nil && u.FetchedAt.Sub(*u.OnlineAt) < onlineWindow
+}
+
+// Usage returns the live usage of the panel user of a customer, nil for customers without one. It is cached per customer
+// for usageTTL, or until the expiration of the customer changes. The expiration and subscription link of the customer
+// are refreshed from the panel when they went stale, e.g. after the user was extended on the panel directly.
+func (s PaymentService) Usage(ctx context.Context, customer *database.Customer) (*Usage, error) {
+	if usage, ok := s.usage.Get(customer.ID); ok && !usageStale(usage, customer) {
+		return usage, nil
+	}
+
+	user, err := s.PanelUser(ctx, customer)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		s.usage.Set(customer.ID, nil)
+		return nil, nil
+	}
+
+	err = s.refreshSubscription(ctx, customer, user)
+	if err != nil {
+		slog.Error("Error refreshing subscription from the panel", "customer_id", utils.MaskHalfInt64(customer.ID), "error", err)
+	}
+
+	usage := &Usage{
+		ExpireAt:     user.ExpireAt,
+		UsedTraffic:  user.UsedTrafficBytes,
+		TrafficLimit: TrafficLimit(user),
+		DeviceLimit:  DeviceLimit(user),
+		FetchedAt:    time.Now(),
+	}
+	strategy, _ := user.TrafficLimitStrategy.Get()
+	if reset, ok := NextTrafficReset(string(strategy), usage.FetchedAt); ok && usage.TrafficLimit > 0 {
+		usage.NextReset = &reset
+	}
+	if onlineAt, ok := user.OnlineAt.Get(); ok {
+		usage.OnlineAt = &onlineAt
+	}
+
+	devices, err := s.panel(customer).DeviceCount(ctx, user.UUID)
+	if err != nil {
+		slog.Warn("Error counting devices", "customer_id", utils.MaskHalfInt64(customer.ID), "error", err)
+	} else {
+		usage.Devices = &devices
+	}
+
+	s.usage.Set(customer.ID, usage)
+	return usage, nil
+}
+
+// usageStale tells whether the customer changed since the usage was cached, e.g. by a purchase.
+func usageStale(usage *Usage, customer *database.Customer) bool {
+	if usage == nil {
+		return customer.ExpireAt != nil
+	}
+	return customer.ExpireAt == nil || !customer.ExpireAt.Equal(usage.ExpireAt)
+}
+
+// refreshSubscription stores the expiration and subscription link of the panel user if the customer has different ones.
+func (s PaymentService) refreshSubscription(ctx context.Context, customer *database.Customer, user *remapi.UserDto) error {
+	updates := map[string]interface{}{}
+	if customer.ExpireAt == nil || !customer.ExpireAt.Equal(user.ExpireAt) {
+		updates["expire_at"] = user.ExpireAt
+	}
+	if user.SubscriptionUrl != "" && (customer.SubscriptionLink == nil || *customer.SubscriptionLink != user.SubscriptionUrl) {
+		updates["subscription_link"] = user.SubscriptionUrl
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	err := s.customerRepository.UpdateFields(ctx, customer.ID, updates)
+	if err != nil {
+		return err
+	}
+
+	expireAt := user.ExpireAt
+	customer.ExpireAt = &expireAt
+	if user.SubscriptionUrl != "" {
+		link := user.SubscriptionUrl
+		customer.SubscriptionLink = &link
+	}
+	slog.Info("customer subscription refreshed from the panel", "customer_id", utils.MaskHalfInt64(customer.ID))
+	return nil
+}
+
+// NextTrafficReset is when the panel next resets the used traffic of a user with the strategy, false for NO_RESET.
+// The panel resets daily at midnight, weekly on Mondays and monthly on the 1st, all in UTC.
+func NextTrafficReset(strategy string, now time.Time) (time.Time, bool) {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch strategy {
+	case "DAY":
+		return midnight.AddDate(0, 0, 1), true
+	case "WEEK":
+		days := (8 - int(midnight.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return midnight.AddDate(0, 0, days), true
+	case "MONTH":
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC), true
+	default:
+		return time.Time{}, false
+	}
+}
@@ -0,0 +1,114 @@
+package promo
+
+import (
+	"context"
+	"errors"
+	"remnawave-tg-shop-bot/internal/database"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound      = errors.New("promo code not found")
+	ErrNotActive     = errors.New("promo code is not active")
+	ErrPlanNotFit    = errors.New("promo code does not apply to this plan")
+	ErrExhausted     = database.ErrPromoCodeExhausted
+	ErrAlreadyUsed   = database.ErrPromoCodeAlreadyUsed
+	ErrInvalidFormat = errors.New("invalid promo code")
+)
+
+type Service struct {
+	promoCodeRepository *database.PromoCodeRepository
+}
+
+func NewService(promoCodeRepository *database.PromoCodeRepository) *Service {
+	return &Service{promoCodeRepository: promoCodeRepository}
+}
+
+// Normalize makes codes case-insensitive, they are stored upper-cased.
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks that the customer can redeem the code for a plan of the given length.
+func (s *Service) Validate(ctx context.Context, code string, customerID int64, month int) (*database.PromoCode, error) {
+	code = Normalize(code)
+	if code == "" || len(code) > 64 {
+		return nil, ErrInvalidFormat
+	}
+
+	promo, err := s.promoCodeRepository.FindByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil {
+		return nil, ErrNotFound
+	}
+	return promo, s.check(ctx, promo, customerID, month)
+}
+
+// ValidateById re-checks a code chosen earlier in the purchase flow.
+func (s *Service) ValidateById(ctx context.Context, id int64, customerID int64, month int) (*database.PromoCode, error) {
+	promo, err := s.promoCodeRepository.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil {
+		return nil, ErrNotFound
+	}
+	return promo, s.check(ctx, promo, customerID, month)
+}
+
+func (s *Service) check(ctx context.Context, promo *database.PromoCode, customerID int64, month int) error {
+	now := time.Now()
+	if promo.ValidFrom != nil && now.Before(*promo.ValidFrom) {
+		return ErrNotActive
+	}
+	if promo.ValidUntil != nil && now.After(*promo.ValidUntil) {
+		return ErrNotActive
+	}
+
+	if len(promo.Months) > 0 {
+		fits := false
+		for _, m := range promo.Months {
+			if int(m) == month {
+				fits = true
+				break
+			}
+		}
+		if !fits {
+			return ErrPlanNotFit
+		}
+	}
+
+	// unpaid invoices of other customers hold a use, the customer's own are released once a new invoice is opened.
+	// The purchase is checked again, with the code locked, when it is created.
+	if promo.MaxUses == nil && promo.PerUserLimit == 0 {
+		return nil
+	}
+	usage, err := s.promoCodeRepository.Usage(ctx, promo.ID, customerID)
+	if err != nil {
+		return err
+	}
+	return promo.CheckUsage(usage)
+}
+
+// Apply returns the discounted price. Fixed discounts are set in rubles and scaled by
+// price/rubPrice for other currencies, they don't apply to plans without a ruble price.
+// A purchase always costs at least 1.
+func Apply(promo *database.PromoCode, price int, rubPrice int) int {
+	var discount int
+	switch promo.DiscountType {
+	case database.DiscountTypePercent:
+		discount = price * promo.DiscountValue / 100
+	case database.DiscountTypeFixed:
+		if rubPrice > 0 {
+			discount = promo.DiscountValue * price / rubPrice
+		}
+	}
+
+	if price-discount < 1 {
+		return 1
+	}
+	return price - discount
+}
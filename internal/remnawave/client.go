@@ -15,8 +15,32 @@ import (
 	"time"
 )
 
+// Panel is the Remnawave API as the bot uses it. Client implements it against a real panel,
+// tests point a Client at the fake panel of the remnawavetest package.
+type Panel interface {
+	Ping(ctx context.Context) error
+	GetUsers(ctx context.Context) (*[]remapi.UserDto, error)
+	CreateOrUpdateUser(ctx context.Context, customerId int64, telegramId int64, trafficLimit int, days int) (*remapi.UserDto, error)
+	ApplyPlan(ctx context.Context, customerId int64, telegramId int64, plan Plan) (*remapi.UserDto, error)
+	ShortenSubscription(ctx context.Context, telegramId int64, days int) (*remapi.UserDto, error)
+	FindUser(ctx context.Context, telegramId int64) (*remapi.UserDto, error)
+	AddTraffic(ctx context.Context, telegramId int64, bytes int) (*remapi.UserDto, error)
+	SetDeviceLimit(ctx context.Context, telegramId int64, limit *int) (*remapi.UserDto, error)
+	ResetTraffic(ctx context.Context, telegramId int64) (*remapi.UserDto, error)
+	DeviceCount(ctx context.Context, userUUID uuid.UUID) (int, error)
+}
+
+var _ Panel = (*Client)(nil)
+
 type Client struct {
-	client *remapi.Client
+	client       *remapi.Client
+	httpClient   *http.Client
+	baseURL      string
+	token        string
+	inboundUUIDs map[uuid.UUID]uuid.UUID
+	inbounds     *cachedList[uuid.UUID]
+	squads       *cachedList[Squad]
+	now          func() time.Time
 }
 
 type headerTransport struct {
@@ -40,7 +64,22 @@ func NewClient(baseURL, token, mode string) *Client {
 	if err != nil {
 		panic(err)
 	}
-	return &Client{client: remnawaveApi}
+	return &Client{
+		client:     remnawaveApi,
+		httpClient: client,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		inbounds:   &cachedList[uuid.UUID]{},
+		squads:     &cachedList[Squad]{},
+		now:        time.Now,
+	}
+}
+
+// NewPanelClient connects to a configured panel, new users get the inbounds configured for it.
+func NewPanelClient(panel config.RemnawavePanel) *Client {
+	client := NewClient(panel.URL, panel.Token, panel.Mode)
+	client.inboundUUIDs = panel.InboundUUIDs
+	return client
 }
 
 func (r *Client) Ping(ctx context.Context) error {
@@ -80,7 +119,183 @@ func (r *Client) GetUsers(ctx context.Context) (*[]remapi.UserDto, error) {
 	return &users, nil
 }
 
+// Plan is what a purchase grants on the panel. Zero values keep the panel defaults:
+// an empty TrafficStrategy means monthly reset, nil DeviceLimit, Inbounds and Squads keep the current settings
+// and new users get the inbounds configured for the panel. Squads are internal squad names or UUIDs.
+type Plan struct {
+	Days            int
+	TrafficLimit    int
+	TrafficStrategy string
+	DeviceLimit     *int
+	Inbounds        []uuid.UUID
+	Squads          []string
+}
+
 func (r *Client) CreateOrUpdateUser(ctx context.Context, customerId int64, telegramId int64, trafficLimit int, days int) (*remapi.UserDto, error) {
+	return r.ApplyPlan(ctx, customerId, telegramId, Plan{Days: days, TrafficLimit: trafficLimit})
+}
+
+// ApplyPlan creates the panel user of a customer or extends the existing one with the plan.
+// The squads of the plan replace the squads of the user, so switching plans moves the user between squads.
+func (r *Client) ApplyPlan(ctx context.Context, customerId int64, telegramId int64, plan Plan) (*remapi.UserDto, error) {
+	var squads []uuid.UUID
+	if plan.Squads != nil {
+		var err error
+		squads, err = r.resolveSquads(ctx, plan.Squads)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	existingUser, err := r.findUserByTelegramId(ctx, telegramId)
+	if err != nil {
+		return nil, err
+	}
+
+	if existingUser == nil {
+		user, err := r.createUser(ctx, customerId, telegramId, plan)
+		if err != nil || squads == nil {
+			return user, err
+		}
+		if err := r.assignSquads(ctx, user.UUID, telegramId, plan.Squads, squads); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	// squads go first, setting them again is harmless should the extension fail and be retried
+	if squads != nil {
+		if err := r.assignSquads(ctx, existingUser.UUID, telegramId, plan.Squads, squads); err != nil {
+			return nil, err
+		}
+	}
+	return r.updateUser(ctx, existingUser, plan)
+}
+
+func (r *Client) assignSquads(ctx context.Context, userUUID uuid.UUID, telegramId int64, names []string, squads []uuid.UUID) error {
+	if err := r.setSquads(ctx, userUUID, squads); err != nil {
+		return err
+	}
+	slog.Info("assigned user squads", "telegramId", utils.MaskHalfInt64(telegramId), "squads", names)
+	return nil
+}
+
+// ShortenSubscription moves the expiration of an existing user back by the given number of days.
+func (r *Client) ShortenSubscription(ctx context.Context, telegramId int64, days int) (*remapi.UserDto, error) {
+	existingUser, err := r.findUserByTelegramId(ctx, telegramId)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser == nil {
+		return nil, fmt.Errorf("user with telegram id %s not found", utils.MaskHalfInt64(telegramId))
+	}
+
+	newExpire := existingUser.ExpireAt.AddDate(0, 0, -days)
+
+	updateUser, err := r.client.UsersControllerUpdateUser(ctx, &remapi.UpdateUserRequestDto{
+		UUID:     existingUser.UUID,
+		ExpireAt: remapi.NewOptDateTime(newExpire),
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("shortened user subscription", "telegramId", utils.MaskHalfInt64(telegramId), "days", days)
+	return &updateUser.Response, nil
+}
+
+// FindUser returns the panel user of a customer, nil if there is none.
+func (r *Client) FindUser(ctx context.Context, telegramId int64) (*remapi.UserDto, error) {
+	return r.findUserByTelegramId(ctx, telegramId)
+}
+
+// AddTraffic raises the traffic limit of an existing user by the given bytes, negative values take traffic back.
+// The expiration is left as is.
+func (r *Client) AddTraffic(ctx context.Context, telegramId int64, bytes int) (*remapi.UserDto, error) {
+	existingUser, err := r.findUserByTelegramId(ctx, telegramId)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser == nil {
+		return nil, fmt.Errorf("user with telegram id %s not found", utils.MaskHalfInt64(telegramId))
+	}
+	currentLimit, ok := existingUser.TrafficLimitBytes.Get()
+	if !ok || currentLimit == 0 {
+		return nil, fmt.Errorf("user with telegram id %s has unlimited traffic", utils.MaskHalfInt64(telegramId))
+	}
+
+	newLimit := currentLimit + bytes
+	if newLimit < 1 {
+		newLimit = 1
+	}
+
+	updateUser, err := r.client.UsersControllerUpdateUser(ctx, &remapi.UpdateUserRequestDto{
+		UUID:              existingUser.UUID,
+		TrafficLimitBytes: remapi.NewOptInt(newLimit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("changed user traffic limit", "telegramId", utils.MaskHalfInt64(telegramId), "bytes", bytes)
+	return &updateUser.Response, nil
+}
+
+// SetDeviceLimit changes the HWID device limit of an existing user, nil restores the panel default.
+func (r *Client) SetDeviceLimit(ctx context.Context, telegramId int64, limit *int) (*remapi.UserDto, error) {
+	existingUser, err := r.findUserByTelegramId(ctx, telegramId)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser == nil {
+		return nil, fmt.Errorf("user with telegram id %s not found", utils.MaskHalfInt64(telegramId))
+	}
+
+	deviceLimit := remapi.OptNilInt{Set: true, Null: true}
+	if limit != nil {
+		deviceLimit = remapi.NewOptNilInt(*limit)
+	}
+
+	updateUser, err := r.client.UsersControllerUpdateUser(ctx, &remapi.UpdateUserRequestDto{
+		UUID:            existingUser.UUID,
+		HwidDeviceLimit: deviceLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("changed user device limit", "telegramId", utils.MaskHalfInt64(telegramId), "limit", limit)
+	return &updateUser.Response, nil
+}
+
+// ResetTraffic sets the used traffic of an existing user back to zero.
+func (r *Client) ResetTraffic(ctx context.Context, telegramId int64) (*remapi.UserDto, error) {
+	existingUser, err := r.findUserByTelegramId(ctx, telegramId)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser == nil {
+		return nil, fmt.Errorf("user with telegram id %s not found", utils.MaskHalfInt64(telegramId))
+	}
+
+	resetUser, err := r.client.UsersControllerResetUserTraffic(ctx, remapi.UsersControllerResetUserTrafficParams{UUID: existingUser.UUID.String()})
+	if err != nil {
+		return nil, err
+	}
+	switch v := resetUser.(type) {
+	case *remapi.ResetUserTrafficResponseDto:
+		// the reset response is its own type, the fields it changes are carried over to the user
+		existingUser.UsedTrafficBytes = v.Response.UsedTrafficBytes
+		existingUser.TrafficLimitBytes = v.Response.TrafficLimitBytes
+		existingUser.LastTrafficResetAt = v.Response.LastTrafficResetAt
+		existingUser.UpdatedAt = v.Response.UpdatedAt
+		slog.Info("reset user traffic", "telegramId", utils.MaskHalfInt64(telegramId))
+		return existingUser, nil
+	case *remapi.UsersControllerResetUserTrafficNotFound:
+		return nil, fmt.Errorf("user with telegram id %s not found", utils.MaskHalfInt64(telegramId))
+	default:
+		return nil, errors.New("unknown response type")
+	}
+}
+
+func (r *Client) findUserByTelegramId(ctx context.Context, telegramId int64) (*remapi.UserDto, error) {
 	resp, err := r.client.UsersControllerGetUserByTelegramId(ctx, remapi.UsersControllerGetUserByTelegramIdParams{TelegramId: strconv.FormatInt(telegramId, 10)})
 	if err != nil {
 		return nil, err
@@ -89,7 +304,7 @@ func (r *Client) CreateOrUpdateUser(ctx context.Context, customerId int64, teleg
 	switch v := resp.(type) {
 
 	case *remapi.UsersControllerGetUserByTelegramIdNotFound:
-		return r.createUser(ctx, customerId, telegramId, trafficLimit, days)
+		return nil, nil
 	case *remapi.UsersDto:
 		var existingUser *remapi.UserDto
 		for _, panelUser := range v.GetResponse() {
@@ -100,21 +315,30 @@ func (r *Client) CreateOrUpdateUser(ctx context.Context, customerId int64, teleg
 		if existingUser == nil {
 			existingUser = &v.GetResponse()[0]
 		}
-		return r.updateUser(ctx, existingUser, trafficLimit, days)
+		return existingUser, nil
 	default:
 		return nil, errors.New("unknown response type")
 	}
 }
 
-func (r *Client) updateUser(ctx context.Context, existingUser *remapi.UserDto, trafficLimit int, days int) (*remapi.UserDto, error) {
+func (r *Client) updateUser(ctx context.Context, existingUser *remapi.UserDto, plan Plan) (*remapi.UserDto, error) {
 
-	newExpire := getNewExpire(days, existingUser.ExpireAt)
+	newExpire := getNewExpire(plan.Days, existingUser.ExpireAt, r.now())
 
 	userUpdate := &remapi.UpdateUserRequestDto{
 		UUID:              existingUser.UUID,
 		ExpireAt:          remapi.NewOptDateTime(newExpire),
 		Status:            remapi.NewOptUpdateUserRequestDtoStatus(remapi.UpdateUserRequestDtoStatusACTIVE),
-		TrafficLimitBytes: remapi.NewOptInt(trafficLimit),
+		TrafficLimitBytes: remapi.NewOptInt(plan.TrafficLimit),
+	}
+	if plan.TrafficStrategy != "" {
+		userUpdate.TrafficLimitStrategy = remapi.NewOptUpdateUserRequestDtoTrafficLimitStrategy(remapi.UpdateUserRequestDtoTrafficLimitStrategy(plan.TrafficStrategy))
+	}
+	if plan.DeviceLimit != nil {
+		userUpdate.HwidDeviceLimit = remapi.NewOptNilInt(*plan.DeviceLimit)
+	}
+	if plan.Inbounds != nil {
+		userUpdate.ActiveUserInbounds = plan.Inbounds
 	}
 
 	var username string
@@ -130,41 +354,51 @@ func (r *Client) updateUser(ctx context.Context, existingUser *remapi.UserDto, t
 		return nil, err
 	}
 	tgid, _ := existingUser.TelegramId.Get()
-	slog.Info("updated user", "telegramId", utils.MaskHalf(strconv.Itoa(tgid)), "username", utils.MaskHalf(username), "days", days)
+	slog.Info("updated user", "telegramId", utils.MaskHalf(strconv.Itoa(tgid)), "username", utils.MaskHalf(username), "days", plan.Days)
 	return &updateUser.Response, nil
 }
 
-func (r *Client) createUser(ctx context.Context, customerId int64, telegramId int64, trafficLimit int, days int) (*remapi.UserDto, error) {
-	expireAt := time.Now().UTC().AddDate(0, 0, days)
+func (r *Client) createUser(ctx context.Context, customerId int64, telegramId int64, plan Plan) (*remapi.UserDto, error) {
+	expireAt := r.now().UTC().AddDate(0, 0, plan.Days)
 	username := generateUsername(customerId, telegramId)
 
-	resp, err := r.client.InboundsControllerGetInbounds(ctx)
-	if err != nil {
-		return nil, err
-	}
+	inboundsId := plan.Inbounds
+	if inboundsId == nil {
+		inbounds, err := r.panelInbounds(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-	inbounds := resp.GetResponse()
-	inboundsId := make([]uuid.UUID, 0, len(config.InboundUUIDs()))
-	for _, inbound := range inbounds {
-		if config.InboundUUIDs() != nil && len(config.InboundUUIDs()) > 0 {
-			if _, isExist := config.InboundUUIDs()[inbound.UUID]; !isExist {
-				continue
+		inboundsId = make([]uuid.UUID, 0, len(r.inboundUUIDs))
+		for _, inbound := range inbounds {
+			if r.inboundUUIDs != nil && len(r.inboundUUIDs) > 0 {
+				if _, isExist := r.inboundUUIDs[inbound]; !isExist {
+					continue
+				} else {
+					inboundsId = append(inboundsId, inbound)
+				}
 			} else {
-				inboundsId = append(inboundsId, inbound.UUID)
+				inboundsId = append(inboundsId, inbound)
 			}
-		} else {
-			inboundsId = append(inboundsId, inbound.UUID)
 		}
 	}
 
+	trafficStrategy := remapi.CreateUserRequestDtoTrafficLimitStrategyMONTH
+	if plan.TrafficStrategy != "" {
+		trafficStrategy = remapi.CreateUserRequestDtoTrafficLimitStrategy(plan.TrafficStrategy)
+	}
+
 	createUserRequestDto := remapi.CreateUserRequestDto{
 		Username:             username,
 		ActiveUserInbounds:   inboundsId,
 		Status:               remapi.NewOptCreateUserRequestDtoStatus(remapi.CreateUserRequestDtoStatusACTIVE),
 		TelegramId:           remapi.NewOptInt(int(telegramId)),
 		ExpireAt:             expireAt,
-		TrafficLimitStrategy: remapi.CreateUserRequestDtoTrafficLimitStrategyMONTH,
-		TrafficLimitBytes:    remapi.NewOptInt(trafficLimit),
+		TrafficLimitStrategy: trafficStrategy,
+		TrafficLimitBytes:    remapi.NewOptInt(plan.TrafficLimit),
+	}
+	if plan.DeviceLimit != nil {
+		createUserRequestDto.HwidDeviceLimit = remapi.NewOptInt(*plan.DeviceLimit)
 	}
 
 	var tgUsername string
@@ -179,21 +413,37 @@ func (r *Client) createUser(ctx context.Context, customerId int64, telegramId in
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("created user", "telegramId", utils.MaskHalf(strconv.FormatInt(telegramId, 10)), "username", utils.MaskHalf(tgUsername), "days", days)
+	slog.Info("created user", "telegramId", utils.MaskHalf(strconv.FormatInt(telegramId, 10)), "username", utils.MaskHalf(tgUsername), "days", plan.Days)
 	return &userCreate.Response, nil
 }
 
+// panelInbounds lists the inbounds of the panel, fetched at most once per catalogTTL.
+func (r *Client) panelInbounds(ctx context.Context) ([]uuid.UUID, error) {
+	return r.inbounds.get(r.now(), false, func() ([]uuid.UUID, error) {
+		resp, err := r.client.InboundsControllerGetInbounds(ctx)
+		if err != nil {
+			return nil, err
+		}
+		inbounds := make([]uuid.UUID, 0, len(resp.GetResponse()))
+		for _, inbound := range resp.GetResponse() {
+			inbounds = append(inbounds, inbound.UUID)
+		}
+		return inbounds, nil
+	})
+}
+
 func generateUsername(customerId int64, telegramId int64) string {
 	return fmt.Sprintf("%d_%d", customerId, telegramId)
 }
 
-func getNewExpire(daysToAdd int, currentExpire time.Time) time.Time {
+// getNewExpire extends an active subscription from its expiration, expired or new ones from now.
+func getNewExpire(daysToAdd int, currentExpire time.Time, now time.Time) time.Time {
 	if currentExpire.IsZero() {
-		return time.Now().UTC().AddDate(0, 0, daysToAdd)
+		return now.UTC().AddDate(0, 0, daysToAdd)
 	}
 
-	if currentExpire.Before(time.Now().UTC()) {
-		return time.Now().UTC().AddDate(0, 0, daysToAdd)
+	if currentExpire.Before(now.UTC()) {
+		return now.UTC().AddDate(0, 0, daysToAdd)
 	}
 
 	return currentExpire.AddDate(0, 0, daysToAdd)
@@ -0,0 +1,375 @@
+package remnawave
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"remnawave-tg-shop-bot/internal/remnawave/remnawavetest"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestClient(t *testing.T) (*Client, *remnawavetest.Server) {
+	t.Helper()
+	panel := remnawavetest.NewServer()
+	t.Cleanup(panel.Close)
+
+	client := NewClient(panel.URL, "token", "remote")
+	client.now = func() time.Time { return testNow }
+	return client, panel
+}
+
+func telegramId(id int64) *int64 {
+	return &id
+}
+
+func TestApplyPlanCreatesMissingUser(t *testing.T) {
+	client, panel := newTestClient(t)
+	first := panel.AddInbound("vless-reality")
+	second := panel.AddInbound("trojan")
+
+	user, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30, TrafficLimit: 1 << 30})
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+
+	if panel.Created() != 1 || panel.Updated() != 0 {
+		t.Fatalf("created %d and updated %d users, want 1 and 0", panel.Created(), panel.Updated())
+	}
+	stored, ok := panel.User("7_1001")
+	if !ok {
+		t.Fatalf("user 7_1001 not created, panel has %+v", panel.Users())
+	}
+	if stored.TelegramID == nil || *stored.TelegramID != 1001 {
+		t.Errorf("telegram id = %v, want 1001", stored.TelegramID)
+	}
+	if want := testNow.AddDate(0, 0, 30); !stored.ExpireAt.Equal(want) {
+		t.Errorf("expire at = %s, want %s", stored.ExpireAt, want)
+	}
+	if stored.TrafficLimitStrategy != "MONTH" {
+		t.Errorf("traffic strategy = %q, want MONTH", stored.TrafficLimitStrategy)
+	}
+	if len(stored.Inbounds) != 2 || stored.Inbounds[0] != first.UUID || stored.Inbounds[1] != second.UUID {
+		t.Errorf("inbounds = %v, want all panel inbounds", stored.Inbounds)
+	}
+	if user.Username != "7_1001" {
+		t.Errorf("returned username = %q, want 7_1001", user.Username)
+	}
+}
+
+func TestApplyPlanUpdatesExistingUser(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddUser(remnawavetest.User{
+		Username:   "7_1001",
+		TelegramID: telegramId(1001),
+		ExpireAt:   testNow.AddDate(0, 0, 5),
+		Status:     "DISABLED",
+	})
+
+	deviceLimit := 3
+	_, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30, TrafficLimit: 0, TrafficStrategy: "WEEK", DeviceLimit: &deviceLimit})
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+
+	if panel.Created() != 0 || panel.Updated() != 1 {
+		t.Fatalf("created %d and updated %d users, want 0 and 1", panel.Created(), panel.Updated())
+	}
+	stored, _ := panel.User("7_1001")
+	if want := testNow.AddDate(0, 0, 35); !stored.ExpireAt.Equal(want) {
+		t.Errorf("expire at = %s, want %s", stored.ExpireAt, want)
+	}
+	if stored.Status != "ACTIVE" {
+		t.Errorf("status = %q, want ACTIVE", stored.Status)
+	}
+	if stored.TrafficLimitStrategy != "WEEK" {
+		t.Errorf("traffic strategy = %q, want WEEK", stored.TrafficLimitStrategy)
+	}
+	if stored.HwidDeviceLimit == nil || *stored.HwidDeviceLimit != 3 {
+		t.Errorf("device limit = %v, want 3", stored.HwidDeviceLimit)
+	}
+}
+
+func TestApplyPlanPrefersUserWithTelegramIdSuffix(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddUser(remnawavetest.User{
+		Username:   "imported",
+		TelegramID: telegramId(1001),
+		ExpireAt:   testNow.AddDate(0, 0, 1),
+	})
+	panel.AddUser(remnawavetest.User{
+		Username:   "7_1001",
+		TelegramID: telegramId(1001),
+		ExpireAt:   testNow.AddDate(0, 0, 10),
+	})
+
+	_, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30})
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+
+	imported, _ := panel.User("imported")
+	if want := testNow.AddDate(0, 0, 1); !imported.ExpireAt.Equal(want) {
+		t.Errorf("user without the _1001 suffix was changed, expire at = %s", imported.ExpireAt)
+	}
+	own, _ := panel.User("7_1001")
+	if want := testNow.AddDate(0, 0, 40); !own.ExpireAt.Equal(want) {
+		t.Errorf("expire at = %s, want %s", own.ExpireAt, want)
+	}
+}
+
+func TestApplyPlanFallsBackToFirstUserOfTelegramId(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddUser(remnawavetest.User{
+		Username:   "first",
+		TelegramID: telegramId(1001),
+		ExpireAt:   testNow.AddDate(0, 0, 1),
+	})
+	panel.AddUser(remnawavetest.User{
+		Username:   "second",
+		TelegramID: telegramId(1001),
+		ExpireAt:   testNow.AddDate(0, 0, 1),
+	})
+
+	_, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30})
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+
+	first, _ := panel.User("first")
+	if want := testNow.AddDate(0, 0, 31); !first.ExpireAt.Equal(want) {
+		t.Errorf("first user expire at = %s, want %s", first.ExpireAt, want)
+	}
+	if panel.Created() != 0 || panel.Updated() != 1 {
+		t.Errorf("created %d and updated %d users, want 0 and 1", panel.Created(), panel.Updated())
+	}
+}
+
+func TestApplyPlanAssignsSquadsOnCreate(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddInbound("vless-reality")
+	premium := panel.AddSquad("premium")
+	streaming := panel.AddSquad("streaming")
+
+	_, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30, Squads: []string{"premium", streaming.UUID.String()}})
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+
+	stored, _ := panel.User("7_1001")
+	if len(stored.Squads) != 2 || stored.Squads[0] != premium.UUID || stored.Squads[1] != streaming.UUID {
+		t.Errorf("squads = %v, want premium and streaming", stored.Squads)
+	}
+}
+
+func TestApplyPlanSwitchesSquadsOnUpdate(t *testing.T) {
+	client, panel := newTestClient(t)
+	basic := panel.AddSquad("basic")
+	premium := panel.AddSquad("premium")
+	panel.AddUser(remnawavetest.User{
+		Username:   "7_1001",
+		TelegramID: telegramId(1001),
+		ExpireAt:   testNow.AddDate(0, 0, 5),
+		Squads:     []uuid.UUID{basic.UUID},
+	})
+
+	_, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30, Squads: []string{"premium"}})
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+
+	stored, _ := panel.User("7_1001")
+	if len(stored.Squads) != 1 || stored.Squads[0] != premium.UUID {
+		t.Errorf("squads = %v, want only premium", stored.Squads)
+	}
+	if want := testNow.AddDate(0, 0, 35); !stored.ExpireAt.Equal(want) {
+		t.Errorf("expire at = %s, want %s", stored.ExpireAt, want)
+	}
+}
+
+func TestApplyPlanKeepsSquadsWithoutPlanSquads(t *testing.T) {
+	client, panel := newTestClient(t)
+	basic := panel.AddSquad("basic")
+	panel.AddUser(remnawavetest.User{
+		Username:   "7_1001",
+		TelegramID: telegramId(1001),
+		ExpireAt:   testNow,
+		Squads:     []uuid.UUID{basic.UUID},
+	})
+
+	_, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30})
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+
+	stored, _ := panel.User("7_1001")
+	if len(stored.Squads) != 1 || stored.Squads[0] != basic.UUID {
+		t.Errorf("squads = %v, want basic kept", stored.Squads)
+	}
+	if panel.Requests("GET /api/internal-squads") != 0 {
+		t.Errorf("squads were listed for a plan without squads")
+	}
+}
+
+func TestApplyPlanRejectsUnknownSquad(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddSquad("basic")
+
+	_, err := client.ApplyPlan(context.Background(), 7, 1001, Plan{Days: 30, Squads: []string{"premium"}})
+	if err == nil {
+		t.Fatal("ApplyPlan succeeded with an unknown squad")
+	}
+	if panel.Created() != 0 {
+		t.Errorf("created %d users, want none", panel.Created())
+	}
+}
+
+func TestApplyPlanCachesInboundsAndSquads(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddInbound("vless-reality")
+	panel.AddSquad("premium")
+
+	for customerId := int64(1); customerId <= 3; customerId++ {
+		_, err := client.ApplyPlan(context.Background(), customerId, 1000+customerId, Plan{Days: 30, Squads: []string{"premium"}})
+		if err != nil {
+			t.Fatalf("ApplyPlan: %v", err)
+		}
+	}
+
+	if got := panel.Requests("GET /api/inbounds"); got != 1 {
+		t.Errorf("inbounds listed %d times, want 1", got)
+	}
+	if got := panel.Requests("GET /api/internal-squads"); got != 1 {
+		t.Errorf("squads listed %d times, want 1", got)
+	}
+}
+
+func TestResolveSquadsRefetchesMissingSquad(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddSquad("basic")
+
+	if _, err := client.resolveSquads(context.Background(), []string{"basic"}); err != nil {
+		t.Fatalf("resolveSquads: %v", err)
+	}
+	premium := panel.AddSquad("premium")
+
+	ids, err := client.resolveSquads(context.Background(), []string{"premium"})
+	if err != nil {
+		t.Fatalf("resolveSquads: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != premium.UUID {
+		t.Errorf("ids = %v, want premium", ids)
+	}
+	if got := panel.Requests("GET /api/internal-squads"); got != 2 {
+		t.Errorf("squads listed %d times, want 2", got)
+	}
+
+	client.now = func() time.Time { return testNow.Add(catalogTTL) }
+	if _, err := client.resolveSquads(context.Background(), []string{"basic"}); err != nil {
+		t.Fatalf("resolveSquads: %v", err)
+	}
+	if got := panel.Requests("GET /api/internal-squads"); got != 3 {
+		t.Errorf("squads listed %d times after the ttl, want 3", got)
+	}
+}
+
+func TestAddTrafficRaisesLimit(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddUser(remnawavetest.User{Username: "7_1001", TelegramID: telegramId(1001), ExpireAt: testNow, TrafficLimitBytes: 1 << 30})
+
+	user, err := client.AddTraffic(context.Background(), 1001, 1<<30)
+	if err != nil {
+		t.Fatalf("AddTraffic: %v", err)
+	}
+	if limit, _ := user.TrafficLimitBytes.Get(); limit != 2<<30 {
+		t.Errorf("returned traffic limit = %d, want %d", limit, 2<<30)
+	}
+	if stored, _ := panel.User("7_1001"); stored.TrafficLimitBytes != 2<<30 {
+		t.Errorf("stored traffic limit = %d, want %d", stored.TrafficLimitBytes, 2<<30)
+	}
+}
+
+func TestAddTrafficRejectsUnlimitedUser(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddUser(remnawavetest.User{Username: "7_1001", TelegramID: telegramId(1001), ExpireAt: testNow})
+
+	if _, err := client.AddTraffic(context.Background(), 1001, 1<<30); err == nil {
+		t.Error("AddTraffic of an unlimited user succeeded, want an error")
+	}
+	if panel.Updated() != 0 {
+		t.Errorf("updated %d users, want 0", panel.Updated())
+	}
+}
+
+func TestResetTraffic(t *testing.T) {
+	client, panel := newTestClient(t)
+	panel.AddUser(remnawavetest.User{Username: "7_1001", TelegramID: telegramId(1001), ExpireAt: testNow, TrafficLimitBytes: 1 << 30, UsedTrafficBytes: 1 << 29})
+
+	user, err := client.ResetTraffic(context.Background(), 1001)
+	if err != nil {
+		t.Fatalf("ResetTraffic: %v", err)
+	}
+	if user.UsedTrafficBytes != 0 {
+		t.Errorf("returned used traffic = %v, want 0", user.UsedTrafficBytes)
+	}
+	if limit, _ := user.TrafficLimitBytes.Get(); limit != 1<<30 {
+		t.Errorf("returned traffic limit = %d, want %d", limit, 1<<30)
+	}
+	if stored, _ := panel.User("7_1001"); stored.UsedTrafficBytes != 0 {
+		t.Errorf("stored used traffic = %v, want 0", stored.UsedTrafficBytes)
+	}
+}
+
+func TestDeviceCount(t *testing.T) {
+	client, panel := newTestClient(t)
+	user := panel.AddUser(remnawavetest.User{Username: "7_1001", ExpireAt: testNow, Devices: 2})
+
+	count, err := client.DeviceCount(context.Background(), user.UUID)
+	if err != nil {
+		t.Fatalf("DeviceCount: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("device count = %d, want 2", count)
+	}
+
+	if _, err := client.DeviceCount(context.Background(), uuid.New()); err == nil {
+		t.Error("DeviceCount of an unknown user succeeded, want an error")
+	}
+}
+
+func TestGetUsersPagesThroughAllUsers(t *testing.T) {
+	client, panel := newTestClient(t)
+	for i := 0; i < 260; i++ {
+		panel.AddUser(remnawavetest.User{ExpireAt: testNow})
+	}
+
+	users, err := client.GetUsers(context.Background())
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(*users) != 260 {
+		t.Errorf("got %d users, want 260", len(*users))
+	}
+}
+
+func TestGetNewExpire(t *testing.T) {
+	tests := []struct {
+		name    string
+		current time.Time
+		want    time.Time
+	}{
+		{"new user starts now", time.Time{}, testNow.AddDate(0, 0, 30)},
+		{"expired user starts now", testNow.AddDate(0, 0, -3), testNow.AddDate(0, 0, 30)},
+		{"active user is extended", testNow.AddDate(0, 0, 3), testNow.AddDate(0, 0, 33)},
+		{"expiring this instant starts from it", testNow, testNow.AddDate(0, 0, 30)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getNewExpire(30, tt.current, testNow); !got.Equal(tt.want) {
+				t.Errorf("getNewExpire = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
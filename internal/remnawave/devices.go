@@ -0,0 +1,22 @@
+package remnawave
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// DeviceCount is the number of HWID devices connected by a user. Like squads, the devices endpoint
+// is newer than the generated API client and is requested as plain JSON.
+func (r *Client) DeviceCount(ctx context.Context, userUUID uuid.UUID) (int, error) {
+	var resp struct {
+		Response struct {
+			Total int `json:"total"`
+		} `json:"response"`
+	}
+	if err := r.doJSON(ctx, http.MethodGet, "/api/hwid/devices/"+userUUID.String(), nil, &resp); err != nil {
+		return 0, fmt.Errorf("failed to list hwid devices: %w", err)
+	}
+	return resp.Response.Total, nil
+}
@@ -0,0 +1,48 @@
+package remnawave
+
+import (
+	"log/slog"
+)
+
+// Panels routes customers to the panel they live on. Customers without a panel, or on a panel
+// that is no longer configured, live on the first one added, the default panel.
+type Panels struct {
+	names  []string
+	panels map[string]Panel
+}
+
+func NewPanels() *Panels {
+	return &Panels{panels: make(map[string]Panel)}
+}
+
+func (p *Panels) Add(name string, panel Panel) {
+	if _, exists := p.panels[name]; !exists {
+		p.names = append(p.names, name)
+	}
+	p.panels[name] = panel
+}
+
+// Names are the panel names in the order they were added.
+func (p *Panels) Names() []string {
+	return p.names
+}
+
+func (p *Panels) Default() string {
+	return p.names[0]
+}
+
+// Resolve returns the name of the panel a customer with the given panel lives on.
+func (p *Panels) Resolve(name *string) string {
+	if name == nil {
+		return p.Default()
+	}
+	if _, ok := p.panels[*name]; !ok {
+		slog.Warn("panel is not configured, using the default panel", "panel", *name)
+		return p.Default()
+	}
+	return *name
+}
+
+func (p *Panels) Get(name *string) Panel {
+	return p.panels[p.Resolve(name)]
+}
@@ -0,0 +1,481 @@
+// Package remnawavetest provides an in-process fake Remnawave panel for tests.
+package remnawavetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is a panel user as the fake panel stores it.
+type User struct {
+	UUID                 uuid.UUID
+	Username             string
+	Status               string
+	TelegramID           *int64
+	ExpireAt             time.Time
+	TrafficLimitBytes    int
+	TrafficLimitStrategy string
+	UsedTrafficBytes     float64
+	HwidDeviceLimit      *int
+	Devices              int
+	OnlineAt             *time.Time
+	Description          *string
+	Inbounds             []uuid.UUID
+	Squads               []uuid.UUID
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+type Inbound struct {
+	UUID uuid.UUID
+	Tag  string
+	Type string
+	Port int
+}
+
+type Squad struct {
+	UUID uuid.UUID
+	Name string
+}
+
+// Server serves the user, inbound, internal squad, hwid device and by-telegram-id endpoints of the Remnawave API from memory.
+// Users are listed in the order they were added.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	users    []*User
+	inbounds []Inbound
+	squads   []Squad
+	created  int
+	updated  int
+	requests map[string]int
+}
+
+func NewServer() *Server {
+	s := &Server{requests: make(map[string]int)}
+	mux := http.NewServeMux()
+	s.handle(mux, "GET /api/users", s.getUsers)
+	s.handle(mux, "POST /api/users", s.createUser)
+	s.handle(mux, "PATCH /api/users", s.updateUser)
+	s.handle(mux, "GET /api/users/by-telegram-id/{telegramId}", s.getUsersByTelegramId)
+	s.handle(mux, "POST /api/users/{uuid}/actions/reset-traffic", s.resetTraffic)
+	s.handle(mux, "GET /api/inbounds", s.getInbounds)
+	s.handle(mux, "GET /api/internal-squads", s.getSquads)
+	s.handle(mux, "GET /api/hwid/devices/{userUuid}", s.getDevices)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[pattern]++
+		s.mu.Unlock()
+		handler(w, r)
+	})
+}
+
+// Requests counts the requests served for a route pattern, e.g. "GET /api/inbounds".
+func (s *Server) Requests(pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[pattern]
+}
+
+// AddUser stores a user, a missing UUID, status, strategy or creation time is filled in.
+func (s *Server) AddUser(user User) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.addUser(user)
+}
+
+func (s *Server) addUser(user User) *User {
+	if user.UUID == uuid.Nil {
+		user.UUID = uuid.New()
+	}
+	if user.Status == "" {
+		user.Status = "ACTIVE"
+	}
+	if user.TrafficLimitStrategy == "" {
+		user.TrafficLimitStrategy = "NO_RESET"
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now().UTC()
+		user.UpdatedAt = user.CreatedAt
+	}
+	s.users = append(s.users, &user)
+	return &user
+}
+
+func (s *Server) AddInbound(tag string) Inbound {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inbound := Inbound{UUID: uuid.New(), Tag: tag, Type: "vless", Port: 443 + len(s.inbounds)}
+	s.inbounds = append(s.inbounds, inbound)
+	return inbound
+}
+
+func (s *Server) AddSquad(name string) Squad {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	squad := Squad{UUID: uuid.New(), Name: name}
+	s.squads = append(s.squads, squad)
+	return squad
+}
+
+// Users returns copies of the stored users.
+func (s *Server) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, *user)
+	}
+	return users
+}
+
+// User returns a copy of the stored user with the username.
+func (s *Server) User(username string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Username == username {
+			return *user, true
+		}
+	}
+	return User{}, false
+}
+
+// Created and Updated count the users created and updated through the API.
+func (s *Server) Created() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.created
+}
+
+func (s *Server) Updated() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updated
+}
+
+func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the generated client sends the paging parameters as floats, e.g. 250.0000000000
+	start, _ := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+	size, err := strconv.ParseFloat(r.URL.Query().Get("size"), 64)
+	if err != nil || size <= 0 {
+		size = 25
+	}
+
+	page := make([]map[string]any, 0, int(size))
+	for i := int(start); i < len(s.users) && i < int(start+size); i++ {
+		page = append(page, s.userJSON(s.users[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"response": map[string]any{
+			"users": page,
+			"total": len(s.users),
+		},
+	})
+}
+
+func (s *Server) getUsersByTelegramId(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	telegramId, err := strconv.ParseInt(r.PathValue("telegramId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid telegram id")
+		return
+	}
+
+	var users []map[string]any
+	for _, user := range s.users {
+		if user.TelegramID != nil && *user.TelegramID == telegramId {
+			users = append(users, s.userJSON(user))
+		}
+	}
+	if len(users) == 0 {
+		writeError(w, http.StatusNotFound, "Users not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"response": users})
+}
+
+type createUserRequest struct {
+	Username             string      `json:"username"`
+	Status               string      `json:"status"`
+	TelegramId           *int64      `json:"telegramId"`
+	ExpireAt             time.Time   `json:"expireAt"`
+	TrafficLimitBytes    int         `json:"trafficLimitBytes"`
+	TrafficLimitStrategy string      `json:"trafficLimitStrategy"`
+	HwidDeviceLimit      *int        `json:"hwidDeviceLimit"`
+	Description          *string     `json:"description"`
+	ActiveUserInbounds   []uuid.UUID `json:"activeUserInbounds"`
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Username == req.Username {
+			writeError(w, http.StatusBadRequest, "User username already exists")
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	user := s.addUser(User{
+		Username:             req.Username,
+		Status:               req.Status,
+		TelegramID:           req.TelegramId,
+		ExpireAt:             req.ExpireAt,
+		TrafficLimitBytes:    req.TrafficLimitBytes,
+		TrafficLimitStrategy: req.TrafficLimitStrategy,
+		HwidDeviceLimit:      req.HwidDeviceLimit,
+		Description:          req.Description,
+		Inbounds:             req.ActiveUserInbounds,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	})
+	s.created++
+
+	writeJSON(w, http.StatusCreated, map[string]any{"response": s.userJSON(user)})
+}
+
+// updateUser applies the fields present in the body, a null hwidDeviceLimit clears the limit.
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var id uuid.UUID
+	if err := json.Unmarshal(fields["uuid"], &id); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid uuid")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var user *User
+	for _, candidate := range s.users {
+		if candidate.UUID == id {
+			user = candidate
+		}
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	targets := map[string]any{
+		"status":               &user.Status,
+		"expireAt":             &user.ExpireAt,
+		"trafficLimitBytes":    &user.TrafficLimitBytes,
+		"trafficLimitStrategy": &user.TrafficLimitStrategy,
+		"hwidDeviceLimit":      &user.HwidDeviceLimit,
+		"description":          &user.Description,
+		"activeUserInbounds":   &user.Inbounds,
+		"activeInternalSquads": &user.Squads,
+	}
+	for field, target := range targets {
+		raw, ok := fields[field]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s", field))
+			return
+		}
+	}
+	user.UpdatedAt = time.Now().UTC()
+	s.updated++
+
+	writeJSON(w, http.StatusOK, map[string]any{"response": s.userJSON(user)})
+}
+
+func (s *Server) resetTraffic(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("uuid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid uuid")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.UUID == id {
+			user.UsedTrafficBytes = 0
+			user.UpdatedAt = time.Now().UTC()
+			writeJSON(w, http.StatusOK, map[string]any{"response": s.userJSON(user)})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "User not found")
+}
+
+func (s *Server) getInbounds(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbounds := make([]map[string]any, 0, len(s.inbounds))
+	for _, inbound := range s.inbounds {
+		inbounds = append(inbounds, s.inboundJSON(inbound))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"response": inbounds})
+}
+
+func (s *Server) getSquads(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	squads := make([]map[string]any, 0, len(s.squads))
+	for _, squad := range s.squads {
+		squads = append(squads, map[string]any{
+			"uuid": squad.UUID,
+			"name": squad.Name,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"response": map[string]any{
+			"total":          len(squads),
+			"internalSquads": squads,
+		},
+	})
+}
+
+// getDevices lists User.Devices made up devices of a user.
+func (s *Server) getDevices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userUuid, err := uuid.Parse(r.PathValue("userUuid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user uuid")
+		return
+	}
+
+	var user *User
+	for _, candidate := range s.users {
+		if candidate.UUID == userUuid {
+			user = candidate
+		}
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	devices := make([]map[string]any, 0, user.Devices)
+	for i := 0; i < user.Devices; i++ {
+		devices = append(devices, map[string]any{
+			"hwid":      fmt.Sprintf("%s-%d", user.Username, i),
+			"userUuid":  user.UUID,
+			"platform":  "android",
+			"createdAt": user.CreatedAt.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"response": map[string]any{
+			"total":   len(devices),
+			"devices": devices,
+		},
+	})
+}
+
+func (s *Server) userJSON(user *User) map[string]any {
+	activeInbounds := make([]map[string]any, 0, len(user.Inbounds))
+	for _, id := range user.Inbounds {
+		inbound := Inbound{UUID: id, Tag: id.String(), Type: "vless"}
+		for _, known := range s.inbounds {
+			if known.UUID == id {
+				inbound = known
+			}
+		}
+		activeInbounds = append(activeInbounds, s.inboundJSON(inbound))
+	}
+
+	var onlineAt any
+	if user.OnlineAt != nil {
+		onlineAt = user.OnlineAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	shortUuid := strings.ReplaceAll(user.UUID.String(), "-", "")[:16]
+	return map[string]any{
+		"uuid":                     user.UUID,
+		"subscriptionUuid":         user.UUID,
+		"shortUuid":                shortUuid,
+		"username":                 user.Username,
+		"status":                   user.Status,
+		"usedTrafficBytes":         user.UsedTrafficBytes,
+		"lifetimeUsedTrafficBytes": user.UsedTrafficBytes,
+		"trafficLimitBytes":        user.TrafficLimitBytes,
+		"trafficLimitStrategy":     user.TrafficLimitStrategy,
+		"subLastUserAgent":         nil,
+		"subLastOpenedAt":          nil,
+		"expireAt":                 user.ExpireAt.UTC().Format(time.RFC3339Nano),
+		"onlineAt":                 onlineAt,
+		"subRevokedAt":             nil,
+		"lastTrafficResetAt":       nil,
+		"trojanPassword":           shortUuid,
+		"vlessUuid":                user.UUID,
+		"ssPassword":               shortUuid,
+		"description":              user.Description,
+		"tag":                      nil,
+		"telegramId":               user.TelegramID,
+		"email":                    nil,
+		"hwidDeviceLimit":          user.HwidDeviceLimit,
+		"createdAt":                user.CreatedAt.UTC().Format(time.RFC3339Nano),
+		"updatedAt":                user.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		"activeUserInbounds":       activeInbounds,
+		"subscriptionUrl":          fmt.Sprintf("%s/api/sub/%s", s.URL, shortUuid),
+		"lastConnectedNode":        nil,
+		"happ":                     map[string]any{"cryptoLink": "happ://crypt/" + shortUuid},
+	}
+}
+
+func (s *Server) inboundJSON(inbound Inbound) map[string]any {
+	return map[string]any{
+		"uuid":     inbound.UUID,
+		"tag":      inbound.Tag,
+		"type":     inbound.Type,
+		"port":     inbound.Port,
+		"network":  "tcp",
+		"security": "reality",
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"statusCode": status,
+		"message":    message,
+		"errorCode":  "A000",
+	})
+}
@@ -0,0 +1,156 @@
+package remnawave
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catalogTTL is how long the inbound and squad lists of a panel are cached.
+const catalogTTL = 10 * time.Minute
+
+// Squad is an internal squad of the panel, a named set of inbounds users are granted access to.
+// The generated API client predates squads, they are managed through plain JSON requests.
+type Squad struct {
+	UUID uuid.UUID `json:"uuid"`
+	Name string    `json:"name"`
+}
+
+// cachedList is a panel list fetched at most once per catalogTTL.
+type cachedList[T any] struct {
+	mu        sync.Mutex
+	items     []T
+	fetchedAt time.Time
+}
+
+// get returns the cached items, fetching them when they are missing, older than catalogTTL or refresh is set.
+func (c *cachedList[T]) get(now time.Time, refresh bool, fetch func() ([]T, error)) ([]T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !refresh && c.items != nil && now.Sub(c.fetchedAt) < catalogTTL {
+		return c.items, nil
+	}
+
+	items, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.items = items
+	c.fetchedAt = now
+	return items, nil
+}
+
+// panelSquads lists the internal squads of the panel, fetched at most once per catalogTTL unless refresh is set.
+func (r *Client) panelSquads(ctx context.Context, refresh bool) ([]Squad, error) {
+	return r.squads.get(r.now(), refresh, func() ([]Squad, error) {
+		var resp struct {
+			Response struct {
+				InternalSquads []Squad `json:"internalSquads"`
+			} `json:"response"`
+		}
+		if err := r.doJSON(ctx, http.MethodGet, "/api/internal-squads", nil, &resp); err != nil {
+			return nil, fmt.Errorf("failed to list internal squads: %w", err)
+		}
+		squads := resp.Response.InternalSquads
+		if squads == nil {
+			squads = []Squad{}
+		}
+		return squads, nil
+	})
+}
+
+// resolveSquads maps squad names or UUIDs to the UUIDs of the panel. Squads missing from the cached list
+// are looked up again once, so squads created since the list was fetched are found.
+func (r *Client) resolveSquads(ctx context.Context, refs []string) ([]uuid.UUID, error) {
+	ids, missing, err := r.lookupSquads(ctx, refs, false)
+	if err != nil {
+		return nil, err
+	}
+	if missing != "" {
+		ids, missing, err = r.lookupSquads(ctx, refs, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if missing != "" {
+		return nil, fmt.Errorf("internal squad %q not found on the panel", missing)
+	}
+	return ids, nil
+}
+
+func (r *Client) lookupSquads(ctx context.Context, refs []string, refresh bool) ([]uuid.UUID, string, error) {
+	squads, err := r.panelSquads(ctx, refresh)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids := make([]uuid.UUID, 0, len(refs))
+	for _, ref := range refs {
+		found := false
+		for _, squad := range squads {
+			if squad.UUID.String() == strings.ToLower(ref) || squad.Name == ref {
+				ids = append(ids, squad.UUID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ref, nil
+		}
+	}
+	return ids, "", nil
+}
+
+// setSquads replaces the internal squads of a user.
+func (r *Client) setSquads(ctx context.Context, userUUID uuid.UUID, squads []uuid.UUID) error {
+	body := map[string]any{
+		"uuid":                 userUUID,
+		"activeInternalSquads": squads,
+	}
+	if err := r.doJSON(ctx, http.MethodPatch, "/api/users", body, nil); err != nil {
+		return fmt.Errorf("failed to set internal squads: %w", err)
+	}
+	return nil
+}
+
+func (r *Client) doJSON(ctx context.Context, method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -9,13 +9,13 @@ import (
 )
 
 type SyncService struct {
-	client             *remnawave.Client
+	panels             *remnawave.Panels
 	customerRepository *database.CustomerRepository
 }
 
-func NewSyncService(client *remnawave.Client, customerRepository *database.CustomerRepository) *SyncService {
+func NewSyncService(panels *remnawave.Panels, customerRepository *database.CustomerRepository) *SyncService {
 	return &SyncService{
-		client: client, customerRepository: customerRepository,
+		panels: panels, customerRepository: customerRepository,
 	}
 }
 
@@ -23,35 +23,46 @@ func (s SyncService) Sync() {
 	slog.Info("Starting sync")
 	ctx := context.Background()
 	var telegramIDs []int64
-	telegramIDsSet := make(map[int64]int64)
-	var mappedUsers []database.Customer
-	users, err := s.client.GetUsers(ctx)
-	if err != nil {
-		slog.Error("Error while getting users from remnawave")
-		return
-	}
-	if users == nil || len(*users) == 0 {
-		slog.Error("No users found in remnawave")
-		return
-	}
-
-	for _, user := range *users {
-		if user.TelegramId.Null {
-			continue
-		}
-		if _, exists := telegramIDsSet[int64(user.TelegramId.Value)]; exists {
-			continue
+	mappedUsers := make(map[int64]database.Customer)
+	for _, name := range s.panels.Names() {
+		panel := name
+		users, err := s.panels.Get(&panel).GetUsers(ctx)
+		if err != nil {
+			slog.Error("Error while getting users from remnawave", "panel", panel)
+			return
 		}
 
-		telegramIDsSet[int64(user.TelegramId.Value)] = int64(user.TelegramId.Value)
+		seen := make(map[int64]bool)
+		for _, user := range *users {
+			if user.TelegramId.Null {
+				continue
+			}
+			telegramID := int64(user.TelegramId.Value)
+			if seen[telegramID] {
+				continue
+			}
+			seen[telegramID] = true
 
-		telegramIDs = append(telegramIDs, int64(user.TelegramId.Value))
+			// a customer who moved between panels keeps the user with the latest expiration
+			existing, exists := mappedUsers[telegramID]
+			if exists && !user.ExpireAt.After(*existing.ExpireAt) {
+				continue
+			}
+			if !exists {
+				telegramIDs = append(telegramIDs, telegramID)
+			}
 
-		mappedUsers = append(mappedUsers, database.Customer{
-			TelegramID:       int64(user.TelegramId.Value),
-			ExpireAt:         &user.ExpireAt,
-			SubscriptionLink: &user.SubscriptionUrl,
-		})
+			mappedUsers[telegramID] = database.Customer{
+				TelegramID:       telegramID,
+				ExpireAt:         &user.ExpireAt,
+				SubscriptionLink: &user.SubscriptionUrl,
+				Panel:            &panel,
+			}
+		}
+	}
+	if len(mappedUsers) == 0 {
+		slog.Error("No users found in remnawave")
+		return
 	}
 
 	existingCustomers, err := s.customerRepository.FindByTelegramIds(ctx, telegramIDs)
@@ -67,7 +78,8 @@ func (s SyncService) Sync() {
 	var toCreate []database.Customer
 	var toUpdate []database.Customer
 
-	for _, cust := range mappedUsers {
+	for _, telegramID := range telegramIDs {
+		cust := mappedUsers[telegramID]
 		if _, found := existingMap[cust.TelegramID]; found {
 			cust.CreatedAt = time.Now()
 			toUpdate = append(toUpdate, cust)
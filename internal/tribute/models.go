@@ -2,6 +2,12 @@ package tribute
 
 import "time"
 
+const (
+	EventNewSubscription       = "new_subscription"
+	EventRenewedSubscription   = "renewed_subscription"
+	EventCancelledSubscription = "cancelled_subscription"
+)
+
 type SubscriptionWebhook struct {
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
@@ -22,4 +28,5 @@ type Payload struct {
 	ChannelID        int       `json:"channel_id"`
 	ChannelName      string    `json:"channel_name"`
 	ExpiresAt        time.Time `json:"expires_at"`
+	CancelReason     string    `json:"cancel_reason,omitempty"`
 }
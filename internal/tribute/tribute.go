@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"log/slog"
@@ -13,23 +14,58 @@ import (
 	"remnawave-tg-shop-bot/internal/config"
 	"remnawave-tg-shop-bot/internal/database"
 	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/utils"
 	"strings"
 	"time"
 )
 
 type Client struct {
-	paymentService     *payment.PaymentService
 	customerRepository *database.CustomerRepository
+	purchaseRepository *database.PurchaseRepository
 }
 
-func NewClient(paymentService *payment.PaymentService, customerRepository *database.CustomerRepository) *Client {
+func NewClient(customerRepository *database.CustomerRepository, purchaseRepository *database.PurchaseRepository) *Client {
 	return &Client{
-		paymentService:     paymentService,
 		customerRepository: customerRepository,
+		purchaseRepository: purchaseRepository,
 	}
 }
 
-func (c *Client) WebHookHandler() http.Handler {
+func (c *Client) Type() database.InvoiceType {
+	return database.InvoiceTypeTribute
+}
+
+func (c *Client) ButtonKey() string {
+	return "tribute_button"
+}
+
+// Currencies only matter for the menu, Tribute webhooks carry the currency that was paid.
+func (c *Client) Currencies() []string {
+	return []string{payment.CurrencyRUB}
+}
+
+func (c *Client) PaymentURL() string {
+	return config.GetTributePaymentUrl()
+}
+
+func (c *Client) WebhookPath() string {
+	return config.GetTributeWebHookUrl()
+}
+
+// CreateInvoice issues nothing, Tribute subscriptions are paid through PaymentURL and reported by the webhook.
+func (c *Client) CreateInvoice(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*payment.Invoice, error) {
+	return &payment.Invoice{}, nil
+}
+
+func (c *Client) CheckStatus(ctx context.Context, purchase *database.Purchase) (*payment.InvoiceState, error) {
+	return nil, payment.ErrStatusCheckUnsupported
+}
+
+func (c *Client) Cancel(ctx context.Context, purchase *database.Purchase) error {
+	return nil
+}
+
+func (c *Client) WebhookHandler(processor payment.Processor) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), time.Second*60)
 		defer cancel()
@@ -65,25 +101,17 @@ func (c *Client) WebHookHandler() http.Handler {
 			return
 		}
 
-		if wh.Name != "new_subscription" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		months := convertPeriodToMonths(wh.Payload.Period)
-
-		customer, err := c.customerRepository.FindByTelegramId(ctx, wh.Payload.TelegramUserID)
-		_, purchaseId, err := c.paymentService.CreatePurchase(ctx, wh.Payload.Amount, months, customer, database.InvoiceTypeTribute)
-
-		if err != nil {
-			slog.Error("webhook: create purchase error", "error", err, "payload", string(body))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
+		switch wh.Name {
+		case EventNewSubscription, EventRenewedSubscription:
+			err = c.handleSubscriptionPayment(ctx, processor, &wh.Payload)
+		case EventCancelledSubscription:
+			err = c.handleCancellation(ctx, &wh.Payload)
+		default:
+			slog.Info("webhook: skipping event", "event", wh.Name)
 		}
 
-		err = c.paymentService.ProcessPurchaseById(ctx, purchaseId)
 		if err != nil {
-			slog.Error("webhook: process purchase error", "error", err, "payload", string(body))
+			slog.Error("webhook: processing error", "event", wh.Name, "error", err, "payload", string(body))
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -92,6 +120,108 @@ func (c *Client) WebHookHandler() http.Handler {
 	})
 }
 
+// handleSubscriptionPayment grants the plan of a paid subscription period, both for the first payment and renewals.
+func (c *Client) handleSubscriptionPayment(ctx context.Context, processor payment.Processor, payload *Payload) error {
+	customer, err := c.findOrCreateCustomer(ctx, payload.TelegramUserID)
+	if err != nil {
+		return err
+	}
+
+	purchaseId, err := c.findOrCreatePurchase(ctx, processor, customer, payload)
+	if err != nil {
+		return err
+	}
+
+	subscriptionID := int64(payload.SubscriptionID)
+	err = c.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+		"tribute_subscription_id": subscriptionID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return processor.ProcessPurchaseById(ctx, purchaseId)
+}
+
+// handleCancellation forgets the Tribute subscription, the period already paid for stays active until it expires.
+func (c *Client) handleCancellation(ctx context.Context, payload *Payload) error {
+	customer, err := c.customerRepository.FindByTelegramId(ctx, payload.TelegramUserID)
+	if err != nil {
+		return err
+	}
+	if customer == nil || customer.TributeSubID == nil || *customer.TributeSubID != int64(payload.SubscriptionID) {
+		return nil
+	}
+
+	err = c.customerRepository.UpdateFields(ctx, customer.ID, map[string]interface{}{
+		"tribute_subscription_id": nil,
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("tribute subscription cancelled", "customer_id", utils.MaskHalfInt64(customer.ID), "reason", payload.CancelReason, "expires_at", payload.ExpiresAt)
+	return nil
+}
+
+// findOrCreateCustomer registers users who subscribed on Tribute without starting the bot first.
+func (c *Client) findOrCreateCustomer(ctx context.Context, telegramId int64) (*database.Customer, error) {
+	customer, err := c.customerRepository.FindByTelegramId(ctx, telegramId)
+	if err != nil {
+		return nil, err
+	}
+	if customer != nil {
+		return customer, nil
+	}
+
+	return c.customerRepository.Create(ctx, &database.Customer{
+		TelegramID: telegramId,
+	})
+}
+
+// findOrCreatePurchase keeps one purchase per subscription period, so a redelivered webhook
+// resolves to the purchase created by the first delivery.
+func (c *Client) findOrCreatePurchase(ctx context.Context, processor payment.Processor, customer *database.Customer, payload *Payload) (int64, error) {
+	externalID := fmt.Sprintf("%d:%d", payload.SubscriptionID, payload.ExpiresAt.Unix())
+
+	existing, err := c.purchaseRepository.FindByExternalID(ctx, database.InvoiceTypeTribute, externalID)
+	if err != nil {
+		return 0, err
+	}
+	if existing != nil {
+		return existing.ID, nil
+	}
+
+	plan, ok := config.GetTributePlan(payload.SubscriptionID, payload.PeriodID)
+	if !ok {
+		slog.Warn("webhook: no plan configured for tribute subscription, guessing from period", "subscriptionId", payload.SubscriptionID, "periodId", payload.PeriodID, "period", payload.Period)
+		plan = config.TributePlan{Months: convertPeriodToMonths(payload.Period), TrafficLimit: config.TrafficLimit()}
+	}
+
+	currency := strings.ToUpper(payload.Currency)
+	if currency == "" {
+		currency = payment.CurrencyRUB
+	}
+
+	_, purchaseId, err := processor.CreatePurchase(ctx, payload.Amount, currency, plan.Months, customer, database.InvoiceTypeTribute)
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.purchaseRepository.UpdateFields(ctx, purchaseId, map[string]interface{}{
+		"external_id":   externalID,
+		"traffic_limit": plan.TrafficLimit,
+	})
+	if err != nil {
+		if cancelErr := processor.CancelPayment(purchaseId); cancelErr != nil {
+			slog.Error("webhook: cancel duplicate purchase error", "error", cancelErr)
+		}
+		return 0, err
+	}
+
+	return purchaseId, nil
+}
+
 func convertPeriodToMonths(period string) int {
 	switch strings.ToLower(period) {
 	case "monthly":
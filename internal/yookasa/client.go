@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"remnawave-tg-shop-bot/internal/config"
 	"strconv"
 	"time"
@@ -16,9 +17,14 @@ import (
 	"github.com/google/uuid"
 )
 
+// paymentListLimit is the largest page YooKassa returns for a payments list.
+const paymentListLimit = 100
+
 type YookasaAPI interface {
 	CreatePayment(ctx context.Context, request PaymentRequest, idempotencyKey string) (*Payment, error)
 	GetPayment(ctx context.Context, paymentID uuid.UUID) (*Payment, error)
+	ListPayments(ctx context.Context, from, to time.Time, cursor string) (*PaymentList, error)
+	CreateRefund(ctx context.Context, request RefundRequest, idempotencyKey string) (*Refund, error)
 }
 
 type Client struct {
@@ -38,61 +44,142 @@ func NewClient(baseURL, shopID, secretKey string) *Client {
 	}
 }
 
-func (c *Client) CreateInvoice(ctx context.Context, amount int, month int, customerId int64, purchaseId int64) (*Payment, error) {
-	rub := Amount{
-		Value:    strconv.Itoa(amount),
-		Currency: "RUB",
-	}
+// CreateInvoice creates a payment confirmed by the payer, savePaymentMethod asks YooKassa to keep the method for recurring payments.
+func (c *Client) CreateInvoice(ctx context.Context, amount int, currency string, description string, receiptCustomer *Customer, customerId int64, purchaseId int64, savePaymentMethod bool) (*Payment, error) {
+	paymentRequest := newPaymentRequest(ctx, amount, currency, description, receiptCustomer, customerId, purchaseId)
+	paymentRequest.SavePaymentMethod = savePaymentMethod
 
-	var monthString string
-	switch month {
-	case 1:
-		monthString = "месяц"
-	case 3, 4:
-		monthString = "месяца"
-	default:
-		monthString = "месяцев"
+	idempotencyKey := uuid.New().String()
+
+	payment, err := c.CreatePayment(ctx, paymentRequest, idempotencyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment: %w", err)
 	}
 
-	description := fmt.Sprintf("Подписка на %d %s", month, monthString)
-	receipt := &Receipt{
-		Customer: &Customer{
-			Email: config.YookasaEmail(),
-		},
-		Items: []Item{
-			{
-				VatCode:        1,
-				Quantity:       "1",
-				Description:    description,
-				Amount:         rub,
-				PaymentSubject: "payment",
-				PaymentMode:    "full_payment",
-			},
-		},
+	return payment, nil
+}
+
+// CreateRecurringPayment charges a saved payment method without user confirmation.
+func (c *Client) CreateRecurringPayment(ctx context.Context, amount int, currency string, description string, receiptCustomer *Customer, customerId int64, purchaseId int64, paymentMethodID uuid.UUID) (*Payment, error) {
+	paymentRequest := newPaymentRequest(ctx, amount, currency, description, receiptCustomer, customerId, purchaseId)
+	paymentRequest.Confirmation = nil
+	paymentRequest.PaymentMethodID = &paymentMethodID
+
+	idempotencyKey := fmt.Sprintf("purchase-%d", purchaseId)
+
+	payment, err := c.CreatePayment(ctx, paymentRequest, idempotencyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recurring payment: %w", err)
 	}
 
+	return payment, nil
+}
+
+func newPaymentRequest(ctx context.Context, amount int, currency string, description string, receiptCustomer *Customer, customerId int64, purchaseId int64) PaymentRequest {
+	price, receipt := newReceipt(amount, currency, description, receiptCustomer)
+
 	metaData := map[string]any{
 		"customerId": customerId,
 		"purchaseId": purchaseId,
 		"username":   ctx.Value("username"),
 	}
 
-	paymentRequest := NewPaymentRequest(
-		rub,
+	return NewPaymentRequest(
+		price,
 		config.BotURL(),
 		description,
 		receipt,
 		metaData,
 	)
+}
 
-	idempotencyKey := uuid.New().String()
+// newReceipt builds a single item receipt with the configured VAT code, payment subject and mode.
+// Receipts without a customer contact go to the shop e-mail.
+func newReceipt(amount int, currency string, description string, receiptCustomer *Customer) (Amount, *Receipt) {
+	price := Amount{
+		Value:    strconv.Itoa(amount),
+		Currency: currency,
+	}
 
-	payment, err := c.CreatePayment(ctx, paymentRequest, idempotencyKey)
+	if receiptCustomer == nil || (receiptCustomer.Email == "" && receiptCustomer.Phone == "") {
+		receiptCustomer = &Customer{Email: config.YookasaEmail()}
+	}
+
+	receipt := &Receipt{
+		Customer: receiptCustomer,
+		Items: []Item{
+			{
+				VatCode:        config.YookasaVatCode(),
+				Quantity:       "1",
+				Description:    description,
+				Amount:         price,
+				PaymentSubject: config.YookasaPaymentSubject(),
+				PaymentMode:    config.YookasaPaymentMode(),
+			},
+		},
+	}
+
+	return price, receipt
+}
+
+// RefundPayment returns the full price of a captured payment, a refund receipt is sent alongside.
+func (c *Client) RefundPayment(ctx context.Context, paymentID uuid.UUID, amount int, currency string, description string, receiptCustomer *Customer, purchaseId int64) (*Refund, error) {
+	price, receipt := newReceipt(amount, currency, description, receiptCustomer)
+
+	refundRequest := RefundRequest{
+		PaymentID:   paymentID,
+		Amount:      price,
+		Description: description,
+		Receipt:     receipt,
+	}
+
+	idempotencyKey := fmt.Sprintf("refund-%d", purchaseId)
+
+	refund, err := c.CreateRefund(ctx, refundRequest, idempotencyKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create payment: %w", err)
+		return nil, fmt.Errorf("failed to create refund: %w", err)
 	}
 
-	return payment, nil
+	return refund, nil
+}
+
+func (c *Client) CreateRefund(ctx context.Context, request RefundRequest, idempotencyKey string) (*Refund, error) {
+	refundURL := fmt.Sprintf("%s/refunds", c.baseURL)
+
+	reqBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal refund request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", refundURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", c.authHeader)
+	req.Header.Set("Idempotence-Key", idempotencyKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading refund resp: %w", err)
+		}
+		return nil, fmt.Errorf("API return error. Status: %d, Body: %s", resp.StatusCode, string(body))
+	}
+
+	var refund Refund
+	if err := json.NewDecoder(resp.Body).Decode(&refund); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &refund, nil
 }
 
 func (c *Client) CreatePayment(ctx context.Context, request PaymentRequest, idempotencyKey string) (*Payment, error) {
@@ -176,3 +263,43 @@ func (c *Client) GetPayment(ctx context.Context, paymentID uuid.UUID) (*Payment,
 
 	return nil, fmt.Errorf("exceeded maximum retries due to 429 Too Many Requests")
 }
+
+// ListPayments returns a page of the payments created in [from, to), the next page is requested with the NextCursor of the previous one.
+func (c *Client) ListPayments(ctx context.Context, from, to time.Time, cursor string) (*PaymentList, error) {
+	query := url.Values{}
+	query.Set("created_at.gte", from.UTC().Format(time.RFC3339))
+	query.Set("created_at.lt", to.UTC().Format(time.RFC3339))
+	query.Set("limit", strconv.Itoa(paymentListLimit))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	paymentsURL := fmt.Sprintf("%s/payments?%s", c.baseURL, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", paymentsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authHeader)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading payments resp: %w", err)
+		}
+		return nil, fmt.Errorf("API return error. Status: %d, Body: %s", resp.StatusCode, string(body))
+	}
+
+	var list PaymentList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &list, nil
+}
@@ -1,40 +1,100 @@
 package yookasa
 
 import (
+	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Payment struct {
-	ID            uuid.UUID         `json:"id,omitempty"`
-	Status        string            `json:"status,omitempty"`
-	Paid          bool              `json:"paid,omitempty"`
-	Amount        Amount            `json:"amount,omitempty"`
-	Confirmation  ConfirmationType  `json:"confirmation,omitempty"`
-	CreatedAt     time.Time         `json:"created_at,omitempty"`
-	ExpiresAt     time.Time         `json:"expires_at,omitempty"`
-	Description   string            `json:"description,omitempty"`
-	Metadata      map[string]string `json:"metadata,omitempty"`
-	Recipient     RecipientType     `json:"recipient,omitempty"`
-	PaymentMethod PaymentType       `json:"payment_method,omitempty"`
-	Refundable    bool              `json:"refundable,omitempty"`
-	Test          bool              `json:"test,omitempty"`
-	RedirectURL   string            `json:"redirect_url,omitempty"`
+	ID             uuid.UUID         `json:"id,omitempty"`
+	Status         string            `json:"status,omitempty"`
+	Paid           bool              `json:"paid,omitempty"`
+	Amount         Amount            `json:"amount,omitempty"`
+	Confirmation   ConfirmationType  `json:"confirmation,omitempty"`
+	CreatedAt      time.Time         `json:"created_at,omitempty"`
+	ExpiresAt      time.Time         `json:"expires_at,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Recipient      RecipientType     `json:"recipient,omitempty"`
+	PaymentMethod  PaymentType       `json:"payment_method,omitempty"`
+	Refundable     bool              `json:"refundable,omitempty"`
+	RefundedAmount *Amount           `json:"refunded_amount,omitempty"`
+	Test           bool              `json:"test,omitempty"`
+	RedirectURL    string            `json:"redirect_url,omitempty"`
+}
+
+// PaymentList is a page of payments, NextCursor is empty on the last page.
+type PaymentList struct {
+	Type       string    `json:"type"`
+	Items      []Payment `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 func (p *Payment) IsCancelled() bool {
 	return p.Status == "canceled"
 }
 
+func (p *Payment) IsRefunded() bool {
+	return p.RefundedAmount != nil && p.RefundedAmount.Value != "" && p.RefundedAmount.Value != "0.00"
+}
+
+func (p *Payment) IsFullyRefunded() bool {
+	if !p.IsRefunded() {
+		return false
+	}
+	refunded, err := strconv.ParseFloat(p.RefundedAmount.Value, 64)
+	if err != nil {
+		return false
+	}
+	amount, err := strconv.ParseFloat(p.Amount.Value, 64)
+	if err != nil {
+		return false
+	}
+	return refunded >= amount
+}
+
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentCanceled  = "payment.canceled"
+	EventRefundSucceeded  = "refund.succeeded"
+)
+
+type Notification struct {
+	Type   string          `json:"type"`
+	Event  string          `json:"event"`
+	Object json.RawMessage `json:"object"`
+}
+
+type Refund struct {
+	ID        uuid.UUID `json:"id"`
+	PaymentID uuid.UUID `json:"payment_id"`
+	Status    string    `json:"status"`
+	Amount    Amount    `json:"amount"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+func (r *Refund) IsSucceeded() bool {
+	return r.Status == "succeeded"
+}
+
+type RefundRequest struct {
+	PaymentID   uuid.UUID `json:"payment_id"`
+	Amount      Amount    `json:"amount"`
+	Description string    `json:"description,omitempty"`
+	Receipt     *Receipt  `json:"receipt,omitempty"`
+}
+
 type PaymentRequest struct {
 	Amount            Amount             `json:"amount"`
-	Confirmation      ConfirmationType   `json:"confirmation"`
+	Confirmation      *ConfirmationType  `json:"confirmation,omitempty"`
 	Capture           bool               `json:"capture"`
 	Description       string             `json:"description,omitempty"`
 	PaymentMethodData *PaymentMethodData `json:"payment_method_data,omitempty"`
 	SavePaymentMethod bool               `json:"save_payment_method"`
-	PaymentMethodID   *uuid.UUID         `json:"payment_method_id"`
+	PaymentMethodID   *uuid.UUID         `json:"payment_method_id,omitempty"`
 	Receipt           *Receipt           `json:"receipt,omitempty"`
 	Metadata          map[string]any     `json:"metadata,omitempty"`
 }
@@ -49,7 +109,7 @@ func NewPaymentRequest(
 		Amount:   amount,
 		Receipt:  receipt,
 		Metadata: metadata,
-		Confirmation: ConfirmationType{
+		Confirmation: &ConfirmationType{
 			Type:      "redirect",
 			ReturnURL: urlRedirect,
 		},
@@ -64,8 +124,10 @@ type Receipt struct {
 	Customer *Customer `json:"customer,omitempty"`
 }
 
+// Customer is who the receipt is sent to, YooKassa requires either an e-mail or a phone in the 79000000000 format.
 type Customer struct {
-	Email string `json:"email"`
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
 }
 
 type Item struct {
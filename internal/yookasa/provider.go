@@ -0,0 +1,173 @@
+package yookasa
+
+import (
+	"context"
+	"fmt"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/internal/translation"
+	"strconv"
+	"time"
+)
+
+type Provider struct {
+	client      *Client
+	translation *translation.Manager
+}
+
+func NewProvider(client *Client, translation *translation.Manager) *Provider {
+	return &Provider{client: client, translation: translation}
+}
+
+func (p *Provider) Type() database.InvoiceType {
+	return database.InvoiceTypeYookasa
+}
+
+func (p *Provider) ButtonKey() string {
+	return "card_button"
+}
+
+func (p *Provider) Currencies() []string {
+	return config.YookasaCurrencies()
+}
+
+// PollSchedule falls back to polling every five minutes once notifications arrive through the webhook.
+func (p *Provider) PollSchedule() string {
+	if config.YookasaWebhookUrl() != "" {
+		return "0 */5 * * * *"
+	}
+	return "*/5 * * * * *"
+}
+
+func (p *Provider) CreateInvoice(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*payment.Invoice, error) {
+	invoice, err := p.client.CreateInvoice(ctx, int(purchase.Amount), purchase.Currency, p.receiptDescription(purchase), receiptCustomer(customer), customer.ID, purchase.ID, purchase.AutoRenew)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment.Invoice{
+		URL: invoice.Confirmation.ConfirmationURL,
+		Fields: map[string]interface{}{
+			"yookasa_url": invoice.Confirmation.ConfirmationURL,
+			"yookasa_id":  invoice.ID,
+		},
+	}, nil
+}
+
+func (p *Provider) CheckStatus(ctx context.Context, purchase *database.Purchase) (*payment.InvoiceState, error) {
+	if purchase.YookasaID == nil {
+		return nil, fmt.Errorf("purchase %d has no yookasa payment", purchase.ID)
+	}
+
+	invoice, err := p.client.GetPayment(ctx, *purchase.YookasaID)
+	if err != nil {
+		return nil, err
+	}
+
+	if invoice.IsCancelled() {
+		return &payment.InvoiceState{Status: payment.InvoiceStatusCanceled}, nil
+	}
+
+	if !invoice.Paid {
+		return &payment.InvoiceState{Status: payment.InvoiceStatusPending}, nil
+	}
+
+	state := &payment.InvoiceState{Status: payment.InvoiceStatusPaid, Username: invoice.Metadata["username"]}
+	if invoice.PaymentMethod.Saved {
+		state.PaymentMethodID = &invoice.PaymentMethod.ID
+	}
+	return state, nil
+}
+
+func (p *Provider) ChargeSavedMethod(ctx context.Context, purchase *database.Purchase, customer *database.Customer) (*payment.Invoice, payment.InvoiceStatus, error) {
+	if customer.PaymentMethodID == nil {
+		return nil, "", fmt.Errorf("customer %d has no saved payment method", customer.ID)
+	}
+
+	invoice, err := p.client.CreateRecurringPayment(ctx, int(purchase.Amount), purchase.Currency, p.receiptDescription(purchase), receiptCustomer(customer), customer.ID, purchase.ID, *customer.PaymentMethodID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := &payment.Invoice{
+		Fields: map[string]interface{}{
+			"yookasa_id": invoice.ID,
+		},
+	}
+
+	switch {
+	case invoice.IsCancelled():
+		return result, payment.InvoiceStatusCanceled, nil
+	case invoice.Paid:
+		return result, payment.InvoiceStatusPaid, nil
+	default:
+		return result, payment.InvoiceStatusPending, nil
+	}
+}
+
+func (p *Provider) Refund(ctx context.Context, purchase *database.Purchase, customer *database.Customer) error {
+	if purchase.YookasaID == nil {
+		return fmt.Errorf("purchase %d has no yookasa payment", purchase.ID)
+	}
+
+	refund, err := p.client.RefundPayment(ctx, *purchase.YookasaID, int(purchase.Amount), purchase.Currency, p.receiptDescription(purchase), receiptCustomer(customer), purchase.ID)
+	if err != nil {
+		return err
+	}
+	if refund.Status == "canceled" {
+		return fmt.Errorf("refund %s of purchase %d was canceled", refund.ID, purchase.ID)
+	}
+	return nil
+}
+
+// ListPayments pages through the YooKassa payments of the period, paid ones include refunded payments.
+func (p *Provider) ListPayments(ctx context.Context, from, to time.Time) ([]payment.ProviderPayment, error) {
+	var payments []payment.ProviderPayment
+	cursor := ""
+	for {
+		list, err := p.client.ListPayments(ctx, from, to, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Items {
+			purchaseID, _ := strconv.ParseInt(item.Metadata["purchaseId"], 10, 64)
+			payments = append(payments, payment.ProviderPayment{
+				ID:         item.ID.String(),
+				PurchaseID: purchaseID,
+				Paid:       item.Paid,
+				Amount:     item.Amount.Value,
+				Currency:   item.Amount.Currency,
+				CreatedAt:  item.CreatedAt,
+			})
+		}
+
+		if list.NextCursor == "" {
+			return payments, nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+// Cancel is a no-op, payments are captured automatically and unpaid ones expire on the YooKassa side.
+func (p *Provider) Cancel(ctx context.Context, purchase *database.Purchase) error {
+	return nil
+}
+
+// receiptDescription names the purchased item on the receipt in the configured receipt language.
+func (p *Provider) receiptDescription(purchase *database.Purchase) string {
+	return payment.Description(p.translation, config.YookasaReceiptLanguage(), purchase)
+}
+
+// receiptCustomer is the contact the customer left for receipts, nil falls back to the shop e-mail.
+func receiptCustomer(customer *database.Customer) *Customer {
+	switch {
+	case customer.ReceiptEmail != nil:
+		return &Customer{Email: *customer.ReceiptEmail}
+	case customer.ReceiptPhone != nil:
+		return &Customer{Phone: *customer.ReceiptPhone}
+	default:
+		return nil
+	}
+}
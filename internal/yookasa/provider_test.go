@@ -0,0 +1,73 @@
+package yookasa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"remnawave-tg-shop-bot/internal/database"
+	"remnawave-tg-shop-bot/internal/payment"
+	"remnawave-tg-shop-bot/internal/translation"
+)
+
+// fakeRecurringPayments answers a saved method charge with a pending payment that has succeeded once it is looked up.
+func fakeRecurringPayments(t *testing.T, paymentID uuid.UUID, methodID uuid.UUID) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/payments":
+			var request PaymentRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Errorf("decode payment request: %v", err)
+			}
+			if request.PaymentMethodID == nil || *request.PaymentMethodID != methodID {
+				t.Errorf("payment method = %v, want %s", request.PaymentMethodID, methodID)
+			}
+			json.NewEncoder(w).Encode(Payment{ID: paymentID, Status: "pending"})
+		case r.Method == http.MethodGet && r.URL.Path == "/payments/"+paymentID.String():
+			json.NewEncoder(w).Encode(Payment{ID: paymentID, Status: "succeeded", Paid: true, Metadata: map[string]string{"username": "alice"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestChargeSavedMethodPendingThenSucceeded(t *testing.T) {
+	paymentID := uuid.New()
+	methodID := uuid.New()
+	server := fakeRecurringPayments(t, paymentID, methodID)
+	defer server.Close()
+
+	provider := NewProvider(NewClient(server.URL, "shop", "secret"), translation.GetInstance())
+	days := 30
+	purchase := &database.Purchase{ID: 7, Amount: 199, Currency: payment.CurrencyRUB, Month: 1, DurationDays: &days, Kind: database.PurchaseKindSubscription}
+	customer := &database.Customer{ID: 3, PaymentMethodID: &methodID}
+
+	invoice, status, err := provider.ChargeSavedMethod(context.Background(), purchase, customer)
+	if err != nil {
+		t.Fatalf("ChargeSavedMethod: %v", err)
+	}
+	if status != payment.InvoiceStatusPending {
+		t.Fatalf("status = %s, want %s", status, payment.InvoiceStatusPending)
+	}
+	if id, ok := invoice.Fields["yookasa_id"].(uuid.UUID); !ok || id != paymentID {
+		t.Fatalf("yookasa_id = %v, want %s", invoice.Fields["yookasa_id"], paymentID)
+	}
+
+	// the renewal keeps the payment id and is settled by the next status check
+	purchase.YookasaID = &paymentID
+	state, err := provider.CheckStatus(context.Background(), purchase)
+	if err != nil {
+		t.Fatalf("CheckStatus: %v", err)
+	}
+	if state.Status != payment.InvoiceStatusPaid {
+		t.Errorf("status = %s, want %s", state.Status, payment.InvoiceStatusPaid)
+	}
+	if state.Username != "alice" {
+		t.Errorf("username = %q, want %q", state.Username, "alice")
+	}
+}
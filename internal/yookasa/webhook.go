@@ -0,0 +1,194 @@
+package yookasa
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"remnawave-tg-shop-bot/internal/config"
+	"remnawave-tg-shop-bot/internal/payment"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notificationNetworks are the addresses YooKassa sends HTTP notifications from,
+// see https://yookassa.ru/developers/using-api/webhooks#ip
+var notificationNetworks = mustParseNetworks(
+	"185.71.76.0/27",
+	"185.71.77.0/27",
+	"77.75.153.0/25",
+	"77.75.156.11/32",
+	"77.75.156.35/32",
+	"77.75.154.128/25",
+	"2a02:5180::/32",
+)
+
+func (p *Provider) WebhookPath() string {
+	return config.YookasaWebhookUrl()
+}
+
+func (p *Provider) WebhookHandler(processor payment.Processor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*60)
+		defer cancel()
+
+		ip := clientIP(r, config.YookasaWebhookTrustProxy())
+		if !isNotificationIP(ip) {
+			slog.Warn("yookasa webhook: request from untrusted address", "ip", ip)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			slog.Error("yookasa webhook: read body error", "error", err)
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		var notification Notification
+		if err := json.Unmarshal(body, &notification); err != nil {
+			slog.Error("yookasa webhook: unmarshal error", "error", err)
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		switch notification.Event {
+		case EventPaymentSucceeded, EventPaymentCanceled:
+			var object Payment
+			if err := json.Unmarshal(notification.Object, &object); err != nil {
+				slog.Error("yookasa webhook: unmarshal payment error", "error", err)
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			err = p.handlePaymentEvent(ctx, processor, &object)
+		case EventRefundSucceeded:
+			var object Refund
+			if err := json.Unmarshal(notification.Object, &object); err != nil {
+				slog.Error("yookasa webhook: unmarshal refund error", "error", err)
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			err = p.handleRefundEvent(ctx, processor, &object)
+		default:
+			slog.Info("yookasa webhook: skipping event", "event", notification.Event)
+		}
+
+		if err != nil {
+			slog.Error("yookasa webhook: processing error", "event", notification.Event, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// handlePaymentEvent acts on the payment as returned by the API, the notification body is only used for its id.
+func (p *Provider) handlePaymentEvent(ctx context.Context, processor payment.Processor, object *Payment) error {
+	invoice, err := p.client.GetPayment(ctx, object.ID)
+	if err != nil {
+		return err
+	}
+
+	purchaseId, err := strconv.ParseInt(invoice.Metadata["purchaseId"], 10, 64)
+	if err != nil {
+		slog.Warn("yookasa webhook: payment without purchase", "paymentId", invoice.ID)
+		return nil
+	}
+
+	if invoice.IsCancelled() {
+		return processor.CancelPayment(purchaseId)
+	}
+
+	if !invoice.Paid {
+		return nil
+	}
+
+	if invoice.PaymentMethod.Saved {
+		err = processor.SavePaymentMethod(ctx, purchaseId, invoice.PaymentMethod.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctxWithUsername := context.WithValue(ctx, "username", invoice.Metadata["username"])
+	err = processor.ProcessPurchaseById(ctxWithUsername, purchaseId)
+	if err != nil {
+		return err
+	}
+	slog.Info("Invoice processed", "invoiceId", invoice.ID, "purchaseId", purchaseId)
+	return nil
+}
+
+// handleRefundEvent rolls back the subscription of refunds made outside the bot, e.g. from the YooKassa dashboard.
+// Refunds issued by /refund are already applied and are skipped by the processor.
+func (p *Provider) handleRefundEvent(ctx context.Context, processor payment.Processor, object *Refund) error {
+	invoice, err := p.client.GetPayment(ctx, object.PaymentID)
+	if err != nil {
+		return err
+	}
+
+	if !invoice.IsFullyRefunded() {
+		if invoice.IsRefunded() {
+			slog.Warn("yookasa webhook: payment partially refunded", "paymentId", invoice.ID, "purchaseId", invoice.Metadata["purchaseId"], "refunded", invoice.RefundedAmount.Value)
+		}
+		return nil
+	}
+
+	purchaseId, err := strconv.ParseInt(invoice.Metadata["purchaseId"], 10, 64)
+	if err != nil {
+		slog.Warn("yookasa webhook: refund without purchase", "paymentId", invoice.ID)
+		return nil
+	}
+
+	return processor.ApplyRefund(ctx, purchaseId)
+}
+
+// clientIP is the address the request came from. Behind a trusted proxy it is the rightmost X-Forwarded-For entry,
+// the one the proxy appended, entries left of it are sent by the client and can be forged.
+func clientIP(r *http.Request, trustProxy bool) net.IP {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			entries := strings.Split(forwarded[len(forwarded)-1], ",")
+			return net.ParseIP(strings.TrimSpace(entries[len(entries)-1]))
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return net.ParseIP(strings.TrimSpace(realIP))
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return net.ParseIP(r.RemoteAddr)
+	}
+	return net.ParseIP(host)
+}
+
+func isNotificationIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range notificationNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
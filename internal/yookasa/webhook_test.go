@@ -0,0 +1,49 @@
+package yookasa
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"remote address without proxy", false, nil, "", "10.0.0.1"},
+		{"forwarded header ignored without proxy", false, []string{"185.71.76.1"}, "", "10.0.0.1"},
+		{"entry appended by the proxy", true, []string{"185.71.76.1"}, "", "185.71.76.1"},
+		{"forged leading entry", true, []string{"185.71.76.1, 203.0.113.7"}, "", "203.0.113.7"},
+		{"last of several headers", true, []string{"185.71.76.1", "203.0.113.7"}, "", "203.0.113.7"},
+		{"real ip without forwarded header", true, nil, "185.71.77.2", "185.71.77.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/yookasa", nil)
+			r.RemoteAddr = "10.0.0.1:5432"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := clientIP(r, tt.trustProxy); got.String() != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForgedForwardedForIsRejected(t *testing.T) {
+	r := httptest.NewRequest("POST", "/yookasa", nil)
+	r.Header.Set("X-Forwarded-For", "185.71.76.1, 203.0.113.7")
+
+	if ip := clientIP(r, true); isNotificationIP(ip) {
+		t.Errorf("forged X-Forwarded-For passed as YooKassa address %s", ip)
+	}
+}